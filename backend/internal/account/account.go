@@ -0,0 +1,263 @@
+// Package account implements self-service account management: profile
+// changes, account deletion and data export.
+package account
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"battleship-go/internal/auth"
+	"battleship-go/internal/events"
+	"battleship-go/internal/game"
+	"battleship-go/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// redactedMessage replaces the chat messages of deleted accounts.
+const redactedMessage = "[deleted]"
+
+var (
+	// ErrUsernameTaken is returned when the requested username belongs to another user.
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrEmailTaken is returned when the requested email belongs to another user.
+	ErrEmailTaken = errors.New("email is already in use")
+)
+
+type AccountService struct {
+	db          *sql.DB
+	authService *auth.AuthService
+	gameService *game.GameService
+	events      *events.Log
+}
+
+func NewAccountService(db *sql.DB, authService *auth.AuthService, gameService *game.GameService) *AccountService {
+	return &AccountService{db: db, authService: authService, gameService: gameService}
+}
+
+// SetEventLog sets the game event log whose cached chat is dropped when an
+// account is deleted.
+func (s *AccountService) SetEventLog(log *events.Log) {
+	s.events = log
+}
+
+// ChangeUsername renames the user.
+func (s *AccountService) ChangeUsername(userID int, username string) (*models.User, error) {
+	if err := auth.ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 AND id != $2)", username, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrUsernameTaken
+	}
+
+	_, err = s.db.Exec("UPDATE users SET username = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", username, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.authService.GetUserByID(userID)
+}
+
+// ChangeEmail sets a new email address after confirming the password. The new
+// address is unverified until the user follows the link sent to it. Links
+// already mailed to the old address stop working.
+func (s *AccountService) ChangeEmail(userID int, email, password string) (*models.User, error) {
+	if err := s.authService.VerifyPassword(userID, password); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1 AND id != $2)", email, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrEmailTaken
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET email = $1, email_verified_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, email, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A verification link for the old address would otherwise verify the new one
+	_, err = tx.Exec(`
+		UPDATE auth_tokens SET used_at = $1
+		WHERE user_id = $2 AND used_at IS NULL`, time.Now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.authService.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.SendEmailVerification(user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	return user, nil
+}
+
+// ChangePassword replaces the password after checking the current one. All
+// tokens issued before the change are revoked.
+func (s *AccountService) ChangePassword(userID int, currentPassword, newPassword string) error {
+	if err := s.authService.VerifyPassword(userID, currentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users SET password_hash = $1, password_changed_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`, string(hashedPassword), time.Now(), userID)
+	return err
+}
+
+// DeleteAccount anonymizes the user instead of deleting the row, so that
+// games, moves and chat messages keep valid references. Personal data, login
+// methods and chat contents are removed, unfinished games are forfeited and
+// all tokens are revoked. Guests, who have no password, are deleted without one.
+func (s *AccountService) DeleteAccount(userID int, password string) error {
+	user, err := s.authService.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.IsGuest {
+		if err := s.authService.VerifyPassword(userID, password); err != nil {
+			return err
+		}
+	}
+	return s.AnonymizeUser(userID)
+}
+
+// AnonymizeUser is DeleteAccount without the password check. The cleanup
+// uses it for expired guests that played.
+func (s *AccountService) AnonymizeUser(userID int) error {
+	if err := s.closeGames(userID); err != nil {
+		return err
+	}
+
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	placeholder := auth.DeletedUsernamePrefix + hex.EncodeToString(raw)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		UPDATE users SET username = $1, email = $2, password_hash = '', email_verified_at = NULL,
+		       totp_secret = NULL, totp_enabled_at = NULL, password_changed_at = $3, deleted_at = $3,
+		       updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`, placeholder, placeholder+"@deleted.invalid", now, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM auth_tokens WHERE user_id = $1",
+		"DELETE FROM recovery_codes WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM friendships WHERE requester_id = $1 OR addressee_id = $1",
+		"DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1",
+		"DELETE FROM notifications WHERE user_id = $1",
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE chat_messages SET message = $1 WHERE player_id = $2", redactedMessage, userID); err != nil {
+		return err
+	}
+	// Game chat is also kept in the event log for replay
+	gameIDs, err := events.RedactChat(tx, userID, redactedMessage)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if s.events != nil {
+		s.events.Forget(gameIDs...)
+	}
+	return nil
+}
+
+// closeGames forfeits the user's active games to the opponent and removes
+// games still waiting for an opponent, including invites sent to the user.
+func (s *AccountService) closeGames(userID int) error {
+	rows, err := s.db.Query(`
+		SELECT id, player1_id, player2_id, status FROM games
+		WHERE (player1_id = $1 OR player2_id = $1 OR (invited_player_id = $1 AND player2_id IS NULL))
+		  AND status IN ($2, $3)`, userID, models.GameStatusWaiting, models.GameStatusActive)
+	if err != nil {
+		return err
+	}
+
+	var games []models.Game
+	for rows.Next() {
+		var g models.Game
+		if err := rows.Scan(&g.ID, &g.Player1ID, &g.Player2ID, &g.Status); err != nil {
+			rows.Close()
+			return err
+		}
+		games = append(games, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, g := range games {
+		if g.Player2ID == nil {
+			if err := s.gameService.DeleteGame(g.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		opponent := g.Player1ID
+		if opponent == userID {
+			opponent = *g.Player2ID
+		}
+		if err := s.gameService.FinishGame(g.ID, &opponent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package account
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"testing"
+
+	"battleship-go/internal/auth"
+	"battleship-go/internal/events"
+	"battleship-go/internal/game"
+	"battleship-go/internal/mail"
+	"battleship-go/internal/models"
+	"battleship-go/internal/protocol"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`token=([0-9a-f]{64})`)
+
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	require.NotEmpty(t, m.sent)
+	match := tokenPattern.FindStringSubmatch(m.sent[len(m.sent)-1].Body)
+	require.Len(t, match, 2)
+	return match[1]
+}
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			email TEXT UNIQUE NOT NULL,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'player',
+			banned_at DATETIME,
+			ban_reason TEXT,
+			email_verified_at DATETIME,
+			password_changed_at DATETIME,
+			totp_secret TEXT,
+			totp_enabled_at DATETIME,
+			totp_last_step INTEGER NOT NULL DEFAULT 0,
+			is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+			deleted_at DATETIME,
+			failed_login_count INTEGER NOT NULL DEFAULT 0,
+			locked_until DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE games (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player1_id INTEGER NOT NULL,
+			player2_id INTEGER,
+			status TEXT DEFAULT 'waiting',
+			current_turn INTEGER,
+			winner_id INTEGER,
+			invited_player_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE ships (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL,
+			player_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			start_x INTEGER NOT NULL,
+			start_y INTEGER NOT NULL,
+			end_x INTEGER NOT NULL,
+			end_y INTEGER NOT NULL,
+			is_vertical BOOLEAN NOT NULL,
+			is_sunk BOOLEAN DEFAULT FALSE
+		);
+
+		CREATE TABLE moves (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL,
+			player_id INTEGER NOT NULL,
+			x INTEGER NOT NULL,
+			y INTEGER NOT NULL,
+			is_hit BOOLEAN NOT NULL,
+			ship_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE chat_channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER
+		);
+
+		CREATE TABLE chat_read_markers (
+			channel_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE quick_chat_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE chat_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE chat_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL DEFAULT 0,
+			game_id INTEGER,
+			player_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE game_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			channel_id INTEGER,
+			sender_id INTEGER,
+			data TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (game_id, seq)
+		);
+
+		CREATE TABLE scores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_id INTEGER UNIQUE NOT NULL,
+			wins INTEGER DEFAULT 0,
+			losses INTEGER DEFAULT 0,
+			hits INTEGER DEFAULT 0,
+			misses INTEGER DEFAULT 0,
+			points INTEGER DEFAULT 0
+		);
+
+		CREATE TABLE auth_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			purpose TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, subject)
+		);
+
+		CREATE TABLE friendships (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			requester_id INTEGER NOT NULL,
+			addressee_id INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			responded_at DATETIME,
+			UNIQUE (requester_id, addressee_id)
+		);
+
+		CREATE TABLE user_blocks (
+			blocker_id INTEGER NOT NULL,
+			blocked_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_id, blocked_id)
+		);
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func setupServices(t *testing.T) (*sql.DB, *auth.AuthService, *AccountService) {
+	db := setupTestDB(t)
+	authService := auth.NewAuthService(db, "test-secret")
+	service := NewAccountService(db, authService, game.NewGameService(db))
+	return db, authService, service
+}
+
+func TestAccountService_ChangeProfile(t *testing.T) {
+	db, authService, service := setupServices(t)
+	defer db.Close()
+
+	alice, err := authService.Register("alice", "alice@example.com", "password123")
+	require.NoError(t, err)
+	_, err = authService.Register("bob", "bob@example.com", "password123")
+	require.NoError(t, err)
+
+	t.Run("username", func(t *testing.T) {
+		_, err := service.ChangeUsername(alice.ID, "bob")
+		assert.ErrorIs(t, err, ErrUsernameTaken)
+
+		_, err = service.ChangeUsername(alice.ID, "no spaces")
+		assert.Error(t, err)
+
+		_, err = service.ChangeUsername(alice.ID, "deleted-abc")
+		assert.Error(t, err)
+
+		user, err := service.ChangeUsername(alice.ID, "alice_2")
+		require.NoError(t, err)
+		assert.Equal(t, "alice_2", user.Username)
+	})
+
+	t.Run("email requires password and resets verification", func(t *testing.T) {
+		_, err := db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = $1", alice.ID)
+		require.NoError(t, err)
+
+		_, err = service.ChangeEmail(alice.ID, "new@example.com", "wrong")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+		_, err = service.ChangeEmail(alice.ID, "bob@example.com", "password123")
+		assert.ErrorIs(t, err, ErrEmailTaken)
+
+		user, err := service.ChangeEmail(alice.ID, "new@example.com", "password123")
+		require.NoError(t, err)
+		assert.Equal(t, "new@example.com", user.Email)
+		assert.Nil(t, user.EmailVerifiedAt)
+	})
+
+	t.Run("email change invalidates outstanding links", func(t *testing.T) {
+		mailer := &recordingMailer{}
+		authService.SetMailer(mailer, "https://battleship.example")
+
+		user, err := authService.GetUserByID(alice.ID)
+		require.NoError(t, err)
+		require.NoError(t, authService.SendEmailVerification(user))
+		oldVerification := mailer.lastToken(t)
+		require.NoError(t, authService.RequestPasswordReset(user.Email))
+		oldReset := mailer.lastToken(t)
+
+		_, err = service.ChangeEmail(alice.ID, "newer@example.com", "password123")
+		require.NoError(t, err)
+		newVerification := mailer.lastToken(t)
+
+		assert.ErrorIs(t, authService.VerifyEmail(oldVerification), auth.ErrInvalidToken)
+		assert.ErrorIs(t, authService.ResetPassword(oldReset, "hijacked1"), auth.ErrInvalidToken)
+		user, err = authService.GetUserByID(alice.ID)
+		require.NoError(t, err)
+		assert.Nil(t, user.EmailVerifiedAt)
+
+		// The link sent to the new address still works
+		require.NoError(t, authService.VerifyEmail(newVerification))
+		user, err = authService.GetUserByID(alice.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, user.EmailVerifiedAt)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		err := service.ChangePassword(alice.ID, "wrong", "newpassword")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+		require.NoError(t, service.ChangePassword(alice.ID, "password123", "newpassword"))
+
+		_, _, err = authService.Login("alice_2", "newpassword")
+		assert.NoError(t, err)
+
+		// Existing tokens are revoked through the change timestamp
+		user, err := authService.GetUserByID(alice.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, user.PasswordChangedAt)
+	})
+}
+
+func TestAccountService_DeleteAccount(t *testing.T) {
+	db, authService, service := setupServices(t)
+	defer db.Close()
+
+	alice, err := authService.Register("alice", "alice@example.com", "password123")
+	require.NoError(t, err)
+	bob, err := authService.Register("bob", "bob@example.com", "password123")
+	require.NoError(t, err)
+
+	// One active game against bob and one still waiting for an opponent
+	_, err = db.Exec(`INSERT INTO games (player1_id, player2_id, status, current_turn) VALUES ($1, $2, $3, $1)`,
+		alice.ID, bob.ID, models.GameStatusActive)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO games (player1_id, status) VALUES ($1, $2)`, alice.ID, models.GameStatusWaiting)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO chat_messages (game_id, player_id, message) VALUES (1, $1, 'my phone is 555-1234')`, alice.ID)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO user_identities (user_id, provider, subject) VALUES ($1, 'github', '42')`, alice.ID)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO friendships (requester_id, addressee_id, status) VALUES ($1, $2, 'accepted')`, alice.ID, bob.ID)
+	require.NoError(t, err)
+
+	// The game chat is also in the event log, replayed from memory
+	eventLog := events.NewLog(db, events.DefaultBufferSize, events.DefaultMaxReplay)
+	service.SetEventLog(eventLog)
+	for _, sender := range []int{alice.ID, bob.ID} {
+		chat := protocol.New(protocol.GameChat{ChatMessage: models.ChatMessage{PlayerID: sender, Message: "my phone is 555-1234"}})
+		chat.Sender = sender
+		require.NoError(t, eventLog.Append(1, chat))
+	}
+
+	assert.ErrorIs(t, service.DeleteAccount(alice.ID, "wrong"), auth.ErrInvalidCredentials)
+	require.NoError(t, service.DeleteAccount(alice.ID, "password123"))
+
+	user, err := authService.GetUserByID(alice.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, user.DeletedAt)
+	assert.True(t, strings.HasPrefix(user.Username, auth.DeletedUsernamePrefix))
+	assert.NotContains(t, user.Email, "alice")
+
+	_, _, err = authService.Login("alice", "password123")
+	assert.Error(t, err)
+
+	var status string
+	var winnerID int
+	require.NoError(t, db.QueryRow("SELECT status, winner_id FROM games WHERE id = 1").Scan(&status, &winnerID))
+	assert.Equal(t, models.GameStatusFinished, status)
+	assert.Equal(t, bob.ID, winnerID)
+
+	var waiting int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM games WHERE id = 2").Scan(&waiting))
+	assert.Zero(t, waiting)
+
+	var message string
+	require.NoError(t, db.QueryRow("SELECT message FROM chat_messages WHERE player_id = $1", alice.ID).Scan(&message))
+	assert.Equal(t, redactedMessage, message)
+
+	replay, err := eventLog.Since(1, 0)
+	require.NoError(t, err)
+	require.Len(t, replay, 2)
+	assert.Equal(t, redactedMessage, replay[0].Data.(protocol.GameChat).Message)
+	assert.Equal(t, "my phone is 555-1234", replay[1].Data.(protocol.GameChat).Message, "other players' chat is kept")
+
+	var identities int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM user_identities").Scan(&identities))
+	assert.Zero(t, identities)
+
+	var friendships int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM friendships").Scan(&friendships))
+	assert.Zero(t, friendships)
+}
+
+func TestAccountService_Export(t *testing.T) {
+	db, authService, service := setupServices(t)
+	defer db.Close()
+
+	alice, err := authService.Register("alice", "alice@example.com", "password123")
+	require.NoError(t, err)
+	bob, err := authService.Register("bob", "bob@example.com", "password123")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO games (player1_id, player2_id, status) VALUES ($1, $2, $3)`,
+		alice.ID, bob.ID, models.GameStatusActive)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO moves (game_id, player_id, x, y, is_hit) VALUES (1, $1, 0, 0, TRUE), (1, $2, 1, 1, FALSE)`,
+		alice.ID, bob.ID)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO chat_messages (game_id, player_id, message) VALUES (1, $1, 'hi'), (1, $2, 'hello')`,
+		alice.ID, bob.ID)
+	require.NoError(t, err)
+
+	export, err := service.Export(alice.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice", export.User.Username)
+	require.NotNil(t, export.Stats)
+	assert.Len(t, export.Games, 1)
+	require.Len(t, export.Moves, 1)
+	assert.Equal(t, alice.ID, export.Moves[0].PlayerID)
+	require.Len(t, export.Chat, 1)
+	assert.Equal(t, "hi", export.Chat[0].Message)
+	assert.Empty(t, export.Identities)
+}
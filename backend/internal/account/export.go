@@ -0,0 +1,119 @@
+package account
+
+import (
+	"time"
+
+	"battleship-go/internal/models"
+)
+
+// Identity is an external login linked to the account.
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     *string   `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export is the archive of everything stored about a user.
+type Export struct {
+	ExportedAt time.Time            `json:"exported_at"`
+	User       *models.User         `json:"user"`
+	Stats      *models.Score        `json:"stats"`
+	Identities []Identity           `json:"identities"`
+	Games      []models.Game        `json:"games"`
+	Moves      []models.Move        `json:"moves"`
+	Chat       []models.ChatMessage `json:"chat"`
+}
+
+// Export collects the user's profile, stats, linked logins, games and their
+// own moves and chat messages.
+func (s *AccountService) Export(userID int) (*Export, error) {
+	user, err := s.authService.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{
+		ExportedAt: time.Now(),
+		User:       user,
+		Identities: make([]Identity, 0),
+		Games:      make([]models.Game, 0),
+		Moves:      make([]models.Move, 0),
+		Chat:       make([]models.ChatMessage, 0),
+	}
+
+	var score models.Score
+	err = s.db.QueryRow(`
+		SELECT id, player_id, wins, losses, hits, misses, points
+		FROM scores WHERE player_id = $1`, userID).Scan(
+		&score.ID, &score.PlayerID, &score.Wins, &score.Losses, &score.Hits, &score.Misses, &score.Points)
+	if err == nil {
+		export.Stats = &score
+	}
+
+	rows, err := s.db.Query(`
+		SELECT provider, subject, email, created_at FROM user_identities
+		WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Identities = append(export.Identities, identity)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at
+		FROM games WHERE player1_id = $1 OR player2_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var g models.Game
+		if err := rows.Scan(&g.ID, &g.Player1ID, &g.Player2ID, &g.Status, &g.CurrentTurn,
+			&g.WinnerID, &g.CreatedAt, &g.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Games = append(export.Games, g)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT id, game_id, player_id, x, y, is_hit, ship_id, created_at
+		FROM moves WHERE player_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m models.Move
+		if err := rows.Scan(&m.ID, &m.GameID, &m.PlayerID, &m.X, &m.Y, &m.IsHit, &m.ShipID, &m.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Moves = append(export.Moves, m)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT id, channel_id, game_id, player_id, message, created_at
+		FROM chat_messages WHERE player_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg models.ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.ChannelID, &msg.GameID, &msg.PlayerID, &msg.Message, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.Chat = append(export.Chat, msg)
+	}
+
+	return export, rows.Err()
+}
@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"battleship-go/internal/game"
+	"battleship-go/internal/models"
+)
+
+// Audit actions recorded for admin operations
+const (
+	ActionSetRole       = "set_role"
+	ActionBanUser       = "ban_user"
+	ActionUnbanUser     = "unban_user"
+	ActionMuteUser      = "mute_user"
+	ActionUnmuteUser    = "unmute_user"
+	ActionDeleteMessage = "delete_message"
+	ActionDismissReport = "dismiss_report"
+	ActionFinishGame    = "finish_game"
+	ActionDeleteGame    = "delete_game"
+	ActionRunCleanup    = "run_cleanup"
+)
+
+// Audit target types
+const (
+	TargetTypeUser    = "user"
+	TargetTypeGame    = "game"
+	TargetTypeSystem  = "system"
+	TargetTypeMessage = "chat_message"
+	TargetTypeReport  = "chat_report"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+type AdminService struct {
+	db          *sql.DB
+	gameService *game.GameService
+}
+
+func NewAdminService(db *sql.DB, gameService *game.GameService) *AdminService {
+	return &AdminService{db: db, gameService: gameService}
+}
+
+// ListUsers returns users whose username or email contains query, along with
+// the total number of matches.
+func (s *AdminService) ListUsers(query string, limit, offset int) ([]models.User, int, error) {
+	limit = clampLimit(limit)
+	pattern := "%" + strings.ToLower(query) + "%"
+
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM users
+		WHERE LOWER(username) LIKE $1 OR LOWER(email) LIKE $1`, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, username, email, role, banned_at, created_at, updated_at
+		FROM users WHERE LOWER(username) LIKE $1 OR LOWER(email) LIKE $1
+		ORDER BY id LIMIT $2 OFFSET $3`, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role,
+			&user.BannedAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// SetRole changes a user's role.
+func (s *AdminService) SetRole(actorID, userID int, role string) error {
+	if !models.ValidRole(role) {
+		return errors.New("invalid role")
+	}
+	if actorID == userID {
+		return errors.New("cannot change your own role")
+	}
+
+	return s.withAudit(actorID, ActionSetRole, TargetTypeUser, userID, map[string]interface{}{"role": role}, func(tx *sql.Tx) error {
+		return execAffectingOne(tx, "UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", role, userID)
+	})
+}
+
+// BanUser prevents a user from logging in or using existing tokens.
+func (s *AdminService) BanUser(actorID, userID int, reason string) error {
+	if actorID == userID {
+		return errors.New("cannot ban yourself")
+	}
+
+	return s.withAudit(actorID, ActionBanUser, TargetTypeUser, userID, map[string]interface{}{"reason": reason}, func(tx *sql.Tx) error {
+		return execAffectingOne(tx, `
+			UPDATE users SET banned_at = $1, ban_reason = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3`, time.Now(), reason, userID)
+	})
+}
+
+// UnbanUser lifts a ban.
+func (s *AdminService) UnbanUser(actorID, userID int) error {
+	return s.withAudit(actorID, ActionUnbanUser, TargetTypeUser, userID, nil, func(tx *sql.Tx) error {
+		return execAffectingOne(tx, `
+			UPDATE users SET banned_at = NULL, ban_reason = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1`, userID)
+	})
+}
+
+// MuteUser stops a user from sending chat messages until the duration has passed.
+func (s *AdminService) MuteUser(actorID, userID int, duration time.Duration, reason string) (time.Time, error) {
+	if actorID == userID {
+		return time.Time{}, errors.New("cannot mute yourself")
+	}
+	if duration <= 0 {
+		return time.Time{}, errors.New("mute duration must be positive")
+	}
+
+	until := time.Now().Add(duration)
+	details := map[string]interface{}{"until": until, "reason": reason}
+	err := s.withAudit(actorID, ActionMuteUser, TargetTypeUser, userID, details, func(tx *sql.Tx) error {
+		return execAffectingOne(tx, `
+			UPDATE users SET muted_until = $1, mute_reason = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3`, until, reason, userID)
+	})
+	return until, err
+}
+
+// UnmuteUser lifts a mute before it expires.
+func (s *AdminService) UnmuteUser(actorID, userID int) error {
+	return s.withAudit(actorID, ActionUnmuteUser, TargetTypeUser, userID, nil, func(tx *sql.Tx) error {
+		return execAffectingOne(tx, `
+			UPDATE users SET muted_until = NULL, mute_reason = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1`, userID)
+	})
+}
+
+// ForceFinishGame ends a game, optionally awarding the win to a player.
+func (s *AdminService) ForceFinishGame(actorID, gameID int, winnerID *int) error {
+	return s.withAudit(actorID, ActionFinishGame, TargetTypeGame, gameID, map[string]interface{}{"winner_id": winnerID}, func(tx *sql.Tx) error {
+		return s.gameService.FinishGameTx(tx, gameID, winnerID)
+	})
+}
+
+// DeleteGame removes a game and its related data.
+func (s *AdminService) DeleteGame(actorID, gameID int) error {
+	return s.withAudit(actorID, ActionDeleteGame, TargetTypeGame, gameID, nil, func(tx *sql.Tx) error {
+		return s.gameService.DeleteGameTx(tx, gameID)
+	})
+}
+
+// RecordAudit stores an audit entry for an admin action. A zero targetID is stored as NULL.
+func (s *AdminService) RecordAudit(actorID int, action, targetType string, targetID int, details map[string]interface{}) error {
+	return insertAudit(s.db, actorID, action, targetType, targetID, details)
+}
+
+// ListAuditLog returns audit entries, newest first.
+func (s *AdminService) ListAuditLog(limit, offset int) ([]models.AuditEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT a.id, a.actor_id, u.username, a.action, a.target_type, a.target_id, a.details, a.created_at
+		FROM admin_audit_log a
+		JOIN users u ON a.actor_id = u.id
+		ORDER BY a.created_at DESC, a.id DESC LIMIT $1 OFFSET $2`, clampLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]models.AuditEntry, 0)
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorUsername, &entry.Action,
+			&entry.TargetType, &entry.TargetID, &details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if details.Valid {
+			entry.Details = json.RawMessage(details.String)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// withAudit runs fn and records the audit entry in the same transaction.
+func (s *AdminService) withAudit(actorID int, action, targetType string, targetID int, details map[string]interface{}, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := insertAudit(tx, actorID, action, targetType, targetID, details); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertAudit(db execer, actorID int, action, targetType string, targetID int, details map[string]interface{}) error {
+	var target *int
+	if targetID != 0 {
+		target = &targetID
+	}
+
+	var detailsJSON *string
+	if len(details) > 0 {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		str := string(encoded)
+		detailsJSON = &str
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO admin_audit_log (actor_id, action, target_type, target_id, details)
+		VALUES ($1, $2, $3, $4, $5)`, actorID, action, targetType, target, detailsJSON)
+	return err
+}
+
+func execAffectingOne(tx *sql.Tx, query string, args ...interface{}) error {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return limit
+}
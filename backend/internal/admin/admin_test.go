@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"battleship-go/internal/game"
+	"battleship-go/internal/models"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			email TEXT UNIQUE NOT NULL,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'player',
+			banned_at DATETIME,
+			ban_reason TEXT,
+			muted_until DATETIME,
+			mute_reason TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE games (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player1_id INTEGER NOT NULL,
+			player2_id INTEGER,
+			status TEXT DEFAULT 'waiting',
+			current_turn INTEGER,
+			winner_id INTEGER,
+			invited_player_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE ships (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER NOT NULL);
+		CREATE TABLE moves (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER NOT NULL);
+		CREATE TABLE chat_messages (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER);
+		CREATE TABLE chat_reports (id INTEGER PRIMARY KEY AUTOINCREMENT, message_id INTEGER NOT NULL);
+		CREATE TABLE chat_channels (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER);
+		CREATE TABLE chat_read_markers (channel_id INTEGER NOT NULL, user_id INTEGER NOT NULL);
+		CREATE TABLE quick_chat_events (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER NOT NULL);
+		CREATE TABLE game_events (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER NOT NULL);
+
+		CREATE TABLE scores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_id INTEGER UNIQUE NOT NULL,
+			wins INTEGER DEFAULT 0,
+			losses INTEGER DEFAULT 0,
+			hits INTEGER DEFAULT 0,
+			misses INTEGER DEFAULT 0,
+			points INTEGER DEFAULT 0
+		);
+
+		CREATE TABLE admin_audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER,
+			details TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO users (id, username, email, password_hash, role) VALUES
+		(1, 'admin', 'admin@test.com', 'hash', 'admin'),
+		(2, 'player1', 'player1@test.com', 'hash', 'player'),
+		(3, 'player2', 'player2@test.com', 'hash', 'player');
+		INSERT INTO scores (player_id) VALUES (1), (2), (3);
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestAdminService_ListUsers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	service := NewAdminService(db, game.NewGameService(db))
+
+	users, total, err := service.ListUsers("PLAYER", 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, users, 1)
+	assert.Equal(t, "player1", users[0].Username)
+
+	users, total, err = service.ListUsers("", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Len(t, users, 3)
+}
+
+func TestAdminService_BanAndAudit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	service := NewAdminService(db, game.NewGameService(db))
+
+	t.Run("ban and unban", func(t *testing.T) {
+		require.NoError(t, service.BanUser(1, 2, "cheating"))
+
+		var banned sql.NullTime
+		require.NoError(t, db.QueryRow("SELECT banned_at FROM users WHERE id = 2").Scan(&banned))
+		assert.True(t, banned.Valid)
+
+		require.NoError(t, service.UnbanUser(1, 2))
+		require.NoError(t, db.QueryRow("SELECT banned_at FROM users WHERE id = 2").Scan(&banned))
+		assert.False(t, banned.Valid)
+	})
+
+	t.Run("cannot ban yourself", func(t *testing.T) {
+		assert.Error(t, service.BanUser(1, 1, ""))
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		assert.ErrorIs(t, service.BanUser(1, 99, ""), sql.ErrNoRows)
+	})
+
+	t.Run("set role", func(t *testing.T) {
+		require.NoError(t, service.SetRole(1, 3, models.RoleModerator))
+		assert.Error(t, service.SetRole(1, 3, "superuser"))
+	})
+
+	t.Run("every action is audited", func(t *testing.T) {
+		entries, err := service.ListAuditLog(0, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		assert.Equal(t, ActionSetRole, entries[0].Action)
+		assert.Equal(t, "admin", entries[0].ActorUsername)
+		assert.JSONEq(t, `{"role":"moderator"}`, string(entries[0].Details))
+		assert.Equal(t, ActionBanUser, entries[2].Action)
+	})
+}
+
+func TestAdminService_Mute(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	service := NewAdminService(db, game.NewGameService(db))
+
+	_, err := service.MuteUser(1, 2, 0, "spam")
+	assert.Error(t, err)
+	_, err = service.MuteUser(1, 1, time.Hour, "")
+	assert.Error(t, err)
+
+	until, err := service.MuteUser(1, 2, time.Hour, "spam")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), until, time.Minute)
+
+	var mutedUntil sql.NullTime
+	require.NoError(t, db.QueryRow("SELECT muted_until FROM users WHERE id = 2").Scan(&mutedUntil))
+	assert.True(t, mutedUntil.Valid)
+
+	require.NoError(t, service.UnmuteUser(1, 2))
+	require.NoError(t, db.QueryRow("SELECT muted_until FROM users WHERE id = 2").Scan(&mutedUntil))
+	assert.False(t, mutedUntil.Valid)
+
+	entries, err := service.ListAuditLog(0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ActionUnmuteUser, entries[0].Action)
+	assert.Equal(t, ActionMuteUser, entries[1].Action)
+}
+
+func TestAdminService_Games(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	service := NewAdminService(db, game.NewGameService(db))
+
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn) VALUES
+		(1, 2, 3, 'active', 2), (2, 2, 3, 'active', 3)`)
+	require.NoError(t, err)
+
+	t.Run("force finish with winner", func(t *testing.T) {
+		winner := 3
+		require.NoError(t, service.ForceFinishGame(1, 1, &winner))
+
+		var status string
+		var winnerID int
+		require.NoError(t, db.QueryRow("SELECT status, winner_id FROM games WHERE id = 1").Scan(&status, &winnerID))
+		assert.Equal(t, models.GameStatusFinished, status)
+		assert.Equal(t, 3, winnerID)
+
+		var wins int
+		require.NoError(t, db.QueryRow("SELECT wins FROM scores WHERE player_id = 3").Scan(&wins))
+		assert.Equal(t, 1, wins)
+	})
+
+	t.Run("cannot finish twice", func(t *testing.T) {
+		assert.Error(t, service.ForceFinishGame(1, 1, nil))
+	})
+
+	t.Run("winner must be a player", func(t *testing.T) {
+		outsider := 1
+		assert.Error(t, service.ForceFinishGame(1, 2, &outsider))
+	})
+
+	t.Run("delete game", func(t *testing.T) {
+		require.NoError(t, service.DeleteGame(1, 2))
+
+		var count int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM games WHERE id = 2").Scan(&count))
+		assert.Zero(t, count)
+
+		assert.ErrorIs(t, service.DeleteGame(1, 2), sql.ErrNoRows)
+	})
+
+	t.Run("no game change without its audit entry", func(t *testing.T) {
+		_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn) VALUES (3, 2, 3, 'active', 2)`)
+		require.NoError(t, err)
+		_, err = db.Exec(`DROP TABLE admin_audit_log`)
+		require.NoError(t, err)
+
+		winner := 2
+		assert.Error(t, service.ForceFinishGame(1, 3, &winner))
+		assert.Error(t, service.DeleteGame(1, 3))
+
+		var status string
+		require.NoError(t, db.QueryRow("SELECT status FROM games WHERE id = 3").Scan(&status))
+		assert.Equal(t, models.GameStatusActive, status)
+
+		var wins int
+		require.NoError(t, db.QueryRow("SELECT wins FROM scores WHERE player_id = 2").Scan(&wins))
+		assert.Zero(t, wins)
+	})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"battleship-go/internal/account"
+	"battleship-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *API) changeUsername(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.accountService.ChangeUsername(c.GetInt("userID"), req.Username)
+	if err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	// Tokens carry the username, so hand out one with the new name
+	token, err := a.authService.GenerateToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  user,
+		"token": token,
+	})
+}
+
+func (a *API) changeEmail(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.accountService.ChangeEmail(c.GetInt("userID"), req.Email, req.Password)
+	if err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (a *API) changePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required,min=6"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	if err := a.accountService.ChangePassword(userID, req.CurrentPassword, req.NewPassword); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	// The change revokes every existing token, including the current one
+	user, err := a.authService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return
+	}
+	token, err := a.authService.GenerateToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func (a *API) deleteAccount(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.accountService.DeleteAccount(c.GetInt("userID"), req.Password); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+	a.hub.Disconnect(c.GetInt("userID"))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// anonymizeGuest anonymizes an expired guest for the cleanup and closes the
+// connections it may still have open.
+func (a *API) anonymizeGuest(userID int) error {
+	if err := a.accountService.AnonymizeUser(userID); err != nil {
+		return err
+	}
+	a.hub.Disconnect(userID)
+	return nil
+}
+
+func (a *API) exportAccount(c *gin.Context) {
+	userID := c.GetInt("userID")
+	export, err := a.accountService.Export(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account data"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="battleship-export-%d.json"`, userID))
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+// respondAccountError maps account service errors to status codes.
+func respondAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect password"})
+	case errors.Is(err, account.ErrUsernameTaken), errors.Is(err, account.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *API) adminListUsers(c *gin.Context) {
+	limit, offset := paginationParams(c)
+	users, total, err := a.adminService.ListUsers(c.Query("q"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"total": total,
+	})
+}
+
+func (a *API) adminSetRole(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.adminService.SetRole(c.GetInt("userID"), userID, req.Role); err != nil {
+		respondAdminError(c, err, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+func (a *API) adminBanUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.adminService.BanUser(c.GetInt("userID"), userID, req.Reason); err != nil {
+		respondAdminError(c, err, "User not found")
+		return
+	}
+	a.hub.Disconnect(userID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User banned"})
+}
+
+func (a *API) adminUnbanUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := a.adminService.UnbanUser(c.GetInt("userID"), userID); err != nil {
+		respondAdminError(c, err, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unbanned"})
+}
+
+func (a *API) adminMuteUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		DurationMinutes int    `json:"duration_minutes" binding:"required,min=1,max=525600"`
+		Reason          string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	until, err := a.adminService.MuteUser(c.GetInt("userID"), userID, time.Duration(req.DurationMinutes)*time.Minute, req.Reason)
+	if err != nil {
+		respondAdminError(c, err, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User muted", "muted_until": until})
+}
+
+func (a *API) adminUnmuteUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := a.adminService.UnmuteUser(c.GetInt("userID"), userID); err != nil {
+		respondAdminError(c, err, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unmuted"})
+}
+
+func (a *API) adminFinishGame(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	var req struct {
+		WinnerID *int `json:"winner_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.adminService.ForceFinishGame(c.GetInt("userID"), gameID, req.WinnerID); err != nil {
+		respondAdminError(c, err, "Game not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Game finished"})
+}
+
+func (a *API) adminDeleteGame(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	if err := a.adminService.DeleteGame(c.GetInt("userID"), gameID); err != nil {
+		respondAdminError(c, err, "Game not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Game deleted"})
+}
+
+func (a *API) adminListAudit(c *gin.Context) {
+	limit, offset := paginationParams(c)
+	entries, err := a.adminService.ListAuditLog(limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// adminWebSocketStats reports the send queue depth and dropped messages of
+// every WebSocket connection.
+func (a *API) adminWebSocketStats(c *gin.Context) {
+	c.JSON(http.StatusOK, a.hub.Stats())
+}
+
+// respondAdminError maps a missing row to 404 and everything else to 400.
+func respondAdminError(c *gin.Context, err error, notFound string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// paginationParams reads the limit and offset query parameters. Invalid
+// values fall back to zero, leaving the service to apply its defaults.
+func paginationParams(c *gin.Context) (int, int) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
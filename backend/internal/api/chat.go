@@ -0,0 +1,603 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"battleship-go/internal/admin"
+	"battleship-go/internal/chat"
+	"battleship-go/internal/models"
+	"battleship-go/internal/protocol"
+	"battleship-go/internal/social"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mutedError is returned by postChat when the sender is muted.
+type mutedError struct {
+	until time.Time
+}
+
+func (e *mutedError) Error() string {
+	return "you are muted"
+}
+
+// errAccountClosed is returned by postChat when the sender was banned or
+// deleted their account, but is still connected.
+var errAccountClosed = errors.New("account is banned or deleted")
+
+// errNotFriends is returned by postChat when the members of a direct channel
+// are no longer friends.
+var errNotFriends = errors.New("direct messages are only available between friends")
+
+// canChat checks that a user may send chat at all: their account is open and
+// they are not muted.
+func (a *API) canChat(userID int) error {
+	user, err := a.authService.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.BannedAt != nil || user.DeletedAt != nil {
+		return errAccountClosed
+	}
+
+	mutedUntil, err := a.socialService.MutedUntil(userID)
+	if err != nil {
+		return err
+	}
+	if mutedUntil != nil {
+		return &mutedError{until: *mutedUntil}
+	}
+	return nil
+}
+
+// postChat stores a chat message and delivers it to the channel. Messages
+// sent over REST and over the WebSocket both go through here.
+func (a *API) postChat(channel *chat.Channel, userID int, text string) (*models.ChatMessage, error) {
+	if err := a.canChat(userID); err != nil {
+		return nil, err
+	}
+
+	if channel.Type == chat.ChannelDirect {
+		if !channel.CanRead(userID) {
+			return nil, chat.ErrChannelNotFound
+		}
+		for _, member := range channel.Members() {
+			if blocked, err := a.socialService.IsBlocked(userID, member); err != nil || blocked {
+				return nil, social.ErrBlocked
+			}
+			if member == userID {
+				continue
+			}
+			friends, err := a.socialService.AreFriends(userID, member)
+			if err != nil {
+				return nil, err
+			}
+			if !friends {
+				return nil, errNotFriends
+			}
+		}
+	}
+
+	chatMessage, err := a.chatService.Send(channel, userID, text)
+	if err != nil {
+		return nil, err
+	}
+
+	a.broadcastToChannel(channel, userID, chatEvent(channel, chatMessage))
+	return chatMessage, nil
+}
+
+// chatEvent is the WebSocket event for a new message in the channel. Each
+// channel type has its own so that game screens never show lobby or direct
+// messages as game chat.
+func chatEvent(channel *chat.Channel, msg *models.ChatMessage) protocol.Payload {
+	switch channel.Type {
+	case chat.ChannelLobby:
+		return protocol.LobbyChat{ChatMessage: *msg}
+	case chat.ChannelDirect:
+		return protocol.DirectMessage{ChatMessage: *msg}
+	default:
+		return protocol.GameChat{ChatMessage: *msg}
+	}
+}
+
+// broadcastToChannel delivers an event to everyone following the channel:
+// the game room, lobby clients or both sides of a direct conversation. With a
+// non-zero senderID, recipients the chat filter rejects are skipped.
+func (a *API) broadcastToChannel(channel *chat.Channel, senderID int, payload protocol.Payload) {
+	event := protocol.New(payload)
+	event.ChannelID = channel.ID
+	if channel.GameID != nil {
+		event.GameID = *channel.GameID
+	}
+
+	switch channel.Type {
+	case chat.ChannelGame:
+		if senderID == 0 {
+			a.hub.BroadcastToGame(*channel.GameID, event)
+		} else {
+			a.hub.BroadcastChatToGame(*channel.GameID, senderID, event)
+		}
+	case chat.ChannelLobby:
+		a.hub.BroadcastChatToLobby(senderID, event)
+	case chat.ChannelDirect:
+		for _, member := range channel.Members() {
+			a.hub.SendToUser(member, event)
+		}
+	}
+}
+
+// receiveChat handles game chat messages sent over the WebSocket.
+func (a *API) receiveChat(userID, gameID int, text string) {
+	channel, err := a.chatService.GameChannel(gameID)
+	if err == nil {
+		_, err = a.postChat(channel, userID, text)
+	}
+	if err != nil {
+		log.Printf("Rejected WebSocket chat from UserID %d: %v", userID, err)
+	}
+}
+
+func (a *API) sendChatMessage(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	channel, err := a.chatService.GameChannel(gameID)
+	if errors.Is(err, chat.ErrGameNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.createChatMessage(c, channel)
+}
+
+func (a *API) getChatMessages(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	channel, err := a.chatService.FindGameChannel(gameID)
+	if errors.Is(err, chat.ErrChannelNotFound) {
+		// Nobody has chatted in this game yet
+		c.JSON(http.StatusOK, []models.ChatMessage{})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.respondHistory(c, channel)
+}
+
+func (a *API) markGameChatRead(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	channel, err := a.chatService.FindGameChannel(gameID)
+	if errors.Is(err, chat.ErrChannelNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.markRead(c, channel)
+}
+
+// respondHistory writes a page of the channel's history selected by the
+// before, after and limit query parameters. since=last_read continues from
+// the user's read marker, for catching up after a reconnect.
+func (a *API) respondHistory(c *gin.Context, channel *chat.Channel) {
+	var page chat.Page
+	for param, dest := range map[string]*int{"before": &page.Before, "after": &page.After, "limit": &page.Limit} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+			return
+		}
+		*dest = n
+	}
+
+	userID := c.GetInt("userID")
+	switch c.Query("since") {
+	case "":
+	case "last_read":
+		lastRead, err := a.chatService.LastRead(channel.ID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		page.Before = 0
+		page.After = lastRead
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be last_read"})
+		return
+	}
+
+	messages, err := a.chatService.History(channel.ID, userID, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// getChatChannels lists the lobby and the user's direct conversations with unread counts.
+func (a *API) getChatChannels(c *gin.Context) {
+	channels, err := a.chatService.Channels(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, channels)
+}
+
+// openDirectChannel returns the direct conversation with a friend, creating it if needed.
+func (a *API) openDirectChannel(c *gin.Context) {
+	otherID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	friends, err := a.socialService.AreFriends(userID, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check friendship"})
+		return
+	}
+	if !friends {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Direct messages are only available between friends"})
+		return
+	}
+
+	channel, err := a.chatService.DirectChannel(userID, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+func (a *API) getChannelMessages(c *gin.Context) {
+	channel, ok := a.readableChannel(c)
+	if !ok {
+		return
+	}
+
+	a.respondHistory(c, channel)
+}
+
+func (a *API) postChannelMessage(c *gin.Context) {
+	channel, ok := a.readableChannel(c)
+	if !ok {
+		return
+	}
+
+	a.createChatMessage(c, channel)
+}
+
+func (a *API) markChannelRead(c *gin.Context) {
+	channel, ok := a.readableChannel(c)
+	if !ok {
+		return
+	}
+
+	a.markRead(c, channel)
+}
+
+// markRead moves the user's read marker in the channel to the message_id in
+// the request body and responds with the remaining unread count.
+func (a *API) markRead(c *gin.Context, channel *chat.Channel) {
+	var req struct {
+		MessageID int `json:"message_id" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	if err := a.chatService.MarkRead(channel.ID, userID, req.MessageID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	unread, err := a.chatService.UnreadCount(channel.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+// readableChannel loads the channel named in the URL and checks that the user
+// may read it. Channels the user cannot read are reported as missing.
+func (a *API) readableChannel(c *gin.Context) (*chat.Channel, bool) {
+	channelID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return nil, false
+	}
+
+	channel, err := a.chatService.Channel(channelID)
+	if err == nil && !channel.CanRead(c.GetInt("userID")) {
+		err = chat.ErrChannelNotFound
+	}
+	if errors.Is(err, chat.ErrChannelNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return channel, true
+}
+
+// createChatMessage posts the message in the request body to the channel.
+func (a *API) createChatMessage(c *gin.Context, channel *chat.Channel) {
+	var req struct {
+		Message string `json:"message" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chatMessage, err := a.postChat(channel, c.GetInt("userID"), req.Message)
+	if err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, chatMessage)
+}
+
+// respondChatError maps an error from sending chat or quick-chat to a response.
+func respondChatError(c *gin.Context, err error) {
+	var muted *mutedError
+	switch {
+	case errors.As(err, &muted):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are muted", "muted_until": muted.until})
+	case errors.Is(err, errAccountClosed), errors.Is(err, errNotFriends):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, social.ErrBlocked):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot message this user"})
+	case errors.Is(err, chat.ErrFreeChatDisabled), errors.Is(err, chat.ErrNotPlayer):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, chat.ErrChannelNotFound), errors.Is(err, chat.ErrGameNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, chat.ErrFlooding):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, chat.ErrEmptyMessage), errors.Is(err, chat.ErrMessageTooLong),
+		errors.Is(err, chat.ErrMessageRejected), errors.Is(err, chat.ErrUnknownQuickChat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// postQuickChat records a quick-chat phrase or reaction and delivers it to the
+// game room. Like free chat, it is subject to mutes and blocks.
+func (a *API) postQuickChat(gameID, userID int, kind, code string) (*chat.QuickChat, error) {
+	if err := a.canChat(userID); err != nil {
+		return nil, err
+	}
+
+	event, err := a.chatService.SendQuick(gameID, userID, kind, code)
+	if err != nil {
+		return nil, err
+	}
+
+	a.hub.BroadcastChatToGame(gameID, userID, protocol.New(protocol.QuickChat{QuickChat: *event}).ForGame(gameID))
+	return event, nil
+}
+
+// receiveQuickChat handles quick-chat and reactions sent over the WebSocket.
+func (a *API) receiveQuickChat(userID, gameID int, kind, code string) {
+	if _, err := a.postQuickChat(gameID, userID, kind, code); err != nil {
+		log.Printf("Rejected quick-chat from UserID %d: %v", userID, err)
+	}
+}
+
+func (a *API) sendQuickChat(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	var req struct {
+		Kind string `json:"kind" binding:"required,oneof=phrase reaction"`
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := a.postQuickChat(gameID, c.GetInt("userID"), req.Kind, req.Code)
+	if err != nil {
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, event)
+}
+
+func (a *API) getQuickChat(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	events, err := a.chatService.RecentQuick(gameID, c.GetInt("userID"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// getQuickChatCatalog lists the phrases and reactions players can send.
+func (a *API) getQuickChatCatalog(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"phrases":   chat.QuickPhrases,
+		"reactions": chat.Reactions,
+	})
+}
+
+func (a *API) reportChatMessage(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = a.chatService.Report(messageID, c.GetInt("userID"), req.Reason)
+	switch {
+	case errors.Is(err, chat.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, chat.ErrAlreadyReported):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, chat.ErrOwnMessage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to report message"})
+	default:
+		c.JSON(http.StatusCreated, gin.H{"message": "Message reported"})
+	}
+}
+
+func (a *API) modListReports(c *gin.Context) {
+	status := c.DefaultQuery("status", chat.ReportOpen)
+	limit, offset := paginationParams(c)
+	reports, err := a.chatService.ListReports(status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reports)
+}
+
+// modResolveReport either deletes the reported message or dismisses the report.
+func (a *API) modResolveReport(c *gin.Context) {
+	reportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+
+	var req struct {
+		Action string `json:"action" binding:"required,oneof=delete dismiss"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	moderatorID := c.GetInt("userID")
+	if req.Action == "dismiss" {
+		if err := a.chatService.DismissReport(reportID, moderatorID); err != nil {
+			respondModerationError(c, err)
+			return
+		}
+		a.recordModeration(moderatorID, admin.ActionDismissReport, admin.TargetTypeReport, reportID)
+		c.JSON(http.StatusOK, gin.H{"message": "Report dismissed"})
+		return
+	}
+
+	messageID, err := a.chatService.ReportedMessage(reportID)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+	a.deleteChatMessage(c, messageID)
+}
+
+func (a *API) modDeleteChatMessage(c *gin.Context) {
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	a.deleteChatMessage(c, messageID)
+}
+
+// deleteChatMessage removes a message and tells clients in the game to drop it.
+func (a *API) deleteChatMessage(c *gin.Context, messageID int) {
+	moderatorID := c.GetInt("userID")
+	msg, err := a.chatService.Delete(messageID, moderatorID)
+	if err != nil {
+		respondModerationError(c, err)
+		return
+	}
+	a.recordModeration(moderatorID, admin.ActionDeleteMessage, admin.TargetTypeMessage, messageID)
+
+	if channel, err := a.chatService.Channel(msg.ChannelID); err == nil {
+		a.broadcastToChannel(channel, 0, protocol.ChatDeleted{ID: msg.ID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+}
+
+// recordModeration writes an audit entry after the action has already been
+// applied, so a failure is only logged.
+func (a *API) recordModeration(moderatorID int, action, targetType string, targetID int) {
+	if err := a.adminService.RecordAudit(moderatorID, action, targetType, targetID, nil); err != nil {
+		log.Printf("Failed to record %s audit entry: %v", action, err)
+	}
+}
+
+// respondModerationError maps chat moderation errors to status codes.
+func respondModerationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, chat.ErrMessageNotFound), errors.Is(err, chat.ErrReportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"battleship-go/internal/protocol"
+	"battleship-go/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Long-poll wait times
+const (
+	defaultPollWait = 25 * time.Second
+	maxPollWait     = 55 * time.Second
+)
+
+// tokenFromQuery lets clients that cannot set headers, such as browser
+// WebSockets and EventSource, pass their token as ?token=. It only applies
+// to the event endpoints. The request log redacts it, but proxies in front
+// of the server may still log it.
+func (a *API) tokenFromQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
+// watchableGame checks that a game exists before a client follows its
+// events. Any signed-in user may follow a game, as a player or a spectator.
+func (a *API) watchableGame(c *gin.Context, gameID int) bool {
+	var exists int
+	if err := a.db.QueryRow("SELECT 1 FROM games WHERE id = $1", gameID).Scan(&exists); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return false
+	}
+	return true
+}
+
+func (a *API) handleWebSocket(c *gin.Context) {
+	if gameIDStr := c.Query("gameId"); gameIDStr != "" {
+		gameID, err := strconv.Atoi(gameIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+			return
+		}
+		if !a.watchableGame(c, gameID) {
+			return
+		}
+	}
+
+	websocket.HandleWebSocket(a.hub, c.Writer, c.Request, c.GetInt("userID"))
+}
+
+// eventStreamParams reads the game and protocol version of an event stream
+// request. Unlike WebSocket clients, stream clients get the current protocol
+// version unless they ask for another with ?v=.
+func (a *API) eventStreamParams(c *gin.Context) (int, int, bool) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return 0, 0, false
+	}
+	format, err := protocol.Negotiate(c.DefaultQuery("v", strconv.Itoa(protocol.CurrentVersion)), "", nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, 0, false
+	}
+	if !a.watchableGame(c, gameID) {
+		return 0, 0, false
+	}
+	return gameID, format.Version, true
+}
+
+// streamGameEvents streams a game's events as Server-Sent Events. Each event
+// has its seq as the SSE id, so a reconnecting EventSource resumes through
+// Last-Event-ID.
+func (a *API) streamGameEvents(c *gin.Context) {
+	gameID, version, ok := a.eventStreamParams(c)
+	if !ok {
+		return
+	}
+
+	var lastSeq *int64
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		seq, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		lastSeq = &seq
+	}
+
+	sub := a.hub.Subscribe(c.GetInt("userID"), gameID, lastSeq)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // disable proxy buffering
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ping := time.NewTicker(a.streamPing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case envelope, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes
+				return
+			}
+			msgBytes, err := protocol.Encode(envelope, version)
+			if err != nil {
+				continue
+			}
+			if envelope.Seq != 0 {
+				fmt.Fprintf(c.Writer, "id: %d\n", envelope.Seq)
+			}
+			fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", envelope.Type, msgBytes)
+			c.Writer.Flush()
+		case <-ping.C:
+			// Comments keep proxies from closing an idle stream
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// pollGameEvents long-polls a game's events after the seq in ?after=. It
+// answers as soon as there are events, or empty after ?wait= seconds.
+// Without ?after= it answers at once with the current seq to start from.
+func (a *API) pollGameEvents(c *gin.Context) {
+	gameID, version, ok := a.eventStreamParams(c)
+	if !ok {
+		return
+	}
+
+	afterStr := c.Query("after")
+	if afterStr == "" {
+		lastSeq, err := a.events.LastSeq(gameID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load events"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": []json.RawMessage{}, "last_seq": lastSeq})
+		return
+	}
+	after, err := strconv.ParseInt(afterStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after"})
+		return
+	}
+
+	wait := defaultPollWait
+	if seconds, err := strconv.Atoi(c.Query("wait")); err == nil && seconds >= 0 {
+		wait = min(time.Duration(seconds)*time.Second, maxPollWait)
+	}
+
+	envelopes := a.hub.Poll(c.Request.Context(), c.GetInt("userID"), gameID, after, wait)
+
+	lastSeq := after
+	messages := make([]json.RawMessage, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		msgBytes, err := protocol.Encode(envelope, version)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, msgBytes)
+		// After a resync the client reloads the game and continues from its seq
+		if resync, ok := envelope.Data.(protocol.Resync); ok {
+			lastSeq = resync.Seq
+		} else if envelope.Seq > lastSeq {
+			lastSeq = envelope.Seq
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": messages, "last_seq": lastSeq})
+}
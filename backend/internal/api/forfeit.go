@@ -0,0 +1,169 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"battleship-go/internal/models"
+	"battleship-go/internal/protocol"
+
+	"github.com/gin-gonic/gin"
+)
+
+// forfeitTimers forfeits active games whose player stays disconnected for too
+// long, for example after their connection died without a close. Timers live
+// in memory and do not survive a restart.
+type forfeitTimers struct {
+	mu     sync.Mutex
+	after  time.Duration
+	timers map[forfeitKey]*time.Timer
+}
+
+type forfeitKey struct {
+	userID int
+	gameID int
+}
+
+func newForfeitTimers(after time.Duration) *forfeitTimers {
+	return &forfeitTimers{after: after, timers: make(map[forfeitKey]*time.Timer)}
+}
+
+// start runs expire unless stop is called for the same player and game first.
+func (f *forfeitTimers) start(userID, gameID int, expire func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := forfeitKey{userID, gameID}
+	if timer, ok := f.timers[key]; ok {
+		timer.Stop()
+	}
+	f.timers[key] = time.AfterFunc(f.after, func() {
+		f.mu.Lock()
+		delete(f.timers, key)
+		f.mu.Unlock()
+		expire()
+	})
+}
+
+func (f *forfeitTimers) stop(userID, gameID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := forfeitKey{userID, gameID}
+	if timer, ok := f.timers[key]; ok {
+		timer.Stop()
+		delete(f.timers, key)
+	}
+}
+
+// gameConnectionChanged starts the forfeit timer when a player of an active
+// game loses their last connection to it and stops it when they reconnect.
+func (a *API) gameConnectionChanged(userID, gameID int, connected bool) {
+	if a.forfeits == nil {
+		return
+	}
+	if connected {
+		a.forfeits.stop(userID, gameID)
+		return
+	}
+
+	if _, ok := a.activeOpponent(gameID, userID); !ok {
+		return
+	}
+	log.Printf("UserID %d disconnected from active game %d, forfeiting in %s unless they return", userID, gameID, a.forfeits.after)
+	a.forfeits.start(userID, gameID, func() { a.forfeitDisconnected(userID, gameID) })
+}
+
+// forfeitDisconnected awards the game to the opponent of a player who did
+// not come back in time.
+func (a *API) forfeitDisconnected(userID, gameID int) {
+	if a.hub.Connected(userID, gameID) {
+		return
+	}
+	opponentID, ok := a.activeOpponent(gameID, userID)
+	if !ok {
+		return
+	}
+
+	if err := a.gameService.FinishGame(gameID, &opponentID); err != nil {
+		log.Printf("Failed to forfeit game %d for UserID %d: %v", gameID, userID, err)
+		return
+	}
+	log.Printf("Game %d forfeited by disconnected UserID %d", gameID, userID)
+	a.broadcastGameEnd(gameID, protocol.ReasonForfeit)
+}
+
+// inactiveGameEnded tells the players of a game the cleanup forfeited or
+// cancelled.
+func (a *API) inactiveGameEnded(gameID int) {
+	a.broadcastGameEnd(gameID, protocol.ReasonInactive)
+}
+
+// warnInactivePlayer tells a player their game is about to be forfeited
+// for inactivity, live and in their notification inbox.
+func (a *API) warnInactivePlayer(userID, gameID int, left time.Duration) {
+	a.notifyUser(userID, protocol.InactivityWarning{GameID: gameID, SecondsLeft: int(left.Seconds())})
+}
+
+// stillHere resets the inactivity timer of a game on behalf of a player.
+func (a *API) stillHere(userID, gameID int) {
+	if err := a.gameService.MarkActive(gameID, userID); err != nil {
+		log.Printf("Ignoring still_here from UserID %d for game %d: %v", userID, gameID, err)
+	}
+}
+
+func (a *API) markStillHere(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	if err := a.gameService.MarkActive(gameID, c.GetInt("userID")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Inactivity timer reset"})
+}
+
+// broadcastGameEnd sends the final state of a game to its room.
+func (a *API) broadcastGameEnd(gameID int, reason string) {
+	var game models.Game
+	err := a.db.QueryRow(`
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, chat_mode, board_size, created_at, updated_at
+		FROM games WHERE id = $1`, gameID).Scan(
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status,
+		&game.CurrentTurn, &game.WinnerID, &game.ChatMode, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
+	if err != nil {
+		return
+	}
+	a.hub.BroadcastToGame(gameID, protocol.New(protocol.GameUpdate{
+		Reason: reason,
+		Game:   &game,
+	}).ForGame(gameID))
+}
+
+// activeOpponent returns the other player of an active game the user plays in.
+func (a *API) activeOpponent(gameID, userID int) (int, bool) {
+	var status string
+	var player1ID int
+	var player2ID *int
+	err := a.db.QueryRow("SELECT status, player1_id, player2_id FROM games WHERE id = $1", gameID).Scan(
+		&status, &player1ID, &player2ID)
+	if err != nil || status != models.GameStatusActive || player2ID == nil {
+		return 0, false
+	}
+
+	switch userID {
+	case player1ID:
+		return *player2ID, true
+	case *player2ID:
+		return player1ID, true
+	default:
+		return 0, false
+	}
+}
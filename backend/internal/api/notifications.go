@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"battleship-go/internal/notify"
+	"battleship-go/internal/protocol"
+	"battleship-go/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hubChannel delivers notifications live over the WebSocket. Users who are
+// not connected get them when they next connect.
+type hubChannel struct {
+	hub *websocket.Hub
+}
+
+func (h *hubChannel) Name() string {
+	return "websocket"
+}
+
+func (h *hubChannel) Deliver(n *notify.Notification) (bool, error) {
+	if h.hub.Presence(n.UserID).Status == websocket.PresenceOffline {
+		return false, nil
+	}
+	h.hub.SendToUser(n.UserID, notificationEvent(n))
+	return true, nil
+}
+
+// notificationEvent is the WebSocket event for a notification. It keeps the
+// notification's type as the event type so clients handle live and missed
+// notifications alike.
+func notificationEvent(n *notify.Notification) *protocol.Envelope {
+	event := protocol.New(protocol.Stored{Type: protocol.MessageType(n.Type), Raw: n.Data})
+	event.NotificationID = n.ID
+	event.CreatedAt = &n.CreatedAt
+	return event
+}
+
+// notificationsUnwritten puts notifications a connection dropped before
+// writing them back in line for the user's next connect.
+func (a *API) notificationsUnwritten(userID int, notificationIDs []int) {
+	if err := a.notificationService.MarkUndelivered(userID, notificationIDs); err != nil {
+		log.Printf("Failed to requeue notifications for UserID %d: %v", userID, err)
+	}
+}
+
+// notifyUser records a notification for a user and delivers it through the
+// configured channels. The payload's message type is the notification type.
+func (a *API) notifyUser(userID int, payload protocol.Payload) {
+	kind := string(payload.MessageType())
+	if _, err := a.notificationService.Notify(userID, kind, payload); err != nil {
+		log.Printf("Failed to notify UserID %d of %s: %v", userID, kind, err)
+	}
+}
+
+// missedNotifications returns the events to push to a user when they connect.
+func (a *API) missedNotifications(userID int) []*protocol.Envelope {
+	missed, err := a.notificationService.Missed(userID)
+	if err != nil {
+		log.Printf("Failed to load missed notifications for UserID %d: %v", userID, err)
+		return nil
+	}
+
+	events := make([]*protocol.Envelope, 0, len(missed))
+	for i := range missed {
+		events = append(events, notificationEvent(&missed[i]))
+	}
+	return events
+}
+
+func (a *API) getNotifications(c *gin.Context) {
+	userID := c.GetInt("userID")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	notifications, err := a.notificationService.List(userID, c.Query("unread") == "true", limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	unread, err := a.notificationService.UnreadCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread})
+}
+
+func (a *API) markNotificationRead(c *gin.Context) {
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	err = a.notificationService.MarkRead(c.GetInt("userID"), notificationID)
+	if errors.Is(err, notify.ErrNotificationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+func (a *API) markAllNotificationsRead(c *gin.Context) {
+	if err := a.notificationService.MarkAllRead(c.GetInt("userID")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All notifications marked as read"})
+}
+
+// notifyTurn tells the player whose turn it is after a move, unless they are
+// already watching the game.
+func (a *API) notifyTurn(gameID, moverID int) {
+	var status string
+	var currentTurn *int
+	err := a.db.QueryRow("SELECT status, current_turn FROM games WHERE id = $1", gameID).Scan(&status, &currentTurn)
+	if err != nil || currentTurn == nil || *currentTurn == moverID || status != "active" {
+		return
+	}
+	if presence := a.hub.Presence(*currentTurn); presence.GameID == gameID {
+		return
+	}
+	a.notifyUser(*currentTurn, protocol.YourTurn{GameID: gameID, OpponentID: moverID})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"battleship-go/internal/auth"
+	"battleship-go/internal/oauth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oauthStateCookie binds a login to the browser that started it, so a
+// callback URL cannot be replayed in someone else's browser.
+const oauthStateCookie = "oauth_state"
+
+func (a *API) getOAuthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": a.oauthService.Providers()})
+}
+
+func (a *API) oauthLogin(c *gin.Context) {
+	authURL, state, err := a.oauthService.AuthorizationURL(c.Request.Context(), c.Param("provider"))
+	if err == oauth.ErrUnknownProvider {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start OAuth login: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider unavailable"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, 600, "/api/auth/oauth", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oauthLink starts linking a provider to the signed-in account. It answers
+// with the provider URL for the client to open, since the request carries
+// the token in a header rather than being a navigation.
+func (a *API) oauthLink(c *gin.Context) {
+	authURL, state, err := a.oauthService.LinkURL(c.Request.Context(), c.Param("provider"), c.GetInt("userID"))
+	if err == oauth.ErrUnknownProvider {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start OAuth link: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider unavailable"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, 600, "/api/auth/oauth", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"url": authURL})
+}
+
+func (a *API) oauthCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		a.oauthFailure(c, http.StatusUnauthorized, providerErr)
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Cookie(oauthStateCookie)
+	if err != nil || state == "" || cookie != state {
+		a.oauthFailure(c, http.StatusBadRequest, oauth.ErrInvalidState.Error())
+		return
+	}
+	c.SetCookie(oauthStateCookie, "", -1, "/api/auth/oauth", "", c.Request.TLS != nil, true)
+
+	user, token, err := a.oauthService.HandleCallback(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+	var twoFactor *auth.TwoFactorRequiredError
+	switch {
+	case err == nil:
+	case errors.As(err, &twoFactor):
+		a.oauthTwoFactor(c, twoFactor.ChallengeToken)
+		return
+	case errors.Is(err, oauth.ErrUnknownProvider):
+		a.oauthFailure(c, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, oauth.ErrInvalidState):
+		a.oauthFailure(c, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, oauth.ErrLinkRequired), errors.Is(err, oauth.ErrIdentityInUse):
+		a.oauthFailure(c, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, auth.ErrUserBanned):
+		a.oauthFailure(c, http.StatusForbidden, err.Error())
+		return
+	default:
+		log.Printf("OAuth login failed: %v", err)
+		a.oauthFailure(c, http.StatusUnauthorized, "login failed")
+		return
+	}
+
+	if a.oauthRedirect == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"user":  user,
+			"token": token,
+		})
+		return
+	}
+
+	// The fragment is never sent to servers, keeping the token out of logs
+	c.Redirect(http.StatusFound, a.oauthRedirect+"#token="+url.QueryEscape(token))
+}
+
+func (a *API) oauthTwoFactor(c *gin.Context, challenge string) {
+	if a.oauthRedirect == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, a.oauthRedirect+"#challenge_token="+url.QueryEscape(challenge))
+}
+
+func (a *API) oauthFailure(c *gin.Context, status int, message string) {
+	if a.oauthRedirect == "" {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	c.Redirect(http.StatusFound, a.oauthRedirect+"#error="+url.QueryEscape(message))
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"battleship-go/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Per-route rate limit policies. Unauthenticated routes are limited per
+// client IP, authenticated ones per user.
+var (
+	loginPolicy          = ratelimit.Policy{Name: "login", Burst: 10, Interval: 30 * time.Second}
+	registerPolicy       = ratelimit.Policy{Name: "register", Burst: 5, Interval: 5 * time.Minute}
+	guestPolicy          = ratelimit.Policy{Name: "guest", Burst: 5, Interval: time.Minute}
+	accountEmailPolicy   = ratelimit.Policy{Name: "account_email", Burst: 5, Interval: 2 * time.Minute}
+	chatPolicy           = ratelimit.Policy{Name: "chat", Burst: 10, Interval: 2 * time.Second}
+	movePolicy           = ratelimit.Policy{Name: "move", Burst: 20, Interval: 500 * time.Millisecond}
+	twoFactorLoginPolicy = ratelimit.Policy{Name: "login_2fa", Burst: 10, Interval: 30 * time.Second}
+	friendRequestPolicy  = ratelimit.Policy{Name: "friend_request", Burst: 10, Interval: time.Minute}
+	reportPolicy         = ratelimit.Policy{Name: "chat_report", Burst: 5, Interval: time.Minute}
+)
+
+// rateLimit rejects requests exceeding the policy with 429 and a Retry-After
+// header. If the limiter store fails, requests are let through rather than
+// taking the whole API down with it.
+func (a *API) rateLimit(policy ratelimit.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if userID := c.GetInt("userID"); userID != 0 {
+			key = "user:" + strconv.Itoa(userID)
+		}
+
+		result, err := a.limiter.Allow(c.Request.Context(), policy, key)
+		if err != nil {
+			log.Printf("Rate limiter error for %s: %v", policy.Name, err)
+			c.Next()
+			return
+		}
+
+		if !result.Allowed {
+			setRetryAfter(c, result.RetryAfter)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up.
+func setRetryAfter(c *gin.Context, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"battleship-go/internal/config"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter creates the gin engine with logging, recovery and CORS. Only the
+// configured proxies may set the client IP through X-Forwarded-For.
+func NewRouter(cfg *config.Config) (*gin.Engine, error) {
+	router := gin.New()
+	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{Formatter: logFormatter}), gin.Recovery())
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     cfg.Server.CORSOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowCredentials: true,
+	}))
+	return router, nil
+}
+
+// logFormatter is gin's log line without colors and with the session token
+// of the event endpoints, which take it as ?token=, redacted.
+func logFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		redactToken(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+// redactToken replaces the token query parameter of a logged path.
+func redactToken(path string) string {
+	base, query, ok := strings.Cut(path, "?")
+	if !ok || !strings.Contains(query, "token") {
+		return path
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return base
+	}
+	if values.Has("token") {
+		values.Set("token", "REDACTED")
+	}
+	return base + "?" + values.Encode()
+}
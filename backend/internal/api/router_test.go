@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"battleship-go/internal/config"
+	"battleship-go/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_RateLimitClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	login := func(t *testing.T, cfg *config.Config) func(forwardedFor string) int {
+		router, err := NewRouter(cfg)
+		require.NoError(t, err)
+		a := &API{limiter: ratelimit.NewLimiter(ratelimit.NewMemoryStore())}
+		router.POST("/api/auth/login", a.rateLimit(loginPolicy), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		return func(forwardedFor string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+			req.RemoteAddr = "192.0.2.1:4321"
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+	}
+
+	t.Run("spoofed X-Forwarded-For shares the peer's bucket", func(t *testing.T) {
+		post := login(t, config.Default())
+		for i := 0; i < loginPolicy.Burst; i++ {
+			require.Equal(t, http.StatusOK, post(fmt.Sprintf("198.51.100.%d", i)))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, post("198.51.100.200"))
+	})
+
+	t.Run("trusted proxy forwards the client IP", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.Server.TrustedProxies = []string{"192.0.2.0/24"}
+		post := login(t, cfg)
+		for i := 0; i < loginPolicy.Burst; i++ {
+			require.Equal(t, http.StatusOK, post("198.51.100.1"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, post("198.51.100.1"))
+		assert.Equal(t, http.StatusOK, post("198.51.100.2"))
+	})
+}
+
+func TestRouter_LogRedactsToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logged bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logged
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	router, err := NewRouter(config.Default())
+	require.NoError(t, err)
+	router.GET("/api/games/:id/events", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/games/3/events?last_seq=4&token=secret.jwt.value", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NotContains(t, logged.String(), "secret.jwt.value")
+	assert.Contains(t, logged.String(), "/api/games/3/events?last_seq=4&token=REDACTED")
+
+	assert.Equal(t, "/api/games", redactToken("/api/games"))
+	assert.Equal(t, "/api/leaderboard?tokens_only=1", redactToken("/api/leaderboard?tokens_only=1"))
+	assert.Equal(t, "/ws", redactToken("/ws?token=a;b"))
+}
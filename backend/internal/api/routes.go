@@ -2,45 +2,156 @@ package api
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"battleship-go/internal/account"
+	"battleship-go/internal/admin"
 	"battleship-go/internal/auth"
+	"battleship-go/internal/chat"
 	"battleship-go/internal/cleanup"
+	"battleship-go/internal/config"
+	"battleship-go/internal/events"
 	"battleship-go/internal/game"
+	"battleship-go/internal/mail"
 	"battleship-go/internal/models"
+	"battleship-go/internal/notify"
+	"battleship-go/internal/oauth"
+	"battleship-go/internal/protocol"
+	"battleship-go/internal/ratelimit"
+	"battleship-go/internal/social"
 	"battleship-go/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 )
 
 type API struct {
-	authService    *auth.AuthService
-	gameService    *game.GameService
-	cleanupService *cleanup.CleanupService
-	hub            *websocket.Hub
-	db             *sql.DB
+	authService         *auth.AuthService
+	accountService      *account.AccountService
+	gameService         *game.GameService
+	chatService         *chat.ChatService
+	adminService        *admin.AdminService
+	socialService       *social.SocialService
+	oauthService        *oauth.Service
+	oauthRedirect       string
+	cleanupService      *cleanup.CleanupService
+	limiter             *ratelimit.Limiter
+	hub                 *websocket.Hub
+	db                  *sql.DB
+	notificationService *notify.NotificationService
+	forfeits            *forfeitTimers // nil when disconnect forfeits are disabled
+	events              *events.Log
+	streamPing          time.Duration // keepalive comment interval of event streams
 }
 
-func SetupRoutes(router *gin.Engine, db *sql.DB, hub *websocket.Hub) {
-	authService := auth.NewAuthService(db, "your-jwt-secret-key")
+// SetupRoutes registers the API routes. The cleanup service is shared with
+// the scheduler that main runs, so manual and scheduled runs use one lock.
+func SetupRoutes(router *gin.Engine, db *sql.DB, hub *websocket.Hub, cleanupService *cleanup.CleanupService, cfg *config.Config) error {
+	keys, err := auth.LoadKeySet(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load JWT keys: %w", err)
+	}
+
+	mailer, err := mail.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup mailer: %w", err)
+	}
+
+	var limiterStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == config.RateLimitStorePostgres {
+		limiterStore = ratelimit.NewSQLStore(db)
+	}
+
+	var chatWords []string
+	if cfg.Chat.WordlistFile != "" {
+		if chatWords, err = chat.LoadWordlist(cfg.Chat.WordlistFile); err != nil {
+			return fmt.Errorf("failed to load chat wordlist: %w", err)
+		}
+	}
+	chatPipeline := chat.NewPipeline(chat.PipelineOptions{
+		MaxLength:   cfg.Chat.MaxLength,
+		Words:       chatWords,
+		RejectWords: cfg.Chat.FilterMode == config.ChatFilterReject,
+		StripLinks:  cfg.Chat.StripLinks,
+	})
+
+	authService := auth.NewAuthServiceWithKeys(db, keys)
+	authService.SetMailer(mailer, cfg.Server.AppBaseURL)
+	authService.SetTokenTTL(cfg.JWT.TokenTTL)
 	gameService := game.NewGameService(db)
-	cleanupService := cleanup.NewCleanupService(db)
+	gameService.SetBoardSize(cfg.Game.BoardSize)
 
 	api := &API{
-		authService:    authService,
-		gameService:    gameService,
-		cleanupService: cleanupService,
-		hub:            hub,
-		db:             db,
+		authService:         authService,
+		accountService:      account.NewAccountService(db, authService, gameService),
+		gameService:         gameService,
+		chatService:         chat.NewChatService(db, chatPipeline),
+		adminService:        admin.NewAdminService(db, gameService),
+		socialService:       social.NewSocialService(db),
+		oauthService:        oauth.NewService(db, authService, cfg.OAuth.Providers),
+		oauthRedirect:       cfg.OAuth.RedirectURL,
+		cleanupService:      cleanupService,
+		limiter:             ratelimit.NewLimiter(limiterStore),
+		hub:                 hub,
+		db:                  db,
+		notificationService: notify.NewNotificationService(db, &hubChannel{hub: hub}),
+		streamPing:          cfg.WebSocket.PingInterval,
+	}
+	if len(cfg.Notify.EmailTypes) > 0 {
+		api.notificationService.AddChannel(notify.NewEmailChannel(db, mailer, cfg.Server.AppBaseURL, cfg.Notify.EmailTypes,
+			func(userID int) bool { return hub.Presence(userID).Status != websocket.PresenceOffline }))
+	}
+	hub.SetPresenceHandler(api.broadcastPresence)
+	hub.SetChatFilter(api.chatRecipients)
+	hub.SetChatHandler(api.receiveChat)
+	hub.SetQuickChatHandler(api.receiveQuickChat)
+	hub.SetConnectHandler(api.missedNotifications)
+	hub.SetUnwrittenHandler(api.notificationsUnwritten)
+	hub.SetKeepAlive(websocket.KeepAlive{
+		PingInterval:   cfg.WebSocket.PingInterval,
+		PongTimeout:    cfg.WebSocket.PongTimeout,
+		WriteTimeout:   cfg.WebSocket.WriteTimeout,
+		MaxMessageSize: cfg.WebSocket.MaxMessageSize,
+		SendQueueSize:  cfg.WebSocket.SendQueueSize,
+		SlowConsumer:   websocket.SlowConsumerPolicy(cfg.WebSocket.SlowConsumer),
+	})
+	if cfg.WebSocket.ForfeitAfter > 0 {
+		api.forfeits = newForfeitTimers(cfg.WebSocket.ForfeitAfter)
 	}
+	hub.SetGameConnectionHandler(api.gameConnectionChanged)
+	cleanupService.SetGameEndedHandler(api.inactiveGameEnded)
+	cleanupService.SetInactivityWarningHandler(api.warnInactivePlayer)
+	hub.SetStillHereHandler(api.stillHere)
+	api.events = events.NewLog(db, events.DefaultBufferSize, events.DefaultMaxReplay)
+	hub.SetEventLog(api.events)
+	api.accountService.SetEventLog(api.events)
+	cleanupService.SetGuestAnonymizer(api.anonymizeGuest)
 
 	// Public routes
-	router.POST("/api/auth/register", api.register)
-	router.POST("/api/auth/login", api.login)
+	router.POST("/api/auth/register", api.rateLimit(registerPolicy), api.register)
+	router.POST("/api/auth/login", api.rateLimit(loginPolicy), api.login)
+	router.POST("/api/auth/login/2fa", api.rateLimit(twoFactorLoginPolicy), api.loginTwoFactor)
+	router.POST("/api/auth/guest", api.rateLimit(guestPolicy), api.createGuest)
+	router.POST("/api/auth/forgot-password", api.rateLimit(accountEmailPolicy), api.forgotPassword)
+	router.POST("/api/auth/reset-password", api.rateLimit(accountEmailPolicy), api.resetPassword)
+	router.POST("/api/auth/verify-email", api.rateLimit(accountEmailPolicy), api.verifyEmail)
+	router.GET("/api/auth/oauth/providers", api.getOAuthProviders)
+	router.GET("/api/auth/oauth/:provider/login", api.oauthLogin)
+	router.GET("/api/auth/oauth/:provider/callback", api.oauthCallback)
+	router.GET("/.well-known/jwks.json", api.getJWKS)
+
+	// WebSocket endpoint and its fallbacks for clients behind proxies that
+	// block upgrades. They take the token as a query parameter.
+	router.GET("/ws", api.tokenFromQuery(), api.authMiddleware(), api.handleWebSocket)
+	router.GET("/api/games/:id/events", api.tokenFromQuery(), api.authMiddleware(), api.streamGameEvents)
+	router.GET("/api/games/:id/events/poll", api.tokenFromQuery(), api.authMiddleware(), api.pollGameEvents)
+	router.GET("/ws/schema", api.getProtocolSchema)
 
 	// Protected routes
 	protected := router.Group("/api")
@@ -49,10 +160,22 @@ func SetupRoutes(router *gin.Engine, db *sql.DB, hub *websocket.Hub) {
 		// User routes
 		protected.GET("/user/profile", api.getUserProfile)
 		protected.GET("/user/stats", api.getUserStats)
+		protected.PUT("/user/username", api.changeUsername)
+		protected.PUT("/user/email", api.requireAccount(), api.rateLimit(accountEmailPolicy), api.changeEmail)
+		protected.PUT("/user/password", api.requireAccount(), api.rateLimit(loginPolicy), api.changePassword)
+		protected.DELETE("/user", api.rateLimit(loginPolicy), api.deleteAccount)
+		protected.GET("/user/export", api.exportAccount)
+		protected.POST("/auth/upgrade", api.rateLimit(registerPolicy), api.upgradeGuest)
+		protected.POST("/auth/oauth/:provider/link", api.requireAccount(), api.oauthLink)
+		protected.POST("/user/verify-email/resend", api.requireAccount(), api.rateLimit(accountEmailPolicy), api.resendVerificationEmail)
+		protected.POST("/user/2fa/enroll", api.requireAccount(), api.enrollTwoFactor)
+		protected.POST("/user/2fa/activate", api.requireAccount(), api.rateLimit(twoFactorLoginPolicy), api.activateTwoFactor)
+		protected.POST("/user/2fa/disable", api.requireAccount(), api.rateLimit(twoFactorLoginPolicy), api.disableTwoFactor)
 
 		// Game routes
 		protected.POST("/games", api.createGame)
 		protected.POST("/games/:id/join", api.joinGame)
+		protected.POST("/games/:id/decline", api.declineInvite)
 		protected.GET("/games", api.getGames)
 		protected.GET("/games/available", api.getAvailableGames)
 		protected.GET("/games/:id", api.getGame)
@@ -60,20 +183,76 @@ func SetupRoutes(router *gin.Engine, db *sql.DB, hub *websocket.Hub) {
 		protected.GET("/games/:id/ships/sunk", api.getSunkShips)
 		protected.GET("/games/:id/ready", api.checkGameReady)
 		protected.POST("/games/:id/ships", api.placeShips)
-		protected.POST("/games/:id/moves", api.makeMove)
+		protected.POST("/games/:id/moves", api.rateLimit(movePolicy), api.makeMove)
 		protected.GET("/games/:id/moves", api.getGameMoves)
+		protected.POST("/games/:id/still-here", api.rateLimit(movePolicy), api.markStillHere)
 
 		// Chat routes
-		protected.POST("/games/:id/chat", api.sendChatMessage)
+		protected.POST("/games/:id/chat", api.rateLimit(chatPolicy), api.sendChatMessage)
 		protected.GET("/games/:id/chat", api.getChatMessages)
+		protected.POST("/games/:id/chat/read", api.markGameChatRead)
+		protected.POST("/games/:id/quick-chat", api.sendQuickChat)
+		protected.GET("/games/:id/quick-chat", api.getQuickChat)
+		protected.GET("/chat/quick-chat", api.getQuickChatCatalog)
+		protected.POST("/chat/:id/report", api.rateLimit(reportPolicy), api.reportChatMessage)
+		protected.GET("/chat/channels", api.getChatChannels)
+		protected.GET("/chat/channels/:id/messages", api.getChannelMessages)
+		protected.POST("/chat/channels/:id/messages", api.rateLimit(chatPolicy), api.postChannelMessage)
+		protected.POST("/chat/channels/:id/read", api.markChannelRead)
+		protected.POST("/chat/dm/:userId", api.requireAccount(), api.openDirectChannel)
+
+		// Notifications
+		protected.GET("/notifications", api.getNotifications)
+		protected.POST("/notifications/read", api.markAllNotificationsRead)
+		protected.POST("/notifications/:id/read", api.markNotificationRead)
+
+		// Friends and blocks
+		friends := protected.Group("", api.requireAccount())
+		friends.GET("/friends", api.getFriends)
+		friends.DELETE("/friends/:id", api.removeFriend)
+		friends.POST("/friends/:id/challenge", api.challengeFriend)
+		friends.GET("/friends/requests", api.getFriendRequests)
+		friends.POST("/friends/requests", api.rateLimit(friendRequestPolicy), api.sendFriendRequest)
+		friends.POST("/friends/requests/:id/accept", api.acceptFriendRequest)
+		friends.DELETE("/friends/requests/:id", api.deleteFriendRequest)
+		protected.GET("/blocks", api.getBlockedUsers)
+		protected.POST("/blocks/:id", api.blockUser)
+		protected.DELETE("/blocks/:id", api.unblockUser)
 
 		// Leaderboard
 		protected.GET("/leaderboard", api.getLeaderboard)
+	}
 
-		// Admin/Cleanup routes
-		protected.GET("/admin/cleanup/status", api.getCleanupStatus)
-		protected.POST("/admin/cleanup/run", api.runCleanup)
+	// Moderation routes
+	modGroup := router.Group("/api/moderation")
+	modGroup.Use(api.authMiddleware(), api.requireRole(models.RoleModerator, models.RoleAdmin))
+	{
+		modGroup.POST("/users/:id/mute", api.adminMuteUser)
+		modGroup.DELETE("/users/:id/mute", api.adminUnmuteUser)
+		modGroup.GET("/reports", api.modListReports)
+		modGroup.POST("/reports/:id/resolve", api.modResolveReport)
+		modGroup.DELETE("/chat/:id", api.modDeleteChatMessage)
+	}
+
+	// Admin routes
+	adminGroup := router.Group("/api/admin")
+	adminGroup.Use(api.authMiddleware(), api.requireRole(models.RoleAdmin))
+	{
+		adminGroup.GET("/users", api.adminListUsers)
+		adminGroup.PUT("/users/:id/role", api.adminSetRole)
+		adminGroup.POST("/users/:id/ban", api.adminBanUser)
+		adminGroup.POST("/users/:id/unban", api.adminUnbanUser)
+		adminGroup.POST("/games/:id/finish", api.adminFinishGame)
+		adminGroup.DELETE("/games/:id", api.adminDeleteGame)
+		adminGroup.GET("/audit", api.adminListAudit)
+		adminGroup.GET("/websocket/stats", api.adminWebSocketStats)
+
+		// Cleanup routes
+		adminGroup.GET("/cleanup/status", api.getCleanupStatus)
+		adminGroup.POST("/cleanup/run", api.runCleanup)
 	}
+
+	return nil
 }
 
 func (a *API) authMiddleware() gin.HandlerFunc {
@@ -93,12 +272,66 @@ func (a *API) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
+		// Role and ban status come from the database so that changes apply
+		// immediately rather than when the token expires.
+		user, err := a.authService.GetUserByID(claims.UserID)
+		if err != nil || user.DeletedAt != nil || auth.TokenRevoked(claims, user) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+		if user.BannedAt != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is banned"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("isGuest", user.IsGuest)
 		c.Next()
 	}
 }
 
+// requireAccount rejects guest users, for features that need a full account.
+func (a *API) requireAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("isGuest") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "A full account is required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireRole allows the request through only if the authenticated user has one of the given roles.
+func (a *API) requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
+func (a *API) getJWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, a.authService.JWKS())
+}
+
+// getProtocolSchema describes every WebSocket message of the current protocol
+// version as JSON Schema, for client code generation and validation.
+func (a *API) getProtocolSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, protocol.GenerateSchemas())
+}
+
 func (a *API) register(c *gin.Context) {
 	var req struct {
 		Username string `json:"username" binding:"required"`
@@ -141,6 +374,24 @@ func (a *API) login(c *gin.Context) {
 	}
 
 	user, token, err := a.authService.Login(req.Username, req.Password)
+	var twoFactor *auth.TwoFactorRequiredError
+	if errors.As(err, &twoFactor) {
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     twoFactor.ChallengeToken,
+		})
+		return
+	}
+	var locked *auth.AccountLockedError
+	if errors.As(err, &locked) {
+		setRetryAfter(c, locked.RetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err == auth.ErrUserBanned {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -152,6 +403,241 @@ func (a *API) login(c *gin.Context) {
 	})
 }
 
+func (a *API) createGuest(c *gin.Context) {
+	user, token, err := a.authService.CreateGuest()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create guest account"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"user":  user,
+		"token": token,
+	})
+}
+
+func (a *API) upgradeGuest(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required,min=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.authService.UpgradeGuest(c.GetInt("userID"), req.Username, req.Email, req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The guest token expires soon, so hand out a regular one
+	token, err := a.authService.GenerateToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  user,
+		"token": token,
+	})
+}
+
+func (a *API) loginTwoFactor(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, token, err := a.authService.CompleteTwoFactorLogin(req.ChallengeToken, req.Code)
+	var locked *auth.AccountLockedError
+	switch {
+	case err == nil:
+	case errors.As(err, &locked):
+		setRetryAfter(c, locked.RetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case err == auth.ErrUserBanned:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err == auth.ErrInvalidToken, err == auth.ErrInvalidTwoFactorCode, err == auth.ErrTwoFactorNotEnabled:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  user,
+		"token": token,
+	})
+}
+
+func (a *API) forgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.authService.RequestPasswordReset(req.Email); err != nil {
+		log.Printf("Failed to process password reset request: %v", err)
+	}
+
+	// Same response whether or not the email is registered
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+func (a *API) resetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.authService.ResetPassword(req.Token, req.Password); err != nil {
+		if err == auth.ErrInvalidToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func (a *API) verifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.authService.VerifyEmail(req.Token); err != nil {
+		if err == auth.ErrInvalidToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+func (a *API) resendVerificationEmail(c *gin.Context) {
+	user, err := a.authService.GetUserByID(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := a.authService.SendEmailVerification(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+func (a *API) enrollTwoFactor(c *gin.Context) {
+	secret, uri, err := a.authService.BeginTOTPEnrollment(c.GetInt("userID"))
+	if err == auth.ErrTwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+func (a *API) activateTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := a.authService.ActivateTOTP(c.GetInt("userID"), req.Code)
+	switch {
+	case err == nil:
+	case err == auth.ErrTwoFactorEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err == auth.ErrInvalidTwoFactorCode, err == auth.ErrTwoFactorNotEnrolled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate two-factor authentication"})
+		return
+	}
+
+	// Recovery codes are only ever shown here
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (a *API) disableTwoFactor(c *gin.Context) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := a.authService.DisableTOTP(c.GetInt("userID"), req.Password, req.Code)
+	var locked *auth.AccountLockedError
+	switch {
+	case err == nil:
+	case errors.As(err, &locked):
+		setRetryAfter(c, locked.RetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
 func (a *API) getUserProfile(c *gin.Context) {
 	userID := c.GetInt("userID")
 	user, err := a.authService.GetUserByID(userID)
@@ -178,21 +664,24 @@ func (a *API) getUserStats(c *gin.Context) {
 
 func (a *API) createGame(c *gin.Context) {
 	userID := c.GetInt("userID")
-	game, err := a.gameService.CreateGame(userID)
+
+	// The body is optional; without one the game allows free chat
+	var req struct {
+		ChatMode string `json:"chat_mode" binding:"omitempty,oneof=free quick"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	game, err := a.gameService.CreateGame(userID, req.ChatMode)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Broadcast new game creation to all connected clients
-	gameCreateMsg := map[string]interface{}{
-		"type":    "new_game_created",
-		"data":    game,
-		"message": "new_game_available",
-	}
-	if msgBytes, err := json.Marshal(gameCreateMsg); err == nil {
-		a.hub.BroadcastToAll(msgBytes)
-	}
+	a.hub.BroadcastToAll(protocol.New(protocol.GameCreated{Game: *game}))
 
 	c.JSON(http.StatusCreated, game)
 }
@@ -205,6 +694,15 @@ func (a *API) joinGame(c *gin.Context) {
 		return
 	}
 
+	// A blocked user cannot join the other's waiting games
+	var creatorID int
+	if err := a.db.QueryRow("SELECT player1_id FROM games WHERE id = $1", gameID).Scan(&creatorID); err == nil {
+		if blocked, err := a.socialService.IsBlocked(userID, creatorID); err != nil || blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": "game is not available for joining"})
+			return
+		}
+	}
+
 	game, err := a.gameService.JoinGame(gameID, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -212,15 +710,10 @@ func (a *API) joinGame(c *gin.Context) {
 	}
 
 	// Broadcast game update to all clients in the game
-	gameUpdateMsg := map[string]interface{}{
-		"type":    "game_update",
-		"game_id": gameID,
-		"data":    game,
-		"message": "player_joined",
-	}
-	if msgBytes, err := json.Marshal(gameUpdateMsg); err == nil {
-		a.hub.BroadcastToGame(gameID, msgBytes)
-	}
+	a.hub.BroadcastToGame(gameID, protocol.New(protocol.GameUpdate{
+		Reason: protocol.ReasonPlayerJoined,
+		Game:   game,
+	}).ForGame(gameID))
 
 	c.JSON(http.StatusOK, game)
 }
@@ -228,9 +721,11 @@ func (a *API) joinGame(c *gin.Context) {
 func (a *API) getGames(c *gin.Context) {
 	userID := c.GetInt("userID")
 	rows, err := a.db.Query(`
-		SELECT id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at
-		FROM games WHERE (player1_id = $1 OR player2_id = $1)
-		   OR (status = 'waiting' AND player2_id IS NULL AND player1_id != $1)
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, invited_player_id, board_size, created_at, updated_at
+		FROM games WHERE ((player1_id = $1 OR player2_id = $1) AND archived_at IS NULL)
+		   OR (status = 'waiting' AND player2_id IS NULL AND player1_id != $1
+		       AND (invited_player_id IS NULL OR invited_player_id = $1)
+		       AND NOT EXISTS (`+blockedBetweenQuery+`))
 		ORDER BY updated_at DESC`, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -243,21 +738,32 @@ func (a *API) getGames(c *gin.Context) {
 	for rows.Next() {
 		var game models.Game
 		err := rows.Scan(&game.ID, &game.Player1ID, &game.Player2ID, &game.Status,
-			&game.CurrentTurn, &game.WinnerID, &game.CreatedAt, &game.UpdatedAt)
+			&game.CurrentTurn, &game.WinnerID, &game.InvitedPlayerID, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
 		if err != nil {
 			continue
 		}
 		games = append(games, game)
 	}
 
+	unread, err := a.chatService.UnreadGameCounts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range games {
+		games[i].UnreadChat = unread[games[i].ID]
+	}
+
 	c.JSON(http.StatusOK, games)
 }
 
 func (a *API) getAvailableGames(c *gin.Context) {
 	userID := c.GetInt("userID")
 	rows, err := a.db.Query(`
-		SELECT id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, invited_player_id, board_size, created_at, updated_at
 		FROM games WHERE status = 'waiting' AND player2_id IS NULL AND player1_id != $1
+		  AND (invited_player_id IS NULL OR invited_player_id = $1)
+		  AND NOT EXISTS (`+blockedBetweenQuery+`)
 		ORDER BY created_at DESC`, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -270,7 +776,7 @@ func (a *API) getAvailableGames(c *gin.Context) {
 	for rows.Next() {
 		var game models.Game
 		err := rows.Scan(&game.ID, &game.Player1ID, &game.Player2ID, &game.Status,
-			&game.CurrentTurn, &game.WinnerID, &game.CreatedAt, &game.UpdatedAt)
+			&game.CurrentTurn, &game.WinnerID, &game.InvitedPlayerID, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -289,10 +795,10 @@ func (a *API) getGame(c *gin.Context) {
 
 	var game models.Game
 	err = a.db.QueryRow(`
-		SELECT id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at 
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, chat_mode, board_size, created_at, updated_at 
 		FROM games WHERE id = $1`, gameID).Scan(
 		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status,
-		&game.CurrentTurn, &game.WinnerID, &game.CreatedAt, &game.UpdatedAt)
+		&game.CurrentTurn, &game.WinnerID, &game.ChatMode, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
 		return
@@ -422,15 +928,7 @@ func (a *API) placeShips(c *gin.Context) {
 	}
 
 	// Broadcast ship placement update to all clients in the game
-	shipPlacementMsg := map[string]interface{}{
-		"type":    "ship_placement_update",
-		"game_id": gameID,
-		"user_id": userID,
-		"message": "ships_placed",
-	}
-	if msgBytes, err := json.Marshal(shipPlacementMsg); err == nil {
-		a.hub.BroadcastToGame(gameID, msgBytes)
-	}
+	a.hub.BroadcastToGame(gameID, protocol.New(protocol.ShipPlacementUpdate{UserID: userID}).ForGame(gameID))
 
 	c.JSON(http.StatusOK, gin.H{"message": "Ships placed successfully"})
 }
@@ -444,8 +942,8 @@ func (a *API) makeMove(c *gin.Context) {
 	}
 
 	var req struct {
-		X *int `json:"x" binding:"required,min=0,max=9"`
-		Y *int `json:"y" binding:"required,min=0,max=9"`
+		X *int `json:"x" binding:"required,min=0"`
+		Y *int `json:"y" binding:"required,min=0"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -466,14 +964,11 @@ func (a *API) makeMove(c *gin.Context) {
 	fmt.Printf("Move successful: %+v\n", move)
 
 	// Broadcast game update to all clients in the game
-	gameUpdateMsg := map[string]interface{}{
-		"type":    "game_update",
-		"game_id": gameID,
-		"data":    move,
-	}
-	if msgBytes, err := json.Marshal(gameUpdateMsg); err == nil {
-		a.hub.BroadcastToGame(gameID, msgBytes)
-	}
+	a.hub.BroadcastToGame(gameID, protocol.New(protocol.GameUpdate{
+		Reason: protocol.ReasonMove,
+		Move:   move,
+	}).ForGame(gameID))
+	a.notifyTurn(gameID, userID)
 
 	c.JSON(http.StatusOK, move)
 }
@@ -509,85 +1004,12 @@ func (a *API) getGameMoves(c *gin.Context) {
 	c.JSON(http.StatusOK, moves)
 }
 
-func (a *API) sendChatMessage(c *gin.Context) {
-	userID := c.GetInt("userID")
-	gameID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
-		return
-	}
-
-	var req struct {
-		Message string `json:"message" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	var chatMessage models.ChatMessage
-	err = a.db.QueryRow(`
-		INSERT INTO chat_messages (game_id, player_id, message) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, game_id, player_id, message, created_at`,
-		gameID, userID, req.Message).Scan(
-		&chatMessage.ID, &chatMessage.GameID, &chatMessage.PlayerID,
-		&chatMessage.Message, &chatMessage.CreatedAt)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Broadcast chat message to all clients in the game
-	chatMsg := map[string]interface{}{
-		"type":    "chat",
-		"game_id": gameID,
-		"data":    chatMessage,
-	}
-	if msgBytes, err := json.Marshal(chatMsg); err == nil {
-		a.hub.BroadcastToGame(gameID, msgBytes)
-	}
-
-	c.JSON(http.StatusCreated, chatMessage)
-}
-
-func (a *API) getChatMessages(c *gin.Context) {
-	gameID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
-		return
-	}
-
-	rows, err := a.db.Query(`
-		SELECT id, game_id, player_id, message, created_at 
-		FROM chat_messages WHERE game_id = $1 ORDER BY created_at`, gameID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer rows.Close()
-
-	// Initialize with empty slice to ensure JSON returns [] instead of null
-	messages := make([]models.ChatMessage, 0)
-	for rows.Next() {
-		var message models.ChatMessage
-		err := rows.Scan(&message.ID, &message.GameID, &message.PlayerID,
-			&message.Message, &message.CreatedAt)
-		if err != nil {
-			continue
-		}
-		messages = append(messages, message)
-	}
-
-	c.JSON(http.StatusOK, messages)
-}
-
 func (a *API) getLeaderboard(c *gin.Context) {
 	rows, err := a.db.Query(`
 		SELECT s.id, s.player_id, u.username, s.wins, s.losses, s.hits, s.misses, s.points 
 		FROM scores s 
 		JOIN users u ON s.player_id = u.id 
+		WHERE u.is_guest = FALSE AND u.deleted_at IS NULL 
 		ORDER BY s.points DESC LIMIT 10`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -624,18 +1046,57 @@ func (a *API) getCleanupStatus(c *gin.Context) {
 		return
 	}
 
+	guests, err := a.cleanupService.GetAbandonedGuestsCount()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiredGuests, err := a.cleanupService.GetExpiredGuestsCount()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	runs, err := a.cleanupService.History(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	options := a.cleanupService.Options()
 	c.JSON(http.StatusOK, gin.H{
-		"inactive_games_count": count,
-		"cleanup_threshold":    "1 hour",
+		"inactive_games_count":   count,
+		"active_timeout":         options.ActiveTimeout.String(),
+		"waiting_timeout":        options.WaitingTimeout.String(),
+		"archive_after":          options.ArchiveAfter.String(),
+		"abandoned_guests_count": guests,
+		"expired_guests_count":   expiredGuests,
+		"runs":                   runs,
 	})
 }
 
 func (a *API) runCleanup(c *gin.Context) {
-	err := a.cleanupService.CleanupInactiveGames()
+	userID := c.GetInt("userID")
+	dryRun := c.Query("dry_run") == "true"
+	run, err := a.cleanupService.Run(c.Request.Context(), cleanup.RunOptions{DryRun: dryRun, TriggeredBy: &userID})
+	if errors.Is(err, cleanup.ErrRunInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "Dry run completed, nothing was changed", "run": run})
+		return
+	}
+
+	if err := a.adminService.RecordAudit(userID, admin.ActionRunCleanup, admin.TargetTypeSystem, run.ID, nil); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Cleanup completed successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Cleanup completed successfully", "run": run})
 }
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"battleship-go/internal/protocol"
+	"battleship-go/internal/social"
+	"battleship-go/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockedBetweenQuery matches a block in either direction between the user in
+// $1 and the creator of the game row being filtered.
+const blockedBetweenQuery = `
+	SELECT 1 FROM user_blocks
+	WHERE (blocker_id = $1 AND blocked_id = player1_id) OR (blocker_id = player1_id AND blocked_id = $1)`
+
+// friendWithPresence is a friend together with their live status.
+type friendWithPresence struct {
+	social.Friend
+	Presence websocket.Presence `json:"presence"`
+}
+
+// chatRecipients returns whether a chat message from senderID may be
+// delivered to a recipient. Muted senders reach nobody and blocks work in
+// both directions. The sender's mute and blocks are loaded once, so the
+// result is cheap to call for every recipient.
+func (a *API) chatRecipients(senderID int) func(recipientID int) bool {
+	mutedUntil, err := a.socialService.MutedUntil(senderID)
+	if err != nil || mutedUntil != nil {
+		return func(int) bool { return false }
+	}
+	blocked, err := a.socialService.BlockedWith(senderID)
+	if err != nil {
+		log.Printf("Failed to load blocks of user %d for chat: %v", senderID, err)
+		return func(int) bool { return false }
+	}
+	return func(recipientID int) bool {
+		return !blocked[recipientID]
+	}
+}
+
+// broadcastPresence tells a user's friends that their presence changed.
+func (a *API) broadcastPresence(userID int, presence websocket.Presence) {
+	friendIDs, err := a.socialService.FriendIDs(userID)
+	if err != nil {
+		log.Printf("Failed to load friends of user %d for presence: %v", userID, err)
+		return
+	}
+
+	event := protocol.New(protocol.Presence{UserID: userID, Status: presence.Status, GameID: presence.GameID, Version: presence.Version})
+	for _, friendID := range friendIDs {
+		a.hub.SendToUser(friendID, event)
+	}
+}
+
+func (a *API) getFriends(c *gin.Context) {
+	friends, err := a.socialService.ListFriends(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load friends"})
+		return
+	}
+
+	result := make([]friendWithPresence, 0, len(friends))
+	for _, friend := range friends {
+		result = append(result, friendWithPresence{Friend: friend, Presence: a.hub.Presence(friend.UserID)})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *API) getFriendRequests(c *gin.Context) {
+	requests, err := a.socialService.ListFriendRequests(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load friend requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func (a *API) sendFriendRequest(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	targetID, err := a.socialService.FindUser(req.Username)
+	if err != nil {
+		respondSocialError(c, err)
+		return
+	}
+
+	accepted, err := a.socialService.SendFriendRequest(userID, targetID)
+	if err != nil {
+		respondSocialError(c, err)
+		return
+	}
+
+	username := c.GetString("username")
+	if accepted {
+		a.notifyUser(targetID, protocol.FriendAccepted{UserID: userID, Username: username})
+		c.JSON(http.StatusOK, gin.H{"status": social.FriendshipAccepted})
+		return
+	}
+
+	a.notifyUser(targetID, protocol.FriendRequest{UserID: userID, Username: username})
+	c.JSON(http.StatusCreated, gin.H{"status": social.FriendshipPending})
+}
+
+func (a *API) acceptFriendRequest(c *gin.Context) {
+	requesterID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	if err := a.socialService.AcceptFriendRequest(userID, requesterID); err != nil {
+		respondSocialError(c, err)
+		return
+	}
+
+	a.notifyUser(requesterID, protocol.FriendAccepted{UserID: userID, Username: c.GetString("username")})
+	c.JSON(http.StatusOK, gin.H{"status": social.FriendshipAccepted})
+}
+
+func (a *API) deleteFriendRequest(c *gin.Context) {
+	otherID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := a.socialService.CancelFriendRequest(c.GetInt("userID"), otherID); err != nil {
+		respondSocialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend request removed"})
+}
+
+func (a *API) removeFriend(c *gin.Context) {
+	friendID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := a.socialService.RemoveFriend(c.GetInt("userID"), friendID); err != nil {
+		respondSocialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend removed"})
+}
+
+// challengeFriend creates a game reserved for a friend and invites them to it.
+func (a *API) challengeFriend(c *gin.Context) {
+	friendID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	friends, err := a.socialService.AreFriends(userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check friendship"})
+		return
+	}
+	if !friends {
+		respondSocialError(c, social.ErrNotFriends)
+		return
+	}
+
+	game, err := a.gameService.CreateInviteGame(userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.notifyUser(friendID, protocol.GameInvite{
+		Game:     game,
+		GameID:   game.ID,
+		UserID:   userID,
+		Username: c.GetString("username"),
+	})
+
+	c.JSON(http.StatusCreated, game)
+}
+
+func (a *API) declineInvite(c *gin.Context) {
+	gameID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	game, err := a.gameService.DeclineInvite(gameID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.notifyUser(game.Player1ID, protocol.GameInviteDeclined{
+		GameID:   gameID,
+		UserID:   userID,
+		Username: c.GetString("username"),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite declined"})
+}
+
+func (a *API) getBlockedUsers(c *gin.Context) {
+	blocked, err := a.socialService.ListBlocked(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load blocked users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, blocked)
+}
+
+func (a *API) blockUser(c *gin.Context) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := a.socialService.Block(c.GetInt("userID"), targetID); err != nil {
+		respondSocialError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User blocked"})
+}
+
+func (a *API) unblockUser(c *gin.Context) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := a.socialService.Unblock(c.GetInt("userID"), targetID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not blocked"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unblocked"})
+}
+
+// respondSocialError maps social service errors to status codes.
+func respondSocialError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, social.ErrUserNotFound), errors.Is(err, social.ErrRequestNotFound), errors.Is(err, social.ErrNotFriends):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, social.ErrAlreadyFriends), errors.Is(err, social.ErrRequestExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, social.ErrBlocked):
+		// Do not reveal who blocked whom
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot send a friend request to this user"})
+	case errors.Is(err, social.ErrSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Request failed"})
+	}
+}
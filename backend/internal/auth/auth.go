@@ -3,33 +3,87 @@ package auth
 import (
 	"database/sql"
 	"errors"
+	"log"
+	"strings"
 	"time"
 
+	"battleship-go/internal/mail"
 	"battleship-go/internal/models"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Session token lifetimes. Guest tokens are the only way into a guest
+// account, so a guest that loses its token is abandoned.
+const (
+	defaultTokenTTL = 24 * time.Hour
+	GuestTokenTTL   = 12 * time.Hour
+)
+
+var (
+	// ErrUserBanned is returned when a banned user tries to authenticate.
+	ErrUserBanned = errors.New("account is banned")
+	// ErrInvalidCredentials is returned for an unknown username or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// dummyPasswordHash is checked when no user matches a login, so an unknown
+// username takes as long to reject as a wrong password.
+const dummyPasswordHash = "$2a$10$KokM9vcHBbxX5yrr.BXGLOILutKxo7nXzvnPSCH1tXVWEUW7O1Qdu"
+
 type AuthService struct {
-	db        *sql.DB
-	jwtSecret []byte
+	db       *sql.DB
+	keys     *KeySet
+	mailer   mail.Mailer
+	baseURL  string
+	tokenTTL time.Duration
 }
 
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	Guest    bool   `json:"guest,omitempty"`
+	// Purpose marks restricted tokens, such as the 2FA login challenge. Regular
+	// session tokens have no purpose.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func NewAuthService(db *sql.DB, jwtSecret string) *AuthService {
+	return NewAuthServiceWithKeys(db, NewHMACKeySet(DefaultKeyID, []byte(jwtSecret)))
+}
+
+// NewAuthServiceWithKeys creates an auth service that signs and verifies
+// tokens with the given key set.
+func NewAuthServiceWithKeys(db *sql.DB, keys *KeySet) *AuthService {
 	return &AuthService{
-		db:        db,
-		jwtSecret: []byte(jwtSecret),
+		db:       db,
+		keys:     keys,
+		mailer:   mail.NewLogMailer(),
+		baseURL:  "http://localhost:3000",
+		tokenTTL: defaultTokenTTL,
 	}
 }
 
+// SetTokenTTL sets the lifetime of session tokens for registered users.
+func (a *AuthService) SetTokenTTL(ttl time.Duration) {
+	a.tokenTTL = ttl
+}
+
+// SetMailer configures how account emails are delivered and the frontend
+// base URL used in the links they contain.
+func (a *AuthService) SetMailer(mailer mail.Mailer, baseURL string) {
+	a.mailer = mailer
+	a.baseURL = strings.TrimRight(baseURL, "/")
+}
+
 func (a *AuthService) Register(username, email, password string) (*models.User, error) {
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
 	// Check if user already exists
 	var exists bool
 	err := a.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)", username, email).Scan(&exists)
@@ -51,9 +105,9 @@ func (a *AuthService) Register(username, email, password string) (*models.User,
 	err = a.db.QueryRow(`
 		INSERT INTO users (username, email, password_hash) 
 		VALUES ($1, $2, $3) 
-		RETURNING id, username, email, created_at, updated_at`,
+		RETURNING id, username, email, role, created_at, updated_at`,
 		username, email, string(hashedPassword)).Scan(
-		&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+		&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -64,74 +118,158 @@ func (a *AuthService) Register(username, email, password string) (*models.User,
 		return nil, err
 	}
 
+	// The account is usable right away; a failed email can be resent later
+	if err := a.SendEmailVerification(&user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	return &user, nil
 }
 
 func (a *AuthService) Login(username, password string) (*models.User, string, error) {
 	var user models.User
 	var hashedPassword string
+	var failedLogins int
+	var lockedUntil *time.Time
 
 	err := a.db.QueryRow(`
-		SELECT id, username, email, password_hash, created_at, updated_at 
+		SELECT id, username, email, password_hash, role, banned_at, email_verified_at, 
+		       failed_login_count, locked_until, created_at, updated_at 
 		FROM users WHERE username = $1`, username).Scan(
-		&user.ID, &user.Username, &user.Email, &hashedPassword, &user.CreatedAt, &user.UpdatedAt)
+		&user.ID, &user.Username, &user.Email, &hashedPassword, &user.Role, &user.BannedAt,
+		&user.EmailVerifiedAt, &failedLogins, &lockedUntil, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, "", errors.New("invalid credentials")
+			bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+			return nil, "", ErrInvalidCredentials
 		}
 		return nil, "", err
 	}
 
+	// Locked accounts are rejected before the comparatively expensive bcrypt check
+	if err := checkLocked(lockedUntil); err != nil {
+		return nil, "", err
+	}
+
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
-		return nil, "", errors.New("invalid credentials")
+		if err := a.recordFailedLogin(user.ID); err != nil {
+			log.Printf("Failed to record failed login for user %d: %v", user.ID, err)
+		}
+		return nil, "", ErrInvalidCredentials
 	}
 
-	// Generate JWT token
-	token, err := a.GenerateToken(&user)
+	if user.BannedAt != nil {
+		return nil, "", ErrUserBanned
+	}
+
+	token, err := a.IssueLoginToken(&user)
 	if err != nil {
 		return nil, "", err
 	}
 
+	// With 2FA enabled the count is only reset once the second factor succeeds
+	if failedLogins > 0 {
+		if err := a.resetFailedLogins(user.ID); err != nil {
+			log.Printf("Failed to reset failed logins for user %d: %v", user.ID, err)
+		}
+	}
+
 	return &user, token, nil
 }
 
+// VerifyPassword checks a user's current password, for confirming sensitive
+// account changes. Accounts without a password never match.
+func (a *AuthService) VerifyPassword(userID int, password string) error {
+	var hashedPassword string
+	err := a.db.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&hashedPassword)
+	if err != nil {
+		return err
+	}
+	if hashedPassword == "" || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// IssueLoginToken returns a session token for a user who has passed the
+// first authentication factor. Users with two-factor authentication enabled
+// get a *TwoFactorRequiredError carrying a challenge token instead.
+func (a *AuthService) IssueLoginToken(user *models.User) (string, error) {
+	var enabled bool
+	err := a.db.QueryRow("SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1", user.ID).Scan(&enabled)
+	if err != nil {
+		return "", err
+	}
+
+	if enabled {
+		challenge, err := a.generateChallengeToken(user)
+		if err != nil {
+			return "", err
+		}
+		return "", &TwoFactorRequiredError{ChallengeToken: challenge}
+	}
+
+	return a.GenerateToken(user)
+}
+
 func (a *AuthService) GenerateToken(user *models.User) (string, error) {
+	ttl := a.tokenTTL
+	if user.IsGuest {
+		ttl = GuestTokenTTL
+	}
+
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
+		Role:     user.Role,
+		Guest:    user.IsGuest,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(a.jwtSecret)
+	return a.keys.sign(claims)
 }
 
 func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return a.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, a.keys.keyFunc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Purpose == "" {
 		return claims, nil
 	}
 
 	return nil, errors.New("invalid token")
 }
 
+// TokenRevoked reports whether a token was issued before the user's last
+// password change. JWT timestamps have second precision, so the change time is
+// truncated to avoid rejecting tokens issued in the same second.
+func TokenRevoked(claims *Claims, user *models.User) bool {
+	if user.PasswordChangedAt == nil || claims.IssuedAt == nil {
+		return false
+	}
+	return claims.IssuedAt.Time.Before(user.PasswordChangedAt.Truncate(time.Second))
+}
+
+// JWKS returns the public verification keys in JWKS format.
+func (a *AuthService) JWKS() JWKS {
+	return a.keys.PublicJWKS()
+}
+
 func (a *AuthService) GetUserByID(userID int) (*models.User, error) {
 	var user models.User
 	err := a.db.QueryRow(`
-		SELECT id, username, email, created_at, updated_at 
+		SELECT id, username, email, role, banned_at, email_verified_at, password_changed_at, 
+		       totp_enabled_at IS NOT NULL, is_guest, deleted_at, created_at, updated_at 
 		FROM users WHERE id = $1`, userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+		&user.ID, &user.Username, &user.Email, &user.Role, &user.BannedAt, &user.EmailVerifiedAt,
+		&user.PasswordChangedAt, &user.TwoFactorEnabled, &user.IsGuest, &user.DeletedAt, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -3,11 +3,15 @@ package auth
 import (
 	"database/sql"
 	"testing"
+	"time"
+
+	"battleship-go/internal/models"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func setupTestDB(t *testing.T) *sql.DB {
@@ -22,9 +26,39 @@ func setupTestDB(t *testing.T) *sql.DB {
 			username TEXT UNIQUE NOT NULL,
 			email TEXT UNIQUE NOT NULL,
 			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'player',
+			banned_at DATETIME,
+			ban_reason TEXT,
+			email_verified_at DATETIME,
+			password_changed_at DATETIME,
+			totp_secret TEXT,
+			totp_enabled_at DATETIME,
+			totp_last_step INTEGER NOT NULL DEFAULT 0,
+			is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+			deleted_at DATETIME,
+			failed_login_count INTEGER NOT NULL DEFAULT 0,
+			locked_until DATETIME,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		);
+
+		CREATE TABLE recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		
+		CREATE TABLE auth_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			purpose TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
 		
 		CREATE TABLE scores (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -70,6 +104,19 @@ func TestAuthService_Register(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "user already exists")
 	})
+
+	t.Run("invalid username", func(t *testing.T) {
+		_, err := authService.Register("bad name!", "bad@example.com", "password123")
+
+		assert.Error(t, err)
+	})
+
+	t.Run("reserved username", func(t *testing.T) {
+		_, err := authService.Register("Deleted-abcdef", "reserved@example.com", "password123")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "reserved")
+	})
 }
 
 func TestAuthService_Login(t *testing.T) {
@@ -98,12 +145,78 @@ func TestAuthService_Login(t *testing.T) {
 		assert.Contains(t, err.Error(), "invalid credentials")
 	})
 
+	t.Run("unknown usernames cost a bcrypt check", func(t *testing.T) {
+		cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+		require.NoError(t, err)
+		assert.Equal(t, bcrypt.DefaultCost, cost)
+	})
+
 	t.Run("invalid password", func(t *testing.T) {
 		_, _, err := authService.Login("testuser", "wrongpassword")
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid credentials")
 	})
+
+	t.Run("banned user", func(t *testing.T) {
+		_, err := db.Exec("UPDATE users SET banned_at = CURRENT_TIMESTAMP WHERE username = $1", "testuser")
+		require.NoError(t, err)
+		defer db.Exec("UPDATE users SET banned_at = NULL WHERE username = $1", "testuser")
+
+		_, _, err = authService.Login("testuser", "password123")
+		assert.ErrorIs(t, err, ErrUserBanned)
+	})
+}
+
+func TestAuthService_Lockout(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	authService := NewAuthService(db, "test-secret")
+	_, err := authService.Register("testuser", "test@example.com", "password123")
+	require.NoError(t, err)
+
+	t.Run("successful login resets the failure count", func(t *testing.T) {
+		for i := 0; i < lockoutThreshold-1; i++ {
+			_, _, err := authService.Login("testuser", "wrongpassword")
+			require.Error(t, err)
+		}
+		_, _, err := authService.Login("testuser", "password123")
+		require.NoError(t, err)
+
+		var failures int
+		require.NoError(t, db.QueryRow("SELECT failed_login_count FROM users WHERE username = 'testuser'").Scan(&failures))
+		assert.Zero(t, failures)
+	})
+
+	t.Run("repeated failures lock the account", func(t *testing.T) {
+		for i := 0; i < lockoutThreshold; i++ {
+			_, _, err := authService.Login("testuser", "wrongpassword")
+			assert.Contains(t, err.Error(), "invalid credentials")
+		}
+
+		// Even the correct password is rejected while locked
+		_, _, err := authService.Login("testuser", "password123")
+		var locked *AccountLockedError
+		require.ErrorAs(t, err, &locked)
+		assert.InDelta(t, lockoutBase, locked.RetryAfter, float64(5*time.Second))
+	})
+
+	t.Run("login works again after the lock expires", func(t *testing.T) {
+		_, err := db.Exec("UPDATE users SET locked_until = $1", time.Now().Add(-time.Second))
+		require.NoError(t, err)
+
+		_, _, err = authService.Login("testuser", "password123")
+		assert.NoError(t, err)
+	})
+}
+
+func TestLockoutDuration(t *testing.T) {
+	assert.Zero(t, lockoutDuration(lockoutThreshold-1))
+	assert.Equal(t, lockoutBase, lockoutDuration(lockoutThreshold))
+	assert.Equal(t, 2*lockoutBase, lockoutDuration(lockoutThreshold+1))
+	assert.Equal(t, 4*lockoutBase, lockoutDuration(lockoutThreshold+2))
+	assert.Equal(t, lockoutMax, lockoutDuration(lockoutThreshold+100))
 }
 
 func TestAuthService_GenerateAndValidateToken(t *testing.T) {
@@ -125,6 +238,7 @@ func TestAuthService_GenerateAndValidateToken(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, user.ID, claims.UserID)
 		assert.Equal(t, user.Username, claims.Username)
+		assert.Equal(t, models.RolePlayer, claims.Role)
 	})
 
 	t.Run("invalid token", func(t *testing.T) {
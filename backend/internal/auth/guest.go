@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"battleship-go/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// guestEmailDomain is used for the placeholder addresses of guest accounts.
+// The .invalid TLD guarantees they can never receive mail.
+const guestEmailDomain = "guests.invalid"
+
+// ErrNotGuest is returned when upgrading an account that is already a full account.
+var ErrNotGuest = errors.New("account is not a guest account")
+
+// CreateGuest creates an ephemeral account with a generated name. Guests have
+// no password and can only use the short-lived token returned here.
+func (a *AuthService) CreateGuest() (*models.User, string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return nil, "", err
+	}
+	username, err := a.UniqueUsername(fmt.Sprintf("guest%06d", n.Int64()))
+	if err != nil {
+		return nil, "", err
+	}
+
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	email := "guest-" + hex.EncodeToString(raw) + "@" + guestEmailDomain
+
+	var user models.User
+	err = a.db.QueryRow(`
+		INSERT INTO users (username, email, password_hash, is_guest)
+		VALUES ($1, $2, '', TRUE)
+		RETURNING id, username, email, role, is_guest, created_at, updated_at`,
+		username, email).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Role, &user.IsGuest, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := a.db.Exec("INSERT INTO scores (player_id) VALUES ($1)", user.ID); err != nil {
+		return nil, "", err
+	}
+
+	token, err := a.GenerateToken(&user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &user, token, nil
+}
+
+// UpgradeGuest turns a guest into a full account with the given credentials.
+// The user ID stays the same, so games, chat and scores are kept.
+func (a *AuthService) UpgradeGuest(userID int, username, email, password string) (*models.User, error) {
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err := a.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE (username = $1 OR email = $2) AND id != $3)`,
+		username, email, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errors.New("user already exists")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.db.Exec(`
+		UPDATE users SET username = $1, email = $2, password_hash = $3, is_guest = FALSE, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND is_guest = TRUE`, username, email, string(hashedPassword), userID)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		return nil, ErrNotGuest
+	}
+
+	user, err := a.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.SendEmailVerification(user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthService_Guest(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	authService := NewAuthService(db, "test-secret")
+
+	guest, token, err := authService.CreateGuest()
+	require.NoError(t, err)
+	assert.True(t, guest.IsGuest)
+	assert.True(t, strings.HasPrefix(guest.Username, "guest"))
+	assert.True(t, strings.HasSuffix(guest.Email, "@"+guestEmailDomain))
+
+	claims, err := authService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.True(t, claims.Guest)
+	assert.WithinDuration(t, time.Now().Add(GuestTokenTTL), claims.ExpiresAt.Time, time.Minute)
+
+	t.Run("guests cannot log in with a password", func(t *testing.T) {
+		_, _, err := authService.Login(guest.Username, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("upgrade rejects taken credentials", func(t *testing.T) {
+		_, err := authService.Register("taken", "taken@example.com", "password123")
+		require.NoError(t, err)
+
+		_, err = authService.UpgradeGuest(guest.ID, "taken", "new@example.com", "password123")
+		assert.Error(t, err)
+	})
+
+	t.Run("upgrade rejects reserved usernames", func(t *testing.T) {
+		_, err := authService.UpgradeGuest(guest.ID, "deleted-abcdef", "new@example.com", "password123")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "reserved")
+	})
+
+	t.Run("upgrade keeps the user and its stats", func(t *testing.T) {
+		_, err := db.Exec("UPDATE scores SET wins = 3 WHERE player_id = $1", guest.ID)
+		require.NoError(t, err)
+
+		upgraded, err := authService.UpgradeGuest(guest.ID, "captain", "captain@example.com", "password123")
+		require.NoError(t, err)
+		assert.Equal(t, guest.ID, upgraded.ID)
+		assert.False(t, upgraded.IsGuest)
+
+		var wins int
+		require.NoError(t, db.QueryRow("SELECT wins FROM scores WHERE player_id = $1", guest.ID).Scan(&wins))
+		assert.Equal(t, 3, wins)
+
+		user, token, err := authService.Login("captain", "password123")
+		require.NoError(t, err)
+		assert.Equal(t, guest.ID, user.ID)
+
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.False(t, claims.Guest)
+	})
+
+	t.Run("full accounts cannot be upgraded again", func(t *testing.T) {
+		_, err := authService.UpgradeGuest(guest.ID, "captain2", "captain2@example.com", "password123")
+		assert.ErrorIs(t, err, ErrNotGuest)
+	})
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+
+	"battleship-go/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyID is the kid used when a key set is built from a single secret.
+const DefaultKeyID = "primary"
+
+// Key is a JWT signing or verification key identified by its kid.
+// Verification-only keys have no signing key.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey creates an HS256 key that can both sign and verify.
+func NewHMACKey(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// NewRSAKey creates an RS256 signing key.
+func NewRSAKey(kid string, key *rsa.PrivateKey) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}
+}
+
+// NewEd25519Key creates an EdDSA signing key.
+func NewEd25519Key(kid string, key ed25519.PrivateKey) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, signKey: key, verifyKey: key.Public()}
+}
+
+// NewPublicKey creates a verification-only key from an RSA or Ed25519 public key.
+func NewPublicKey(kid string, key interface{}) (*Key, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// KeySet holds every key accepted for verification and the single active signing key.
+type KeySet struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// NewKeySet creates a key set that signs with the given active key.
+func NewKeySet(active *Key) *KeySet {
+	return &KeySet{
+		active: active,
+		keys:   map[string]*Key{active.ID: active},
+	}
+}
+
+// NewHMACKeySet creates a key set with a single HS256 key.
+func NewHMACKeySet(kid string, secret []byte) *KeySet {
+	return NewKeySet(NewHMACKey(kid, secret))
+}
+
+// Add registers an additional verification key.
+func (ks *KeySet) Add(key *Key) error {
+	if _, exists := ks.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key id %q", key.ID)
+	}
+	ks.keys[key.ID] = key
+	return nil
+}
+
+// ActiveKeyID returns the kid of the signing key.
+func (ks *KeySet) ActiveKeyID() string {
+	return ks.active.ID
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.signKey)
+}
+
+// keyFunc resolves the verification key from the token's kid header. Tokens
+// issued before kids were introduced carry none and are checked against the
+// active key. The token's algorithm must match the key's to prevent algorithm
+// confusion between HMAC secrets and public keys.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := ks.active
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = ks.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a JSON Web Key as published in a JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns the asymmetric verification keys so that other services
+// can verify our tokens. HMAC secrets are never published.
+func (ks *KeySet) PublicJWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0)}
+	for _, key := range ks.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
+
+// LoadKeySet builds the key set described by the configuration.
+func LoadKeySet(cfg *config.Config) (*KeySet, error) {
+	var active *Key
+	switch cfg.JWT.Algorithm {
+	case config.JWTAlgorithmHS256:
+		active = NewHMACKey(cfg.JWT.KeyID, []byte(cfg.JWT.Secret))
+	case config.JWTAlgorithmRS256:
+		pem, err := os.ReadFile(cfg.JWT.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+		}
+		active = NewRSAKey(cfg.JWT.KeyID, key)
+	case config.JWTAlgorithmEdDSA:
+		pem, err := os.ReadFile(cfg.JWT.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		key, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Ed25519 private key: %w", err)
+		}
+		active = NewEd25519Key(cfg.JWT.KeyID, key.(ed25519.PrivateKey))
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", cfg.JWT.Algorithm)
+	}
+
+	keys := NewKeySet(active)
+	for kid, secret := range cfg.JWT.VerifyKeys {
+		if err := keys.Add(NewHMACKey(kid, []byte(secret))); err != nil {
+			return nil, err
+		}
+	}
+	for kid, path := range cfg.JWT.PublicKeyFiles {
+		key, err := loadPublicKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		if err := keys.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func loadPublicKey(kid, path string) (*Key, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key %q: %w", kid, err)
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return NewPublicKey(kid, key)
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+		return NewPublicKey(kid, key)
+	}
+	return nil, fmt.Errorf("public key %q is neither RSA nor Ed25519", kid)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"battleship-go/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySet_Rotation(t *testing.T) {
+	user := &models.User{ID: 7, Username: "rotator"}
+
+	oldService := NewAuthServiceWithKeys(nil, NewHMACKeySet("2024-01", []byte("old-secret")))
+	oldToken, err := oldService.GenerateToken(user)
+	require.NoError(t, err)
+
+	keys := NewHMACKeySet("2024-06", []byte("new-secret"))
+	require.NoError(t, keys.Add(NewHMACKey("2024-01", []byte("old-secret"))))
+	service := NewAuthServiceWithKeys(nil, keys)
+
+	t.Run("new tokens use the active kid", func(t *testing.T) {
+		token, err := service.GenerateToken(user)
+		require.NoError(t, err)
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+		require.NoError(t, err)
+		assert.Equal(t, "2024-06", parsed.Header["kid"])
+	})
+
+	t.Run("tokens signed with a retired key still verify", func(t *testing.T) {
+		claims, err := service.ValidateToken(oldToken)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, claims.UserID)
+	})
+
+	t.Run("unknown kid is rejected", func(t *testing.T) {
+		other := NewAuthServiceWithKeys(nil, NewHMACKeySet("unknown", []byte("old-secret")))
+		token, err := other.GenerateToken(user)
+		require.NoError(t, err)
+
+		_, err = service.ValidateToken(token)
+		assert.Error(t, err)
+	})
+
+	t.Run("duplicate kid is rejected", func(t *testing.T) {
+		assert.Error(t, keys.Add(NewHMACKey("2024-06", []byte("another"))))
+	})
+}
+
+func TestKeySet_Asymmetric(t *testing.T) {
+	user := &models.User{ID: 3, Username: "signer"}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for name, key := range map[string]*Key{
+		"RS256": NewRSAKey("rsa-1", rsaKey),
+		"EdDSA": NewEd25519Key("ed-1", edKey),
+	} {
+		t.Run(name, func(t *testing.T) {
+			service := NewAuthServiceWithKeys(nil, NewKeySet(key))
+
+			token, err := service.GenerateToken(user)
+			require.NoError(t, err)
+
+			claims, err := service.ValidateToken(token)
+			assert.NoError(t, err)
+			assert.Equal(t, user.ID, claims.UserID)
+
+			jwks := service.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, key.ID, jwks.Keys[0].Kid)
+			assert.Equal(t, name, jwks.Keys[0].Alg)
+		})
+	}
+
+	t.Run("verification-only public key", func(t *testing.T) {
+		signer := NewAuthServiceWithKeys(nil, NewKeySet(NewRSAKey("external", rsaKey)))
+		token, err := signer.GenerateToken(user)
+		require.NoError(t, err)
+
+		keys := NewHMACKeySet(DefaultKeyID, []byte("secret"))
+		pub, err := NewPublicKey("external", &rsaKey.PublicKey)
+		require.NoError(t, err)
+		require.NoError(t, keys.Add(pub))
+
+		claims, err := NewAuthServiceWithKeys(nil, keys).ValidateToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, claims.UserID)
+	})
+
+	t.Run("HMAC keys are not published", func(t *testing.T) {
+		service := NewAuthService(nil, "secret")
+		assert.Empty(t, service.JWKS().Keys)
+	})
+}
+
+func TestKeySet_RejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := NewAuthServiceWithKeys(nil, NewKeySet(NewRSAKey("rsa-1", rsaKey)))
+
+	// A token signed with HS256 using the public modulus as secret must not
+	// be accepted for an RS256 kid.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 1})
+	token.Header["kid"] = "rsa-1"
+	signed, err := token.SignedString(rsaKey.PublicKey.N.Bytes())
+	require.NoError(t, err)
+
+	_, err = service.ValidateToken(signed)
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+)
+
+// Progressive lockout: after lockoutThreshold consecutive failed logins the
+// account is locked for lockoutBase, doubling with every further failure up
+// to lockoutMax.
+const (
+	lockoutThreshold = 5
+	lockoutBase      = time.Minute
+	lockoutMax       = time.Hour
+)
+
+// AccountLockedError is returned while an account is locked after repeated
+// failed logins.
+type AccountLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AccountLockedError) Error() string {
+	return "account temporarily locked due to repeated failed logins"
+}
+
+// checkLocked returns an *AccountLockedError if the lock is still in effect.
+func checkLocked(lockedUntil *time.Time) error {
+	if lockedUntil == nil {
+		return nil
+	}
+	if remaining := time.Until(*lockedUntil); remaining > 0 {
+		return &AccountLockedError{RetryAfter: remaining}
+	}
+	return nil
+}
+
+// lockoutDuration returns how long to lock an account after failures
+// consecutive failed logins, or zero if it should not be locked.
+func lockoutDuration(failures int) time.Duration {
+	if failures < lockoutThreshold {
+		return 0
+	}
+	duration := lockoutBase
+	for i := lockoutThreshold; i < failures && duration < lockoutMax; i++ {
+		duration *= 2
+	}
+	if duration > lockoutMax {
+		duration = lockoutMax
+	}
+	return duration
+}
+
+// recordFailedLogin counts a failed login attempt and locks the account once
+// the threshold is reached.
+func (a *AuthService) recordFailedLogin(userID int) error {
+	var failures int
+	err := a.db.QueryRow(`
+		UPDATE users SET failed_login_count = failed_login_count + 1
+		WHERE id = $1 RETURNING failed_login_count`, userID).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if duration := lockoutDuration(failures); duration > 0 {
+		_, err = a.db.Exec("UPDATE users SET locked_until = $1 WHERE id = $2", time.Now().Add(duration), userID)
+	}
+	return err
+}
+
+// resetFailedLogins clears the failure count after a successful login.
+func (a *AuthService) resetFailedLogins(userID int) error {
+	_, err := a.db.Exec("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1", userID)
+	return err
+}
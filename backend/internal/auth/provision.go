@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"battleship-go/internal/models"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 50
+)
+
+// UniqueUsername turns base into a valid username that is not taken yet,
+// appending a number if needed. The reserved prefix of deleted accounts is
+// stripped.
+func (a *AuthService) UniqueUsername(base string) (string, error) {
+	base = sanitizeUsername(base)
+	for strings.HasPrefix(strings.ToLower(base), DeletedUsernamePrefix) {
+		base = strings.TrimLeft(base[len(DeletedUsernamePrefix):], "_-")
+	}
+	if len(base) < minUsernameLength {
+		base = "player"
+	}
+
+	for i := 1; i <= 1000; i++ {
+		candidate := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			if len(candidate)+len(suffix) > maxUsernameLength {
+				candidate = candidate[:maxUsernameLength-len(suffix)]
+			}
+			candidate += suffix
+		}
+
+		var exists bool
+		if err := a.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", candidate).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+
+	return "", errors.New("could not find a free username")
+}
+
+// ProvisionUser creates an account without a usable password, for users who
+// sign in through an external identity provider.
+func (a *AuthService) ProvisionUser(username, email string, emailVerified bool) (*models.User, error) {
+	var verifiedAt *time.Time
+	if emailVerified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+
+	var user models.User
+	err := a.db.QueryRow(`
+		INSERT INTO users (username, email, password_hash, email_verified_at) 
+		VALUES ($1, $2, '', $3) 
+		RETURNING id, username, email, role, email_verified_at, created_at, updated_at`,
+		username, email, verifiedAt).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := a.db.Exec("INSERT INTO scores (player_id) VALUES ($1)", user.ID); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// DeletedUsernamePrefix starts the placeholder names of deleted accounts.
+const DeletedUsernamePrefix = "deleted-"
+
+// ValidateUsername checks that a chosen username has a valid length and
+// only contains letters, digits, underscores and dashes.
+func ValidateUsername(name string) error {
+	if len(name) < minUsernameLength || len(name) > maxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	}
+	if sanitizeUsername(name) != name {
+		return errors.New("username may only contain letters, digits, underscores and dashes")
+	}
+	if strings.HasPrefix(strings.ToLower(name), DeletedUsernamePrefix) {
+		return errors.New("username is reserved")
+	}
+	return nil
+}
+
+// sanitizeUsername keeps letters, digits, underscores and dashes.
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == ' ' || r == '.':
+			b.WriteRune('_')
+		}
+		if b.Len() >= maxUsernameLength {
+			break
+		}
+	}
+	return strings.Trim(b.String(), "_-")
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthService_UniqueUsername(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	authService := NewAuthService(db, "test-secret")
+
+	t.Run("sanitizes the base", func(t *testing.T) {
+		name, err := authService.UniqueUsername("Jane Doe!")
+		require.NoError(t, err)
+		assert.Equal(t, "Jane_Doe", name)
+	})
+
+	t.Run("appends a number when taken", func(t *testing.T) {
+		_, err := authService.Register("captain", "captain@example.com", "password123")
+		require.NoError(t, err)
+
+		name, err := authService.UniqueUsername("captain")
+		require.NoError(t, err)
+		assert.Equal(t, "captain2", name)
+	})
+
+	t.Run("avoids the reserved prefix", func(t *testing.T) {
+		name, err := authService.UniqueUsername("deleted-sailor")
+		require.NoError(t, err)
+		assert.Equal(t, "sailor", name)
+		assert.NoError(t, ValidateUsername(name))
+
+		name, err = authService.UniqueUsername("DELETED-deleted-x")
+		require.NoError(t, err)
+		assert.Equal(t, "player", name)
+	})
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"battleship-go/internal/mail"
+	"battleship-go/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes of single-use tokens stored in auth_tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+// ErrInvalidToken is returned for unknown, expired or already used tokens.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// RequestPasswordReset emails a reset link if an account with the email
+// exists. It does not reveal whether the email is registered.
+func (a *AuthService) RequestPasswordReset(email string) error {
+	var user models.User
+	err := a.db.QueryRow("SELECT id, username, email FROM users WHERE email = $1", email).Scan(
+		&user.ID, &user.Username, &user.Email)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := a.issueToken(user.ID, TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Battleship password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s\n\n"+
+			"If you did not request this, you can ignore this email.\n",
+			user.Username, passwordResetTTL, a.link("/reset-password", token)),
+	})
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset.
+// Tokens issued before the reset are invalidated.
+func (a *AuthService) ResetPassword(token, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeToken(tx, token, TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = $1, password_changed_at = $2, failed_login_count = 0, locked_until = NULL, 
+		       updated_at = CURRENT_TIMESTAMP 
+		WHERE id = $3`, string(hashedPassword), now, userID)
+	if err != nil {
+		return err
+	}
+
+	// Any other outstanding reset links for this user are now stale
+	_, err = tx.Exec(`
+		UPDATE auth_tokens SET used_at = $1 
+		WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL`, now, userID, TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SendEmailVerification emails a link that confirms the user's address.
+func (a *AuthService) SendEmailVerification(user *models.User) error {
+	token, err := a.issueToken(user.ID, TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your Battleship email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below.\n\n%s\n",
+			user.Username, a.link("/verify-email", token)),
+	})
+}
+
+// VerifyEmail marks the user's email as verified using a token from SendEmailVerification.
+func (a *AuthService) VerifyEmail(token string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeToken(tx, token, TokenPurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET email_verified_at = $1, updated_at = CURRENT_TIMESTAMP 
+		WHERE id = $2`, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// issueToken stores the SHA-256 hash of a new random token and returns the
+// plaintext, which only ever leaves the server in the email.
+func (a *AuthService) issueToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	_, err := a.db.Exec(`
+		INSERT INTO auth_tokens (user_id, purpose, token_hash, expires_at) 
+		VALUES ($1, $2, $3, $4)`, userID, purpose, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeToken marks a token as used and returns its user. The conditional
+// update guarantees a token can be used only once even under concurrent requests.
+func consumeToken(tx *sql.Tx, token, purpose string) (int, error) {
+	var id, userID int
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err := tx.QueryRow(`
+		SELECT id, user_id, expires_at, used_at FROM auth_tokens 
+		WHERE token_hash = $1 AND purpose = $2`, hashToken(token), purpose).Scan(
+		&id, &userID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if usedAt != nil || time.Now().After(expiresAt) {
+		return 0, ErrInvalidToken
+	}
+
+	result, err := tx.Exec("UPDATE auth_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL", time.Now(), id)
+	if err != nil {
+		return 0, err
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		return 0, ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *AuthService) link(path, token string) string {
+	return a.baseURL + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"battleship-go/internal/mail"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`token=([0-9a-f]{64})`)
+
+func (m *recordingMailer) lastToken(t *testing.T) string {
+	require.NotEmpty(t, m.sent)
+	match := tokenPattern.FindStringSubmatch(m.sent[len(m.sent)-1].Body)
+	require.Len(t, match, 2)
+	return match[1]
+}
+
+func TestAuthService_PasswordReset(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	mailer := &recordingMailer{}
+	authService := NewAuthService(db, "test-secret")
+	authService.SetMailer(mailer, "https://battleship.example/")
+
+	_, err := authService.Register("testuser", "test@example.com", "password123")
+	require.NoError(t, err)
+
+	t.Run("unknown email sends nothing", func(t *testing.T) {
+		sent := len(mailer.sent)
+		assert.NoError(t, authService.RequestPasswordReset("nobody@example.com"))
+		assert.Len(t, mailer.sent, sent)
+	})
+
+	t.Run("reset with emailed token", func(t *testing.T) {
+		require.NoError(t, authService.RequestPasswordReset("test@example.com"))
+		assert.Contains(t, mailer.sent[len(mailer.sent)-1].Body, "https://battleship.example/reset-password?token=")
+		token := mailer.lastToken(t)
+
+		// The plaintext token is never stored
+		var count int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM auth_tokens WHERE token_hash = $1", token).Scan(&count))
+		assert.Zero(t, count)
+
+		require.NoError(t, authService.ResetPassword(token, "newpassword"))
+
+		_, _, err := authService.Login("testuser", "newpassword")
+		assert.NoError(t, err)
+		_, _, err = authService.Login("testuser", "password123")
+		assert.Error(t, err)
+
+		t.Run("token is single use", func(t *testing.T) {
+			assert.ErrorIs(t, authService.ResetPassword(token, "another"), ErrInvalidToken)
+		})
+	})
+
+	t.Run("reset invalidates other outstanding links", func(t *testing.T) {
+		require.NoError(t, authService.RequestPasswordReset("test@example.com"))
+		first := mailer.lastToken(t)
+		require.NoError(t, authService.RequestPasswordReset("test@example.com"))
+		second := mailer.lastToken(t)
+
+		require.NoError(t, authService.ResetPassword(second, "password456"))
+		assert.ErrorIs(t, authService.ResetPassword(first, "password789"), ErrInvalidToken)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		require.NoError(t, authService.RequestPasswordReset("test@example.com"))
+		token := mailer.lastToken(t)
+		_, err := db.Exec("UPDATE auth_tokens SET expires_at = $1 WHERE token_hash = $2",
+			time.Now().Add(-time.Minute), hashToken(token))
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, authService.ResetPassword(token, "password789"), ErrInvalidToken)
+	})
+
+	t.Run("tokens issued before the reset are revoked", func(t *testing.T) {
+		user, err := authService.GetUserByID(1)
+		require.NoError(t, err)
+		require.NotNil(t, user.PasswordChangedAt)
+
+		assert.False(t, TokenRevoked(&Claims{}, user))
+
+		jwtToken, err := authService.GenerateToken(user)
+		require.NoError(t, err)
+		claims, err := authService.ValidateToken(jwtToken)
+		require.NoError(t, err)
+		assert.False(t, TokenRevoked(claims, user))
+
+		later := time.Now().Add(time.Hour)
+		user.PasswordChangedAt = &later
+		assert.True(t, TokenRevoked(claims, user))
+	})
+}
+
+func TestAuthService_EmailVerification(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	mailer := &recordingMailer{}
+	authService := NewAuthService(db, "test-secret")
+	authService.SetMailer(mailer, "https://battleship.example")
+
+	user, err := authService.Register("testuser", "test@example.com", "password123")
+	require.NoError(t, err)
+	assert.Nil(t, user.EmailVerifiedAt)
+
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "test@example.com", mailer.sent[0].To)
+	token := mailer.lastToken(t)
+
+	t.Run("password reset token cannot verify email", func(t *testing.T) {
+		require.NoError(t, authService.RequestPasswordReset("test@example.com"))
+		assert.ErrorIs(t, authService.VerifyEmail(mailer.lastToken(t)), ErrInvalidToken)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		require.NoError(t, authService.VerifyEmail(token))
+
+		verified, err := authService.GetUserByID(user.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, verified.EmailVerifiedAt)
+
+		assert.ErrorIs(t, authService.VerifyEmail(token), ErrInvalidToken)
+	})
+}
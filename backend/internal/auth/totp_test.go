@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B SHA1 vectors, truncated to six digits
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		assert.Equal(t, expected, totpCode(secret, unix/totpPeriod), "time %d", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	require.NoError(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+
+	t.Run("current code", func(t *testing.T) {
+		matched, ok := verifyTOTP(secret, totpCode(key, step), now, 0)
+		assert.True(t, ok)
+		assert.Equal(t, step, matched)
+	})
+
+	t.Run("clock skew of one period", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, totpCode(key, step-1), now, 0)
+		assert.True(t, ok)
+		_, ok = verifyTOTP(secret, totpCode(key, step-2), now, 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("replay is rejected", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, totpCode(key, step), now, step)
+		assert.False(t, ok)
+	})
+
+	t.Run("malformed code", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, "12345", now, 0)
+		assert.False(t, ok)
+	})
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := totpProvisioningURI("Battleship", "alice", "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, "otpauth://totp/Battleship:alice?algorithm=SHA1&digits=6&issuer=Battleship&period=30&secret=JBSWY3DPEHPK3PXP", uri)
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"battleship-go/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PurposeTwoFactorChallenge marks the short-lived token issued between the
+// password step and the second factor of a login.
+const PurposeTwoFactorChallenge = "2fa"
+
+const (
+	totpIssuer         = "Battleship"
+	challengeTTL       = 5 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// recoveryCodeAlphabet avoids characters that are easy to confuse (0/O, 1/I/L)
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+var (
+	// ErrInvalidTwoFactorCode is returned when a TOTP or recovery code does not match.
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has 2FA.
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnrolled is returned when activating without a pending enrollment.
+	ErrTwoFactorNotEnrolled = errors.New("two-factor enrollment has not been started")
+	// ErrTwoFactorNotEnabled is returned when an operation requires 2FA that is not set up.
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+)
+
+// TwoFactorRequiredError is returned by Login when the password was correct
+// but the account requires a second factor. The challenge token must be
+// exchanged with CompleteTwoFactorLogin.
+type TwoFactorRequiredError struct {
+	ChallengeToken string
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+func (a *AuthService) generateChallengeToken(user *models.User) (string, error) {
+	claims := &Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Purpose:  PurposeTwoFactorChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return a.keys.sign(claims)
+}
+
+func (a *AuthService) validateChallengeToken(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, a.keys.keyFunc)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.Purpose != PurposeTwoFactorChallenge {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// BeginTOTPEnrollment generates a new TOTP secret for the user and returns it
+// along with the otpauth:// provisioning URI. The secret is not enforced until
+// ActivateTOTP confirms that the user's authenticator produces valid codes.
+func (a *AuthService) BeginTOTPEnrollment(userID int) (string, string, error) {
+	var username string
+	var enabledAt *time.Time
+	err := a.db.QueryRow("SELECT username, totp_enabled_at FROM users WHERE id = $1", userID).Scan(&username, &enabledAt)
+	if err != nil {
+		return "", "", err
+	}
+	if enabledAt != nil {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = a.db.Exec(`
+		UPDATE users SET totp_secret = $1, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, secret, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, totpProvisioningURI(totpIssuer, username, secret), nil
+}
+
+// ActivateTOTP enables 2FA once the user proves their authenticator works and
+// returns a fresh set of one-time recovery codes. Only their hashes are stored.
+func (a *AuthService) ActivateTOTP(userID int, code string) ([]string, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabledAt *time.Time
+	var lastStep int64
+	err = tx.QueryRow("SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = $1", userID).Scan(
+		&secret, &enabledAt, &lastStep)
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := verifyTOTP(secret.String, code, time.Now(), lastStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled_at = $1, totp_last_step = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`, time.Now(), step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off. Both the password and a current TOTP or recovery
+// code are required so that a stolen session alone cannot remove the protection.
+// Wrong ones count as failed logins, so guessing locks the account.
+func (a *AuthService) DisableTOTP(userID int, password, code string) error {
+	var lockedUntil *time.Time
+	if err := a.db.QueryRow("SELECT locked_until FROM users WHERE id = $1", userID).Scan(&lockedUntil); err != nil {
+		return err
+	}
+	if err := checkLocked(lockedUntil); err != nil {
+		return err
+	}
+
+	if err := a.VerifyPassword(userID, password); err != nil {
+		if err == ErrInvalidCredentials {
+			if err := a.recordFailedLogin(userID); err != nil {
+				log.Printf("Failed to record failed login for user %d: %v", userID, err)
+			}
+		}
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := verifySecondFactor(tx, userID, code); err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			tx.Rollback()
+			if err := a.recordFailedLogin(userID); err != nil {
+				log.Printf("Failed to record failed login for user %d: %v", userID, err)
+			}
+		}
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := a.resetFailedLogins(userID); err != nil {
+		log.Printf("Failed to reset failed logins for user %d: %v", userID, err)
+	}
+	return nil
+}
+
+// CompleteTwoFactorLogin exchanges a challenge token from Login and a TOTP or
+// recovery code for a session token.
+func (a *AuthService) CompleteTwoFactorLogin(challenge, code string) (*models.User, string, error) {
+	claims, err := a.validateChallengeToken(challenge)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var lockedUntil *time.Time
+	if err := a.db.QueryRow("SELECT locked_until FROM users WHERE id = $1", claims.UserID).Scan(&lockedUntil); err != nil {
+		return nil, "", err
+	}
+	if err := checkLocked(lockedUntil); err != nil {
+		return nil, "", err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	if err := verifySecondFactor(tx, claims.UserID, code); err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			tx.Rollback()
+			if err := a.recordFailedLogin(claims.UserID); err != nil {
+				log.Printf("Failed to record failed login for user %d: %v", claims.UserID, err)
+			}
+		}
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	if err := a.resetFailedLogins(claims.UserID); err != nil {
+		log.Printf("Failed to reset failed logins for user %d: %v", claims.UserID, err)
+	}
+
+	user, err := a.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.BannedAt != nil {
+		return nil, "", ErrUserBanned
+	}
+
+	token, err := a.GenerateToken(user)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code and
+// records its use so that neither can be replayed.
+func verifySecondFactor(tx *sql.Tx, userID int, code string) error {
+	var secret sql.NullString
+	var enabledAt *time.Time
+	var lastStep int64
+	err := tx.QueryRow("SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = $1", userID).Scan(
+		&secret, &enabledAt, &lastStep)
+	if err != nil {
+		return err
+	}
+	if enabledAt == nil || !secret.Valid {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := verifyTOTP(secret.String, code, time.Now(), lastStep); ok {
+		// The conditional update rejects a concurrent login with the same code
+		result, err := tx.Exec(`
+			UPDATE users SET totp_last_step = $1
+			WHERE id = $2 AND totp_last_step < $1`, step, userID)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows != 1 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidTwoFactorCode
+	}
+	result, err := tx.Exec(`
+		UPDATE recovery_codes SET used_at = $1
+		WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`, time.Now(), userID, hashToken(normalized))
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes discards the user's existing recovery codes and
+// returns a new set in display form (xxxxx-xxxxx).
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, v := range raw {
+		if i == recoveryCodeLength/2 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+	}
+	return b.String(), nil
+}
+
+// normalizeRecoveryCode strips separators and case so that codes can be
+// typed the way they are displayed or without the dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func currentTOTP(t *testing.T, secret string) string {
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+func TestAuthService_TwoFactor(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	authService := NewAuthService(db, "test-secret")
+	user, err := authService.Register("alice", "alice@example.com", "password123")
+	require.NoError(t, err)
+
+	secret, uri, err := authService.BeginTOTPEnrollment(user.ID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Battleship:alice?"))
+
+	t.Run("enrollment is not enforced until activated", func(t *testing.T) {
+		_, token, err := authService.Login("alice", "password123")
+		require.NoError(t, err)
+		assert.NotEmpty(t, token)
+	})
+
+	_, err = authService.ActivateTOTP(user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	activationCode := currentTOTP(t, secret)
+	codes, err := authService.ActivateTOTP(user.ID, activationCode)
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	var stored string
+	require.NoError(t, db.QueryRow("SELECT code_hash FROM recovery_codes LIMIT 1").Scan(&stored))
+	assert.NotContains(t, codes, stored)
+
+	login := func(t *testing.T) string {
+		_, token, err := authService.Login("alice", "password123")
+		assert.Empty(t, token)
+
+		var twoFactor *TwoFactorRequiredError
+		require.True(t, errors.As(err, &twoFactor))
+		return twoFactor.ChallengeToken
+	}
+
+	t.Run("challenge token is not a session token", func(t *testing.T) {
+		_, err := authService.ValidateToken(login(t))
+		assert.Error(t, err)
+	})
+
+	t.Run("code used for activation cannot be replayed", func(t *testing.T) {
+		_, _, err := authService.CompleteTwoFactorLogin(login(t), activationCode)
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	})
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		challenge := login(t)
+		loggedIn, token, err := authService.CompleteTwoFactorLogin(challenge, strings.ToUpper(codes[0]))
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, loggedIn.ID)
+
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, claims.UserID)
+
+		_, _, err = authService.CompleteTwoFactorLogin(login(t), codes[0])
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	})
+
+	t.Run("session tokens are not accepted as challenges", func(t *testing.T) {
+		token, err := authService.GenerateToken(user)
+		require.NoError(t, err)
+
+		_, _, err = authService.CompleteTwoFactorLogin(token, codes[1])
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("profile reports 2FA", func(t *testing.T) {
+		profile, err := authService.GetUserByID(user.ID)
+		require.NoError(t, err)
+		assert.True(t, profile.TwoFactorEnabled)
+	})
+
+	t.Run("failed disables lock the account", func(t *testing.T) {
+		unlock := func() {
+			_, err := db.Exec("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1", user.ID)
+			require.NoError(t, err)
+		}
+		unlock()
+		for i := 0; i < lockoutThreshold-1; i++ {
+			assert.ErrorIs(t, authService.DisableTOTP(user.ID, "wrong", codes[1]), ErrInvalidCredentials)
+		}
+		assert.ErrorIs(t, authService.DisableTOTP(user.ID, "password123", "bogus"), ErrInvalidTwoFactorCode)
+
+		var locked *AccountLockedError
+		assert.ErrorAs(t, authService.DisableTOTP(user.ID, "password123", codes[1]), &locked)
+		_, _, err := authService.Login("alice", "password123")
+		assert.ErrorAs(t, err, &locked)
+		unlock()
+	})
+
+	t.Run("disable requires password and code", func(t *testing.T) {
+		err := authService.DisableTOTP(user.ID, "wrong", codes[1])
+		assert.Error(t, err)
+
+		err = authService.DisableTOTP(user.ID, "password123", "bogus")
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+		require.NoError(t, authService.DisableTOTP(user.ID, "password123", codes[1]))
+
+		_, token, err := authService.Login("alice", "password123")
+		require.NoError(t, err)
+		assert.NotEmpty(t, token)
+
+		var remaining int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM recovery_codes").Scan(&remaining))
+		assert.Zero(t, remaining)
+	})
+}
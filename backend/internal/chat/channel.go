@@ -0,0 +1,275 @@
+package chat
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"battleship-go/internal/models"
+)
+
+// Channel types
+const (
+	ChannelGame   = "game"
+	ChannelLobby  = "lobby"
+	ChannelDirect = "dm"
+)
+
+// lobbyKey is the channel key of the single global lobby.
+const lobbyKey = "lobby"
+
+var (
+	ErrChannelNotFound = errors.New("channel not found")
+	ErrGameNotFound    = errors.New("game not found")
+)
+
+// Channel is a conversation: a game's chat, the global lobby or a direct
+// conversation between two users.
+type Channel struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	GameID    *int      `json:"game_id,omitempty"`
+	User1ID   *int      `json:"-"`
+	User2ID   *int      `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Members returns the two participants of a direct channel, and nil for
+// other channel types.
+func (c *Channel) Members() []int {
+	if c.Type != ChannelDirect || c.User1ID == nil || c.User2ID == nil {
+		return nil
+	}
+	return []int{*c.User1ID, *c.User2ID}
+}
+
+// CanRead reports whether userID may see the channel's messages. Game chat
+// stays open as it always has been; direct channels are private.
+func (c *Channel) CanRead(userID int) bool {
+	if c.Type != ChannelDirect {
+		return true
+	}
+	for _, member := range c.Members() {
+		if member == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelSummary is a channel as listed for one user.
+type ChannelSummary struct {
+	Channel
+	// OtherUserID and OtherUsername identify the other side of a direct channel.
+	OtherUserID   int                 `json:"other_user_id,omitempty"`
+	OtherUsername string              `json:"other_username,omitempty"`
+	LastMessage   *models.ChatMessage `json:"last_message"`
+	Unread        int                 `json:"unread"`
+}
+
+const channelColumns = "id, type, game_id, user1_id, user2_id, created_at"
+
+func scanChannel(row interface{ Scan(...interface{}) error }) (*Channel, error) {
+	var ch Channel
+	err := row.Scan(&ch.ID, &ch.Type, &ch.GameID, &ch.User1ID, &ch.User2ID, &ch.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// Channel returns a channel by ID.
+func (s *ChatService) Channel(channelID int) (*Channel, error) {
+	return scanChannel(s.db.QueryRow("SELECT "+channelColumns+" FROM chat_channels WHERE id = $1", channelID))
+}
+
+// GameChannel returns the chat channel of a game, creating it on first use.
+// It returns ErrGameNotFound if there is no such game.
+func (s *ChatService) GameChannel(gameID int) (*Channel, error) {
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM games WHERE id = $1)", gameID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrGameNotFound
+	}
+	return s.ensureChannel(ChannelGame, fmt.Sprintf("game:%d", gameID), &gameID, nil, nil)
+}
+
+// FindGameChannel returns the chat channel of a game without creating it.
+func (s *ChatService) FindGameChannel(gameID int) (*Channel, error) {
+	return scanChannel(s.db.QueryRow("SELECT "+channelColumns+" FROM chat_channels WHERE channel_key = $1",
+		fmt.Sprintf("game:%d", gameID)))
+}
+
+// LobbyChannel returns the global lobby channel.
+func (s *ChatService) LobbyChannel() (*Channel, error) {
+	return s.ensureChannel(ChannelLobby, lobbyKey, nil, nil, nil)
+}
+
+// DirectChannel returns the direct channel between two users, creating it on
+// first use. The pair is stored in ascending order so either side finds it.
+func (s *ChatService) DirectChannel(userID, otherID int) (*Channel, error) {
+	if userID == otherID {
+		return nil, errors.New("cannot message yourself")
+	}
+	low, high := userID, otherID
+	if low > high {
+		low, high = high, low
+	}
+	return s.ensureChannel(ChannelDirect, fmt.Sprintf("dm:%d:%d", low, high), nil, &low, &high)
+}
+
+func (s *ChatService) ensureChannel(kind, key string, gameID, user1ID, user2ID *int) (*Channel, error) {
+	_, err := s.db.Exec(`
+		INSERT INTO chat_channels (type, channel_key, game_id, user1_id, user2_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (channel_key) DO NOTHING`, kind, key, gameID, user1ID, user2ID)
+	if err != nil {
+		return nil, err
+	}
+	return scanChannel(s.db.QueryRow("SELECT "+channelColumns+" FROM chat_channels WHERE channel_key = $1", key))
+}
+
+// MarkRead moves userID's read marker in a channel forward to messageID.
+// Markers never move backwards.
+func (s *ChatService) MarkRead(channelID, userID, messageID int) error {
+	_, err := s.db.Exec(`
+		INSERT INTO chat_read_markers (channel_id, user_id, last_read_message_id, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (channel_id, user_id) DO UPDATE
+		SET last_read_message_id = CASE
+		        WHEN excluded.last_read_message_id > chat_read_markers.last_read_message_id
+		        THEN excluded.last_read_message_id
+		        ELSE chat_read_markers.last_read_message_id END,
+		    updated_at = CURRENT_TIMESTAMP`, channelID, userID, messageID)
+	return err
+}
+
+// LastRead returns the ID of the last message userID marked read in the
+// channel, or zero.
+func (s *ChatService) LastRead(channelID, userID int) (int, error) {
+	var messageID int
+	err := s.db.QueryRow(`
+		SELECT last_read_message_id FROM chat_read_markers
+		WHERE channel_id = $1 AND user_id = $2`, channelID, userID).Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return messageID, err
+}
+
+// UnreadCount returns how many visible messages from others in the channel
+// are newer than userID's read marker.
+func (s *ChatService) UnreadCount(channelID, userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM chat_messages m
+		WHERE m.channel_id = $1 AND m.player_id != $2 AND m.deleted_at IS NULL
+		  AND m.id > COALESCE((SELECT last_read_message_id FROM chat_read_markers
+		                       WHERE channel_id = $1 AND user_id = $2), 0)
+		  AND NOT EXISTS (`+blockedSenderQuery+`)`, channelID, userID).Scan(&count)
+	return count, err
+}
+
+// UnreadGameCounts returns the number of unread chat messages in each of
+// userID's games that has any, keyed by game ID.
+func (s *ChatService) UnreadGameCounts(userID int) (map[int]int, error) {
+	rows, err := s.db.Query(`
+		SELECT c.game_id, COUNT(m.id)
+		FROM chat_channels c
+		JOIN games g ON g.id = c.game_id AND c.type = $1
+		JOIN chat_messages m ON m.channel_id = c.id
+		LEFT JOIN chat_read_markers r ON r.channel_id = c.id AND r.user_id = $2
+		WHERE (g.player1_id = $2 OR g.player2_id = $2)
+		  AND m.player_id != $2 AND m.deleted_at IS NULL
+		  AND m.id > COALESCE(r.last_read_message_id, 0)
+		  AND NOT EXISTS (`+blockedSenderQuery+`)
+		GROUP BY c.game_id`, ChannelGame, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var gameID, count int
+		if err := rows.Scan(&gameID, &count); err != nil {
+			return nil, err
+		}
+		counts[gameID] = count
+	}
+	return counts, rows.Err()
+}
+
+// Channels lists the lobby and userID's direct conversations, most recently
+// active first after the lobby, with unread counts and the last visible
+// message of each.
+func (s *ChatService) Channels(userID int) ([]ChannelSummary, error) {
+	// Make sure the lobby exists so it is always listed
+	if _, err := s.LobbyChannel(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT c.id, c.type, c.game_id, c.user1_id, c.user2_id, c.created_at,
+		       COALESCE(u.id, 0), COALESCE(u.username, ''), c.unread,
+		       lm.id, lm.channel_id, lm.game_id, lm.player_id, lm.message, lm.created_at
+		FROM (
+			SELECT CASE WHEN c.channel_key = $1 THEN 0 ELSE 1 END AS lobby_last,
+			       c.id, c.type, c.game_id, c.user1_id, c.user2_id, c.created_at,
+			       (SELECT MAX(m.id) FROM chat_messages m
+			        WHERE m.channel_id = c.id AND m.deleted_at IS NULL
+			          AND NOT EXISTS (`+blockedSenderQuery+`)) AS last_id,
+			       (SELECT COUNT(*) FROM chat_messages m
+			        WHERE m.channel_id = c.id AND m.player_id != $2 AND m.deleted_at IS NULL
+			          AND m.id > COALESCE(r.last_read_message_id, 0)
+			          AND NOT EXISTS (`+blockedSenderQuery+`)) AS unread,
+			       COALESCE((SELECT MAX(id) FROM chat_messages WHERE channel_id = c.id), 0) AS activity
+			FROM chat_channels c
+			LEFT JOIN chat_read_markers r ON r.channel_id = c.id AND r.user_id = $2
+			WHERE c.channel_key = $1 OR (c.type = $3 AND (c.user1_id = $2 OR c.user2_id = $2))
+		) c
+		LEFT JOIN users u ON c.type = $3
+		     AND u.id = CASE WHEN c.user1_id = $2 THEN c.user2_id ELSE c.user1_id END
+		LEFT JOIN chat_messages lm ON lm.id = c.last_id
+		ORDER BY c.lobby_last, c.activity DESC, c.id DESC`,
+		lobbyKey, userID, ChannelDirect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make([]ChannelSummary, 0)
+	for rows.Next() {
+		var summary ChannelSummary
+		var last struct {
+			id, channelID, playerID sql.NullInt64
+			gameID                  *int
+			message                 sql.NullString
+			createdAt               sql.NullTime
+		}
+		ch := &summary.Channel
+		if err := rows.Scan(&ch.ID, &ch.Type, &ch.GameID, &ch.User1ID, &ch.User2ID, &ch.CreatedAt,
+			&summary.OtherUserID, &summary.OtherUsername, &summary.Unread,
+			&last.id, &last.channelID, &last.gameID, &last.playerID, &last.message, &last.createdAt); err != nil {
+			return nil, err
+		}
+		if last.id.Valid {
+			summary.LastMessage = &models.ChatMessage{
+				ID:        int(last.id.Int64),
+				ChannelID: int(last.channelID.Int64),
+				GameID:    last.gameID,
+				PlayerID:  int(last.playerID.Int64),
+				Message:   last.message.String,
+				CreatedAt: last.createdAt.Time,
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
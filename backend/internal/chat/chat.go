@@ -0,0 +1,295 @@
+// Package chat stores messages in game, lobby and direct channels after
+// passing them through moderation, handles player reports and moderator
+// deletions, and carries in-game quick-chat phrases and reactions.
+package chat
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"battleship-go/internal/models"
+)
+
+// Report statuses
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrOwnMessage      = errors.New("cannot report your own message")
+	ErrAlreadyReported = errors.New("message already reported")
+	ErrReportNotFound  = errors.New("report not found")
+)
+
+// Report is a player's complaint about a chat message.
+type Report struct {
+	ID               int        `json:"id"`
+	MessageID        int        `json:"message_id"`
+	ChannelID        int        `json:"channel_id"`
+	GameID           *int       `json:"game_id,omitempty"`
+	Message          string     `json:"message"`
+	AuthorID         int        `json:"author_id"`
+	AuthorUsername   string     `json:"author_username"`
+	ReporterID       int        `json:"reporter_id"`
+	ReporterUsername string     `json:"reporter_username"`
+	Reason           string     `json:"reason"`
+	Status           string     `json:"status"`
+	ResolvedBy       *int       `json:"resolved_by,omitempty"`
+	ResolvedAt       *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type ChatService struct {
+	db       *sql.DB
+	pipeline *Pipeline
+	flood    *floodGuard
+	quick    *floodGuard
+	now      func() time.Time
+}
+
+func NewChatService(db *sql.DB, pipeline *Pipeline) *ChatService {
+	return &ChatService{
+		db:       db,
+		pipeline: pipeline,
+		flood:    newFloodGuard(floodPolicy, true),
+		quick:    newFloodGuard(quickChatPolicy, false),
+		now:      time.Now,
+	}
+}
+
+// blockedSenderQuery matches a block in either direction between the viewer
+// in $2 and the author of the message row m being filtered.
+const blockedSenderQuery = `
+	SELECT 1 FROM user_blocks
+	WHERE (blocker_id = $2 AND blocked_id = m.player_id) OR (blocker_id = m.player_id AND blocked_id = $2)`
+
+const messageColumns = "id, channel_id, game_id, player_id, message, created_at"
+
+func scanMessage(row interface{ Scan(...interface{}) error }, msg *models.ChatMessage) error {
+	return row.Scan(&msg.ID, &msg.ChannelID, &msg.GameID, &msg.PlayerID, &msg.Message, &msg.CreatedAt)
+}
+
+// Send moderates a message and stores it in the channel.
+func (s *ChatService) Send(channel *Channel, userID int, text string) (*models.ChatMessage, error) {
+	if channel.Type == ChannelGame && channel.GameID != nil {
+		allowed, err := s.freeChatAllowed(*channel.GameID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, ErrFreeChatDisabled
+		}
+	}
+
+	text, err := s.pipeline.Moderate(text)
+	if err != nil {
+		return nil, err
+	}
+	if !s.flood.allow(userID, text, s.now()) {
+		return nil, ErrFlooding
+	}
+
+	var msg models.ChatMessage
+	err = scanMessage(s.db.QueryRow(`
+		INSERT INTO chat_messages (channel_id, game_id, player_id, message)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+messageColumns, channel.ID, channel.GameID, userID, text), &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Page selects part of a channel's history by message ID. With After set,
+// pages move forward from that message for catch-up; otherwise they move back
+// from Before, or from the newest message if Before is zero.
+type Page struct {
+	Before int
+	After  int
+	Limit  int
+}
+
+// History returns a page of a channel's messages, oldest first, as viewerID
+// sees it: deleted messages and those from users blocked in either direction
+// are left out.
+func (s *ChatService) History(channelID, viewerID int, page Page) ([]models.ChatMessage, error) {
+	order := "DESC"
+	if page.After > 0 {
+		order = "ASC"
+	}
+	rows, err := s.db.Query(`
+		SELECT `+messageColumns+`
+		FROM chat_messages m WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+		  AND NOT EXISTS (`+blockedSenderQuery+`)
+		  AND ($3 = 0 OR m.id < $3) AND m.id > $4
+		ORDER BY m.id `+order+` LIMIT $5`, channelID, viewerID, page.Before, page.After, clampLimit(page.Limit))
+	if err != nil {
+		return nil, err
+	}
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+func collectMessages(rows *sql.Rows) ([]models.ChatMessage, error) {
+	defer rows.Close()
+
+	messages := make([]models.ChatMessage, 0)
+	for rows.Next() {
+		var msg models.ChatMessage
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// Delete hides a message on behalf of a moderator and resolves its open
+// reports. It returns the deleted message.
+func (s *ChatService) Delete(messageID, moderatorID int) (*models.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := s.now()
+	var msg models.ChatMessage
+	err = scanMessage(tx.QueryRow(`
+		UPDATE chat_messages SET deleted_at = $1, deleted_by = $2
+		WHERE id = $3 AND deleted_at IS NULL
+		RETURNING `+messageColumns, now, moderatorID, messageID), &msg)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE chat_reports SET status = $1, resolved_by = $2, resolved_at = $3
+		WHERE message_id = $4 AND status = $5`, ReportResolved, moderatorID, now, messageID, ReportOpen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, tx.Commit()
+}
+
+// Report files a complaint about a message for the moderator queue. Messages
+// in channels the reporter cannot read are treated as missing.
+func (s *ChatService) Report(messageID, reporterID int, reason string) error {
+	var authorID, channelID int
+	err := s.db.QueryRow(`
+		SELECT player_id, channel_id FROM chat_messages WHERE id = $1 AND deleted_at IS NULL`, messageID).Scan(
+		&authorID, &channelID)
+	if err == sql.ErrNoRows {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return err
+	}
+	if !channel.CanRead(reporterID) {
+		return ErrMessageNotFound
+	}
+	if authorID == reporterID {
+		return ErrOwnMessage
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO chat_reports (message_id, reporter_id, reason, status) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (message_id, reporter_id) DO NOTHING`, messageID, reporterID, reason, ReportOpen)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrAlreadyReported
+	}
+	return nil
+}
+
+// ListReports returns reports with the given status, oldest first so the
+// queue is worked in order.
+func (s *ChatService) ListReports(status string, limit, offset int) ([]Report, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.message_id, m.channel_id, m.game_id, m.message, m.player_id, author.username,
+		       r.reporter_id, reporter.username, r.reason, r.status, r.resolved_by, r.resolved_at, r.created_at
+		FROM chat_reports r
+		JOIN chat_messages m ON m.id = r.message_id
+		JOIN users author ON author.id = m.player_id
+		JOIN users reporter ON reporter.id = r.reporter_id
+		WHERE r.status = $1
+		ORDER BY r.created_at, r.id LIMIT $2 OFFSET $3`, status, clampLimit(limit), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]Report, 0)
+	for rows.Next() {
+		var r Report
+		if err := rows.Scan(&r.ID, &r.MessageID, &r.ChannelID, &r.GameID, &r.Message, &r.AuthorID, &r.AuthorUsername,
+			&r.ReporterID, &r.ReporterUsername, &r.Reason, &r.Status, &r.ResolvedBy, &r.ResolvedAt, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// DismissReport closes a report without acting on the message.
+func (s *ChatService) DismissReport(reportID, moderatorID int) error {
+	result, err := s.db.Exec(`
+		UPDATE chat_reports SET status = $1, resolved_by = $2, resolved_at = $3
+		WHERE id = $4 AND status = $5`, ReportDismissed, moderatorID, s.now(), reportID, ReportOpen)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrReportNotFound
+	}
+	return nil
+}
+
+// ReportedMessage returns the ID of the message an open report is about.
+func (s *ChatService) ReportedMessage(reportID int) (int, error) {
+	var messageID int
+	err := s.db.QueryRow(`
+		SELECT message_id FROM chat_reports WHERE id = $1 AND status = $2`, reportID, ReportOpen).Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return 0, ErrReportNotFound
+	}
+	return messageID, err
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return limit
+}
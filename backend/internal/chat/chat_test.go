@@ -0,0 +1,479 @@
+package chat
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL
+		);
+
+		CREATE TABLE games (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player1_id INTEGER NOT NULL,
+			player2_id INTEGER,
+			chat_mode TEXT NOT NULL DEFAULT 'free'
+		);
+
+		CREATE TABLE quick_chat_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL,
+			player_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			code TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE chat_channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			channel_key TEXT UNIQUE NOT NULL,
+			game_id INTEGER,
+			user1_id INTEGER,
+			user2_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE chat_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL,
+			game_id INTEGER,
+			player_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			deleted_at DATETIME,
+			deleted_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE chat_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL,
+			reporter_id INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'open',
+			resolved_by INTEGER,
+			resolved_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (message_id, reporter_id)
+		);
+
+		CREATE TABLE chat_read_markers (
+			channel_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			last_read_message_id INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel_id, user_id)
+		);
+
+		CREATE TABLE user_blocks (
+			blocker_id INTEGER NOT NULL,
+			blocked_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_id, blocked_id)
+		);
+
+		INSERT INTO users (id, username) VALUES (1, 'alice'), (2, 'bob'), (3, 'mod');
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestPipeline_Moderate(t *testing.T) {
+	mask := NewPipeline(PipelineOptions{MaxLength: 20, Words: []string{"darn", " "}, StripLinks: true})
+
+	_, err := mask.Moderate("   ")
+	assert.ErrorIs(t, err, ErrEmptyMessage)
+
+	_, err = mask.Moderate(strings.Repeat("a", 21))
+	assert.ErrorIs(t, err, ErrMessageTooLong)
+
+	// Length counts characters, not bytes
+	_, err = mask.Moderate(strings.Repeat("é", 20))
+	assert.NoError(t, err)
+
+	text, err := mask.Moderate("well DARN it")
+	require.NoError(t, err)
+	assert.Equal(t, "well **** it", text)
+
+	// Only whole words are matched
+	text, err = mask.Moderate("darning")
+	require.NoError(t, err)
+	assert.Equal(t, "darning", text)
+
+	text, err = mask.Moderate("see www.x.io ok")
+	require.NoError(t, err)
+	assert.Equal(t, "see "+linkPlaceholder+" ok", text)
+
+	reject := NewPipeline(PipelineOptions{Words: []string{"darn"}})
+	_, err = reject.Moderate("darn")
+	assert.NoError(t, err, "masking is the default")
+
+	reject = NewPipeline(PipelineOptions{Words: []string{"darn"}, RejectWords: true})
+	_, err = reject.Moderate("oh darn")
+	assert.ErrorIs(t, err, ErrMessageRejected)
+
+	text, err = reject.Moderate("https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", text, "links are kept unless stripping is enabled")
+}
+
+func TestLoadWordlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\nfoo\n\n  bar  \n"), 0o600))
+
+	words, err := LoadWordlist(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, words)
+}
+
+func TestFloodGuard(t *testing.T) {
+	guard := newFloodGuard(floodPolicy, true)
+	now := time.Now()
+
+	assert.True(t, guard.allow(1, "hello", now))
+	assert.False(t, guard.allow(1, "  HELLO ", now.Add(time.Second)), "repeats are rejected")
+	assert.True(t, guard.allow(2, "hello", now), "other users are unaffected")
+	assert.True(t, guard.allow(1, "hello", now.Add(floodWindow+time.Second)))
+
+	// Bursts beyond the policy are rejected
+	later := now.Add(time.Hour)
+	for i := 0; i < floodPolicy.Burst; i++ {
+		assert.True(t, guard.allow(3, strings.Repeat("x", i+1), later))
+	}
+	assert.False(t, guard.allow(3, "one more", later))
+}
+
+func TestChatService_SendAndHistory(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO games (id, player1_id, player2_id) VALUES (1, 1, 2)")
+	require.NoError(t, err)
+	service := NewChatService(db, NewPipeline(PipelineOptions{Words: []string{"darn"}}))
+	game, err := service.GameChannel(1)
+	require.NoError(t, err)
+
+	msg, err := service.Send(game, 1, "  darn  ")
+	require.NoError(t, err)
+	assert.Equal(t, "****", msg.Message)
+
+	_, err = service.Send(game, 2, "hi")
+	require.NoError(t, err)
+
+	messages, err := service.History(game.ID, 1, Page{})
+	require.NoError(t, err)
+	assert.Len(t, messages, 2)
+
+	_, err = db.Exec("INSERT INTO user_blocks (blocker_id, blocked_id) VALUES (2, 1)")
+	require.NoError(t, err)
+
+	// Blocks hide messages in both directions
+	messages, err = service.History(game.ID, 1, Page{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, 1, messages[0].PlayerID)
+
+	messages, err = service.History(game.ID, 2, Page{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, 2, messages[0].PlayerID)
+}
+
+func TestChatService_Reports(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO games (id, player1_id, player2_id) VALUES (1, 1, 2)")
+	require.NoError(t, err)
+	service := NewChatService(db, NewPipeline(PipelineOptions{}))
+	game, err := service.GameChannel(1)
+	require.NoError(t, err)
+
+	msg, err := service.Send(game, 1, "rude")
+	require.NoError(t, err)
+	other, err := service.Send(game, 1, "also rude")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, service.Report(msg.ID, 1, ""), ErrOwnMessage)
+	assert.ErrorIs(t, service.Report(999, 2, ""), ErrMessageNotFound)
+	require.NoError(t, service.Report(msg.ID, 2, "insult"))
+	assert.ErrorIs(t, service.Report(msg.ID, 2, "again"), ErrAlreadyReported)
+	require.NoError(t, service.Report(other.ID, 2, ""))
+
+	// Other people's direct messages cannot be reported, or probed for
+	direct, err := service.DirectChannel(1, 2)
+	require.NoError(t, err)
+	private, err := service.Send(direct, 1, "between us")
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.Report(private.ID, 3, ""), ErrMessageNotFound)
+
+	reports, err := service.ListReports(ReportOpen, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, "rude", reports[0].Message)
+	assert.Equal(t, "alice", reports[0].AuthorUsername)
+	assert.Equal(t, "bob", reports[0].ReporterUsername)
+
+	t.Run("dismiss", func(t *testing.T) {
+		require.NoError(t, service.DismissReport(reports[1].ID, 3))
+		assert.ErrorIs(t, service.DismissReport(reports[1].ID, 3), ErrReportNotFound)
+	})
+
+	t.Run("delete resolves open reports", func(t *testing.T) {
+		messageID, err := service.ReportedMessage(reports[0].ID)
+		require.NoError(t, err)
+
+		deleted, err := service.Delete(messageID, 3)
+		require.NoError(t, err)
+		require.NotNil(t, deleted.GameID)
+		assert.Equal(t, 1, *deleted.GameID)
+		assert.Equal(t, game.ID, deleted.ChannelID)
+
+		_, err = service.Delete(messageID, 3)
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+
+		open, err := service.ListReports(ReportOpen, 0, 0)
+		require.NoError(t, err)
+		assert.Empty(t, open)
+
+		resolved, err := service.ListReports(ReportResolved, 0, 0)
+		require.NoError(t, err)
+		require.Len(t, resolved, 1)
+		require.NotNil(t, resolved[0].ResolvedBy)
+		assert.Equal(t, 3, *resolved[0].ResolvedBy)
+
+		messages, err := service.History(game.ID, 2, Page{})
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "also rude", messages[0].Message)
+	})
+}
+
+func TestChatService_Channels(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewChatService(db, NewPipeline(PipelineOptions{}))
+
+	_, err := service.GameChannel(7)
+	assert.ErrorIs(t, err, ErrGameNotFound)
+	_, err = db.Exec("INSERT INTO games (id, player1_id, player2_id) VALUES (7, 1, 2)")
+	require.NoError(t, err)
+
+	game, err := service.GameChannel(7)
+	require.NoError(t, err)
+	again, err := service.GameChannel(7)
+	require.NoError(t, err)
+	assert.Equal(t, game.ID, again.ID)
+	assert.Equal(t, ChannelGame, game.Type)
+
+	dm, err := service.DirectChannel(2, 1)
+	require.NoError(t, err)
+	reverse, err := service.DirectChannel(1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, dm.ID, reverse.ID)
+	assert.Equal(t, []int{1, 2}, dm.Members())
+	assert.True(t, dm.CanRead(2))
+	assert.False(t, dm.CanRead(3))
+	assert.True(t, game.CanRead(3))
+
+	_, err = service.DirectChannel(1, 1)
+	assert.Error(t, err)
+
+	_, err = service.Channel(999)
+	assert.ErrorIs(t, err, ErrChannelNotFound)
+
+	lobby, err := service.LobbyChannel()
+	require.NoError(t, err)
+	_, err = service.Send(lobby, 3, "hello lobby")
+	require.NoError(t, err)
+	_, err = service.Send(dm, 2, "psst")
+	require.NoError(t, err)
+
+	channels, err := service.Channels(1)
+	require.NoError(t, err)
+	require.Len(t, channels, 2)
+	assert.Equal(t, ChannelLobby, channels[0].Type)
+	assert.Equal(t, 1, channels[0].Unread)
+	assert.Equal(t, dm.ID, channels[1].ID)
+	assert.Equal(t, "bob", channels[1].OtherUsername)
+	require.NotNil(t, channels[1].LastMessage)
+	assert.Equal(t, "psst", channels[1].LastMessage.Message)
+	assert.Nil(t, channels[1].LastMessage.GameID)
+	require.NotNil(t, channels[0].LastMessage)
+	assert.Equal(t, "hello lobby", channels[0].LastMessage.Message)
+
+	channels, err = service.Channels(3)
+	require.NoError(t, err)
+	assert.Len(t, channels, 1, "other users' direct channels are not listed")
+
+	_, err = db.Exec("INSERT INTO user_blocks (blocker_id, blocked_id) VALUES (1, 3)")
+	require.NoError(t, err)
+	channels, err = service.Channels(1)
+	require.NoError(t, err)
+	assert.Zero(t, channels[0].Unread, "blocked senders are not counted")
+	assert.Nil(t, channels[0].LastMessage, "blocked senders are not shown")
+}
+
+func TestChatService_HistoryAndUnread(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewChatService(db, NewPipeline(PipelineOptions{}))
+
+	lobby, err := service.LobbyChannel()
+	require.NoError(t, err)
+
+	var ids []int
+	for i := 0; i < 5; i++ {
+		// Step the clock so the flood guard lets every message through
+		service.now = func() time.Time { return time.Now().Add(time.Duration(i) * time.Hour) }
+		msg, err := service.Send(lobby, 2, strings.Repeat("m", i+1))
+		require.NoError(t, err)
+		ids = append(ids, msg.ID)
+	}
+
+	page, err := service.History(lobby.ID, 1, Page{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, ids[3], page[0].ID, "pages are oldest first")
+	assert.Equal(t, ids[4], page[1].ID)
+
+	page, err = service.History(lobby.ID, 1, Page{Before: page[0].ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page, 3)
+	assert.Equal(t, ids[0], page[0].ID)
+
+	// Catching up moves forward from a known message
+	page, err = service.History(lobby.ID, 1, Page{After: ids[1], Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, ids[2], page[0].ID)
+	assert.Equal(t, ids[3], page[1].ID)
+
+	unread, err := service.UnreadCount(lobby.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 5, unread)
+
+	lastRead, err := service.LastRead(lobby.ID, 1)
+	require.NoError(t, err)
+	assert.Zero(t, lastRead)
+
+	unread, err = service.UnreadCount(lobby.ID, 2)
+	require.NoError(t, err)
+	assert.Zero(t, unread, "own messages are never unread")
+
+	require.NoError(t, service.MarkRead(lobby.ID, 1, ids[2]))
+	unread, err = service.UnreadCount(lobby.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread)
+
+	// Markers never move backwards
+	require.NoError(t, service.MarkRead(lobby.ID, 1, ids[0]))
+	unread, err = service.UnreadCount(lobby.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread)
+
+	lastRead, err = service.LastRead(lobby.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, ids[2], lastRead)
+}
+
+func TestChatService_UnreadGameCounts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewChatService(db, NewPipeline(PipelineOptions{}))
+
+	_, err := db.Exec("INSERT INTO games (id, player1_id, player2_id) VALUES (1, 1, 2), (2, 2, 3)")
+	require.NoError(t, err)
+
+	game, err := service.GameChannel(1)
+	require.NoError(t, err)
+	other, err := service.GameChannel(2)
+	require.NoError(t, err)
+
+	first, err := service.Send(game, 2, "one")
+	require.NoError(t, err)
+	_, err = service.Send(game, 2, "two")
+	require.NoError(t, err)
+	_, err = service.Send(game, 1, "mine")
+	require.NoError(t, err)
+	_, err = service.Send(other, 3, "not my game")
+	require.NoError(t, err)
+
+	counts, err := service.UnreadGameCounts(1)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2}, counts)
+
+	require.NoError(t, service.MarkRead(game.ID, 1, first.ID))
+	counts, err = service.UnreadGameCounts(1)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 1}, counts)
+
+	_, err = service.FindGameChannel(3)
+	assert.ErrorIs(t, err, ErrChannelNotFound)
+	found, err := service.FindGameChannel(1)
+	require.NoError(t, err)
+	assert.Equal(t, game.ID, found.ID)
+}
+
+func TestChatService_QuickChat(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewChatService(db, NewPipeline(PipelineOptions{}))
+
+	_, err := db.Exec("INSERT INTO games (id, player1_id, player2_id, chat_mode) VALUES (1, 1, 2, 'quick')")
+	require.NoError(t, err)
+
+	game, err := service.GameChannel(1)
+	require.NoError(t, err)
+	_, err = service.Send(game, 1, "hello")
+	assert.ErrorIs(t, err, ErrFreeChatDisabled)
+
+	event, err := service.SendQuick(1, 1, QuickChatPhrase, "good_luck")
+	require.NoError(t, err)
+	assert.Equal(t, "Good luck!", event.Text)
+
+	_, err = service.SendQuick(1, 1, QuickChatPhrase, "free text")
+	assert.ErrorIs(t, err, ErrUnknownQuickChat)
+	_, err = service.SendQuick(1, 1, QuickChatPhrase, "fire")
+	assert.ErrorIs(t, err, ErrUnknownQuickChat, "reactions are not phrases")
+	_, err = service.SendQuick(1, 3, QuickChatReaction, "fire")
+	assert.ErrorIs(t, err, ErrNotPlayer)
+
+	// Repeats are fine, but bursts are limited on their own allowance
+	for i := 1; i < quickChatPolicy.Burst; i++ {
+		_, err = service.SendQuick(1, 1, QuickChatReaction, "fire")
+		require.NoError(t, err)
+	}
+	_, err = service.SendQuick(1, 1, QuickChatReaction, "fire")
+	assert.ErrorIs(t, err, ErrFlooding)
+
+	_, err = service.SendQuick(1, 2, QuickChatReaction, "clap")
+	require.NoError(t, err)
+
+	events, err := service.RecentQuick(1, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, events, quickChatPolicy.Burst+1)
+	assert.Equal(t, "good_luck", events[0].Code)
+	assert.Equal(t, "👏", events[len(events)-1].Text)
+
+	_, err = db.Exec("INSERT INTO user_blocks (blocker_id, blocked_id) VALUES (1, 2)")
+	require.NoError(t, err)
+	events, err = service.RecentQuick(1, 1, 0)
+	require.NoError(t, err)
+	assert.Len(t, events, quickChatPolicy.Burst)
+}
@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"battleship-go/internal/ratelimit"
+)
+
+// DefaultMaxLength is the message length used when none is configured.
+const DefaultMaxLength = 500
+
+// linkPlaceholder replaces links when link stripping is enabled.
+const linkPlaceholder = "[link removed]"
+
+var (
+	ErrEmptyMessage    = errors.New("message is empty")
+	ErrMessageTooLong  = errors.New("message is too long")
+	ErrMessageRejected = errors.New("message contains blocked words")
+	ErrFlooding        = errors.New("you are sending messages too quickly")
+)
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// PipelineOptions configure message moderation.
+type PipelineOptions struct {
+	// MaxLength is the maximum message length in characters.
+	MaxLength int
+	// Words are matched case-insensitively as whole words.
+	Words []string
+	// RejectWords rejects messages containing a listed word instead of masking it.
+	RejectWords bool
+	// StripLinks replaces URLs with a placeholder.
+	StripLinks bool
+}
+
+// Pipeline checks and cleans chat messages before they are stored.
+type Pipeline struct {
+	maxLength   int
+	words       *regexp.Regexp
+	rejectWords bool
+	stripLinks  bool
+}
+
+func NewPipeline(opts PipelineOptions) *Pipeline {
+	p := &Pipeline{
+		maxLength:   opts.MaxLength,
+		rejectWords: opts.RejectWords,
+		stripLinks:  opts.StripLinks,
+	}
+	if p.maxLength <= 0 {
+		p.maxLength = DefaultMaxLength
+	}
+
+	var quoted []string
+	for _, word := range opts.Words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) > 0 {
+		p.words = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return p
+}
+
+// Moderate returns the message as it should be stored, or an error if it
+// must be refused.
+func (p *Pipeline) Moderate(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(text) > p.maxLength {
+		return "", ErrMessageTooLong
+	}
+
+	if p.stripLinks {
+		text = linkPattern.ReplaceAllString(text, linkPlaceholder)
+	}
+
+	if p.words != nil {
+		if p.rejectWords {
+			if p.words.MatchString(text) {
+				return "", ErrMessageRejected
+			}
+		} else {
+			text = p.words.ReplaceAllStringFunc(text, func(word string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(word))
+			})
+		}
+	}
+
+	return text, nil
+}
+
+// LoadWordlist reads one word per line, ignoring blank lines and lines
+// starting with #.
+func LoadWordlist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// floodWindow is how long a message is remembered for duplicate detection.
+const floodWindow = 30 * time.Second
+
+// floodPolicy limits how fast one user may send chat messages, whichever way
+// they arrive.
+var floodPolicy = ratelimit.Policy{Name: "chat_flood", Burst: 5, Interval: 2 * time.Second}
+
+// floodGuard rejects users who send faster than its policy allows and, when
+// dedupe is set, those who repeat the same message within floodWindow.
+type floodGuard struct {
+	mu     sync.Mutex
+	policy ratelimit.Policy
+	dedupe bool
+	users  map[int]*floodState
+}
+
+type floodState struct {
+	bucket   *ratelimit.Bucket
+	lastText string
+	lastSent time.Time
+}
+
+func newFloodGuard(policy ratelimit.Policy, dedupe bool) *floodGuard {
+	return &floodGuard{policy: policy, dedupe: dedupe, users: make(map[int]*floodState)}
+}
+
+// allow records the message and reports whether it may be sent.
+func (f *floodGuard) allow(userID int, text string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state, ok := f.users[userID]
+	if !ok {
+		state = &floodState{bucket: ratelimit.NewBucket(f.policy)}
+		f.users[userID] = state
+	}
+
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	if f.dedupe && normalized == state.lastText && now.Sub(state.lastSent) < floodWindow {
+		return false
+	}
+	if !state.bucket.Take(now).Allowed {
+		return false
+	}
+	state.lastText = normalized
+	state.lastSent = now
+
+	// Forget idle users now and then so the map does not grow forever
+	if len(f.users) > 10000 {
+		for id, s := range f.users {
+			if now.Sub(s.lastSent) >= floodWindow {
+				delete(f.users, id)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"battleship-go/internal/models"
+	"battleship-go/internal/ratelimit"
+)
+
+// Quick-chat kinds
+const (
+	QuickChatPhrase   = "phrase"
+	QuickChatReaction = "reaction"
+)
+
+var (
+	ErrUnknownQuickChat = errors.New("unknown quick-chat code")
+	ErrNotPlayer        = errors.New("only players in the game can do that")
+	ErrFreeChatDisabled = errors.New("free chat is disabled in this game")
+)
+
+// QuickPhrases is the catalog of quick-chat phrases by code.
+var QuickPhrases = map[string]string{
+	"good_luck":   "Good luck!",
+	"nice_shot":   "Nice shot!",
+	"close_one":   "That was close!",
+	"oops":        "Oops!",
+	"thinking":    "Let me think...",
+	"your_turn":   "Your turn!",
+	"well_played": "Well played!",
+	"gg":          "Good game!",
+	"rematch":     "Rematch?",
+	"thanks":      "Thanks!",
+}
+
+// Reactions is the catalog of emoji reactions by code.
+var Reactions = map[string]string{
+	"thumbs_up": "👍",
+	"clap":      "👏",
+	"laugh":     "😂",
+	"wow":       "😮",
+	"sad":       "😢",
+	"fire":      "🔥",
+	"boom":      "💥",
+	"anchor":    "⚓",
+}
+
+// quickChatPolicy limits quick-chat separately from free-text chat, so
+// reactions do not eat into a player's chat allowance or the other way round.
+var quickChatPolicy = ratelimit.Policy{Name: "quick_chat", Burst: 3, Interval: 3 * time.Second}
+
+// QuickChat is a catalog phrase or reaction sent during a game.
+type QuickChat struct {
+	ID        int       `json:"id"`
+	GameID    int       `json:"game_id"`
+	PlayerID  int       `json:"player_id"`
+	Kind      string    `json:"kind"`
+	Code      string    `json:"code"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// quickChatText looks up a catalog entry.
+func quickChatText(kind, code string) (string, bool) {
+	var text string
+	var ok bool
+	switch kind {
+	case QuickChatPhrase:
+		text, ok = QuickPhrases[code]
+	case QuickChatReaction:
+		text, ok = Reactions[code]
+	}
+	return text, ok
+}
+
+// SendQuick records a quick-chat phrase or reaction from a player in the
+// game. Quick-chat is allowed whatever the game's chat mode.
+func (s *ChatService) SendQuick(gameID, userID int, kind, code string) (*QuickChat, error) {
+	text, ok := quickChatText(kind, code)
+	if !ok {
+		return nil, ErrUnknownQuickChat
+	}
+
+	var playing bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM games WHERE id = $1 AND (player1_id = $2 OR player2_id = $2))`,
+		gameID, userID).Scan(&playing)
+	if err != nil {
+		return nil, err
+	}
+	if !playing {
+		return nil, ErrNotPlayer
+	}
+
+	if !s.quick.allow(userID, code, s.now()) {
+		return nil, ErrFlooding
+	}
+
+	event := QuickChat{Text: text}
+	err = s.db.QueryRow(`
+		INSERT INTO quick_chat_events (game_id, player_id, kind, code)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, game_id, player_id, kind, code, created_at`, gameID, userID, kind, code).Scan(
+		&event.ID, &event.GameID, &event.PlayerID, &event.Kind, &event.Code, &event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// RecentQuick returns up to limit of a game's latest quick-chat events, oldest
+// first, leaving out those from users blocked in either direction.
+func (s *ChatService) RecentQuick(gameID, viewerID, limit int) ([]QuickChat, error) {
+	rows, err := s.db.Query(`
+		SELECT id, game_id, player_id, kind, code, created_at
+		FROM quick_chat_events m WHERE m.game_id = $1
+		  AND NOT EXISTS (`+blockedSenderQuery+`)
+		ORDER BY m.id DESC LIMIT $3`, gameID, viewerID, clampLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]QuickChat, 0)
+	for rows.Next() {
+		var event QuickChat
+		if err := rows.Scan(&event.ID, &event.GameID, &event.PlayerID, &event.Kind, &event.Code, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Text, _ = quickChatText(event.Kind, event.Code)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// freeChatAllowed reports whether free-text chat is allowed in the game.
+func (s *ChatService) freeChatAllowed(gameID int) (bool, error) {
+	var mode string
+	err := s.db.QueryRow("SELECT chat_mode FROM games WHERE id = $1", gameID).Scan(&mode)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return mode != models.ChatModeQuick, err
+}
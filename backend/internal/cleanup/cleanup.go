@@ -1,145 +1,605 @@
 package cleanup
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"os"
 	"time"
 
+	"battleship-go/internal/auth"
+	"battleship-go/internal/game"
 	"battleship-go/internal/models"
 )
 
+// abandonedGuestsQuery selects guest accounts whose token has expired and that
+// never took part in a game or chat. A guest cannot log in again once its
+// token is gone.
+const abandonedGuestsQuery = `
+	SELECT u.id FROM users u
+	WHERE u.is_guest = TRUE AND u.created_at < $1
+	  AND NOT EXISTS (SELECT 1 FROM games g WHERE g.player1_id = u.id OR g.player2_id = u.id)
+	  AND NOT EXISTS (SELECT 1 FROM chat_messages m WHERE m.player_id = u.id)`
+
+// expiredGuestsQuery selects the other expired guests, those that played or
+// chatted. Their rows are anonymized rather than deleted so others' history
+// stays intact.
+const expiredGuestsQuery = `
+	SELECT u.id FROM users u
+	WHERE u.is_guest = TRUE AND u.deleted_at IS NULL AND u.created_at < $1
+	  AND (EXISTS (SELECT 1 FROM games g WHERE g.player1_id = u.id OR g.player2_id = u.id)
+	    OR EXISTS (SELECT 1 FROM chat_messages m WHERE m.player_id = u.id))`
+
+// Options are the cleanup thresholds.
+type Options struct {
+	// ActiveTimeout is how long an active game may go without a move before
+	// it is forfeited by the player whose turn it is.
+	ActiveTimeout time.Duration
+	// WaitingTimeout is how long a game may wait for an opponent before it
+	// is cancelled.
+	WaitingTimeout time.Duration
+	// ArchiveAfter is how long after finishing a game is archived.
+	ArchiveAfter time.Duration
+	// WarnBefore are how long before an inactive game is forfeited its
+	// players are warned, e.g. 10 and 2 minutes.
+	WarnBefore []time.Duration
+}
+
+// DefaultOptions are used unless configured otherwise.
+var DefaultOptions = Options{
+	ActiveTimeout:  time.Hour,
+	WaitingTimeout: time.Hour,
+	ArchiveAfter:   7 * 24 * time.Hour,
+	WarnBefore:     []time.Duration{10 * time.Minute, 2 * time.Minute},
+}
+
+// lastActivity is a game's last move or last update, whichever is later.
+// Players that are still there but thinking update the game to say so.
+const lastActivity = `CASE WHEN MAX(m.created_at) > g.updated_at THEN MAX(m.created_at) ELSE g.updated_at END`
+
+// inactiveGamesQuery selects unarchived games in status $1 without activity
+// since $2.
+const inactiveGamesQuery = `
+	SELECT g.id, g.player1_id, g.player2_id, g.current_turn
+	FROM games g
+	LEFT JOIN moves m ON g.id = m.game_id
+	WHERE g.status = $1 AND g.archived_at IS NULL
+	GROUP BY g.id, g.player1_id, g.player2_id, g.current_turn, g.updated_at
+	HAVING ` + lastActivity + ` < $2`
+
+// Result is what a cleanup run did, or would do in a dry run.
+type Result struct {
+	ForfeitedGames int `json:"forfeited_games"`
+	CancelledGames int `json:"cancelled_games"`
+	ArchivedGames  int `json:"archived_games"`
+	PurgedGuests   int `json:"purged_guests"`
+	// AnonymizedGuests are expired guests that played, see expiredGuestsQuery.
+	AnonymizedGuests int `json:"anonymized_guests"`
+	// Games lists the forfeited and cancelled games. It is not kept in the
+	// run history.
+	Games []GameAction `json:"games,omitempty"`
+}
+
+// Cleanup actions on a game
+const (
+	ActionForfeit = "forfeit"
+	ActionCancel  = "cancel"
+)
+
+// GameAction is what the cleanup did to one inactive game.
+type GameAction struct {
+	GameID   int    `json:"game_id"`
+	Action   string `json:"action"`
+	WinnerID *int   `json:"winner_id,omitempty"`
+}
+
+// Run is a recorded cleanup run.
+type Run struct {
+	ID          int       `json:"id"`
+	TriggeredBy *int      `json:"triggered_by"` // admin who started it, nil for the scheduler
+	DryRun      bool      `json:"dry_run"`
+	Instance    string    `json:"instance"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Error       string    `json:"error,omitempty"`
+	Result
+}
+
+// RunOptions control a single cleanup run.
+type RunOptions struct {
+	// DryRun only reports what the run would do.
+	DryRun bool
+	// TriggeredBy is the admin starting the run, nil for the scheduler.
+	TriggeredBy *int
+	// SkipIfRanWithin skips the run when another scheduled run started this
+	// recently, so that instances sharing a database run once per cycle.
+	SkipIfRanWithin time.Duration
+}
+
+// ErrRunInProgress is returned when another run holds the cleanup lock.
+var ErrRunInProgress = errors.New("a cleanup run is already in progress")
+
 type CleanupService struct {
-	db *sql.DB
+	db          *sql.DB
+	gameService *game.GameService
+	options     Options
+	locker      Locker
+	instance    string
+	onGameEnded func(gameID int)
+	onWarning   func(userID, gameID int, left time.Duration)
+	anonymize   func(userID int) error
+}
+
+func NewCleanupService(db *sql.DB, gameService *game.GameService, options Options) *CleanupService {
+	instance, _ := os.Hostname()
+	return &CleanupService{
+		db:          db,
+		gameService: gameService,
+		options:     options,
+		locker:      &LocalLock{},
+		instance:    instance,
+	}
+}
+
+// Options returns the cleanup thresholds.
+func (c *CleanupService) Options() Options {
+	return c.options
+}
+
+// SetLocker replaces the lock that keeps runs from overlapping. Instances
+// sharing a database should use an AdvisoryLock.
+func (c *CleanupService) SetLocker(locker Locker) {
+	c.locker = locker
+}
+
+// SetGameEndedHandler registers a function called after the cleanup forfeits
+// or cancels a game, so its players can be told.
+func (c *CleanupService) SetGameEndedHandler(handler func(gameID int)) {
+	c.onGameEnded = handler
 }
 
-func NewCleanupService(db *sql.DB) *CleanupService {
-	return &CleanupService{db: db}
+// SetGuestAnonymizer registers the function that anonymizes an expired
+// guest that played. Without one such guests are kept as they are.
+func (c *CleanupService) SetGuestAnonymizer(anonymize func(userID int) error) {
+	c.anonymize = anonymize
 }
 
-// StartCleanupScheduler starts a background goroutine that periodically cleans up inactive games
-func (c *CleanupService) StartCleanupScheduler() {
-	ticker := time.NewTicker(10 * time.Minute) // Run every 10 minutes
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				if err := c.CleanupInactiveGames(); err != nil {
-					log.Printf("Error during cleanup: %v", err)
-				}
+// Start runs the cleanup every interval, and checks for players to warn
+// every minute, until ctx is cancelled.
+func (c *CleanupService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var warnings <-chan time.Time
+	if len(c.options.WarnBefore) > 0 && c.onWarning != nil {
+		warningTicker := time.NewTicker(warningCheckInterval)
+		defer warningTicker.Stop()
+		warnings = warningTicker.C
+	}
+
+	log.Printf("Cleanup scheduler started - will check for inactive games every %s", interval)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Cleanup scheduler stopped")
+			return
+		case <-ticker.C:
+			// Leave some slack so jittery tickers of other instances do not skip a cycle
+			_, err := c.Run(ctx, RunOptions{SkipIfRanWithin: interval * 9 / 10})
+			if err != nil && !errors.Is(err, ErrRunInProgress) {
+				log.Printf("Error during cleanup: %v", err)
+			}
+		case <-warnings:
+			if _, err := c.WarnInactivePlayers(ctx); err != nil && !errors.Is(err, ErrRunInProgress) {
+				log.Printf("Error warning inactive players: %v", err)
 			}
 		}
-	}()
-	log.Println("Cleanup scheduler started - will check for inactive games every 10 minutes")
+	}
 }
 
-// CleanupInactiveGames removes games that have been inactive for more than 1 hour
-func (c *CleanupService) CleanupInactiveGames() error {
-	// Define what constitutes an inactive game:
-	// 1. Games in 'waiting' status older than 1 hour
-	// 2. Games in 'active' status with no moves in the last 1 hour
+// Run performs every cleanup task once and records the run. A failing task
+// does not stop the others; the first error is returned with the run. It
+// returns nil and no error when the run was skipped.
+func (c *CleanupService) Run(ctx context.Context, opts RunOptions) (*Run, error) {
+	unlock, ok, err := c.locker.TryLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrRunInProgress
+	}
+	defer unlock()
 
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	if opts.SkipIfRanWithin > 0 {
+		recent, err := c.ranSince(time.Now().Add(-opts.SkipIfRanWithin))
+		if err != nil || recent {
+			return nil, err
+		}
+	}
 
-	// First, find games to be cleaned up
-	rows, err := c.db.Query(`
-		SELECT g.id, g.status, g.updated_at, 
-		       COALESCE(MAX(m.created_at), g.created_at) as last_activity
-		FROM games g
-		LEFT JOIN moves m ON g.id = m.game_id
-		WHERE g.status != $1 
-		GROUP BY g.id, g.status, g.updated_at
-		HAVING COALESCE(MAX(m.created_at), g.created_at) < $2
-	`, models.GameStatusFinished, oneHourAgo)
+	run := &Run{TriggeredBy: opts.TriggeredBy, DryRun: opts.DryRun, Instance: c.instance, StartedAt: time.Now()}
+	run.Result, err = c.CleanupInactiveGames(opts.DryRun)
 
+	archived, archiveErr := c.ArchiveFinishedGames(opts.DryRun)
+	run.ArchivedGames = archived
+	if err == nil {
+		err = archiveErr
+	}
+
+	var purged int
+	var purgeErr error
+	if opts.DryRun {
+		purged, purgeErr = c.GetAbandonedGuestsCount()
+	} else {
+		purged, purgeErr = c.PurgeAbandonedGuests()
+	}
+	run.PurgedGuests = purged
+	if err == nil {
+		err = purgeErr
+	}
+
+	var anonymized int
+	var anonymizeErr error
+	if opts.DryRun {
+		anonymized, anonymizeErr = c.GetExpiredGuestsCount()
+	} else {
+		anonymized, anonymizeErr = c.AnonymizeExpiredGuests()
+	}
+	run.AnonymizedGuests = anonymized
+	if err == nil {
+		err = anonymizeErr
+	}
+
+	run.FinishedAt = time.Now()
 	if err != nil {
-		return err
+		run.Error = err.Error()
+	}
+	if recordErr := c.record(run); recordErr != nil {
+		log.Printf("Failed to record cleanup run: %v", recordErr)
+	}
+	return run, err
+}
+
+// ranSince reports whether a scheduled run started after t.
+func (c *CleanupService) ranSince(t time.Time) (bool, error) {
+	var count int
+	err := c.db.QueryRow(`
+		SELECT COUNT(*) FROM cleanup_runs
+		WHERE triggered_by IS NULL AND dry_run = FALSE AND started_at > $1`, t).Scan(&count)
+	return count > 0, err
+}
+
+func (c *CleanupService) record(run *Run) error {
+	return c.db.QueryRow(`
+		INSERT INTO cleanup_runs (triggered_by, dry_run, instance, started_at, finished_at,
+			forfeited_games, cancelled_games, archived_games, purged_guests, anonymized_guests, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id`,
+		run.TriggeredBy, run.DryRun, run.Instance, run.StartedAt, run.FinishedAt,
+		run.ForfeitedGames, run.CancelledGames, run.ArchivedGames, run.PurgedGuests, run.AnonymizedGuests, run.Error).Scan(&run.ID)
+}
+
+// History returns the most recent runs, newest first.
+func (c *CleanupService) History(limit int) ([]Run, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	rows, err := c.db.Query(`
+		SELECT id, triggered_by, dry_run, instance, started_at, finished_at,
+			forfeited_games, cancelled_games, archived_games, purged_guests, anonymized_guests, error
+		FROM cleanup_runs ORDER BY started_at DESC, id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
-	var gamesToCleanup []int
+	runs := []Run{}
 	for rows.Next() {
-		var gameID int
-		var status string
-		var updatedAt, lastActivity time.Time
+		var run Run
+		if err := rows.Scan(&run.ID, &run.TriggeredBy, &run.DryRun, &run.Instance, &run.StartedAt, &run.FinishedAt,
+			&run.ForfeitedGames, &run.CancelledGames, &run.ArchivedGames, &run.PurgedGuests, &run.AnonymizedGuests, &run.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// CleanupInactiveGames ends games nobody plays anymore. Active games without
+// a move for ActiveTimeout are forfeited by the player whose turn it is,
+// scored like any other win. Games waiting for an opponent for
+// WaitingTimeout are cancelled. Nothing is deleted. A dry run only reports
+// the games.
+func (c *CleanupService) CleanupInactiveGames(dryRun bool) (Result, error) {
+	var result Result
 
-		if err := rows.Scan(&gameID, &status, &updatedAt, &lastActivity); err != nil {
-			log.Printf("Error scanning game for cleanup: %v", err)
+	idleSince := time.Now().Add(-c.options.ActiveTimeout)
+	stale, err := c.inactiveGames(models.GameStatusActive, idleSince)
+	if err != nil {
+		return result, err
+	}
+	for _, g := range stale {
+		winnerID, err := c.forfeitWinner(g)
+		if err != nil {
+			log.Printf("Error picking the winner of inactive game %d: %v", g.ID, err)
 			continue
 		}
+		if !dryRun {
+			// The snapshot may be stale: a player may have moved since
+			err := c.gameService.FinishIdleGame(g.ID, winnerID, g.CurrentTurn, idleSince)
+			if errors.Is(err, game.ErrGameNotIdle) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Error forfeiting inactive game %d: %v", g.ID, err)
+				continue
+			}
+			if winnerID != nil {
+				log.Printf("Inactive game %d forfeited, UserID %d wins", g.ID, *winnerID)
+			} else {
+				log.Printf("Inactive game %d closed without a winner", g.ID)
+			}
+			c.gameEnded(g.ID)
+		}
+		result.ForfeitedGames++
+		result.Games = append(result.Games, GameAction{GameID: g.ID, Action: ActionForfeit, WinnerID: winnerID})
+	}
 
-		log.Printf("Found inactive game %d (status: %s, last activity: %s)",
-			gameID, status, lastActivity.Format(time.RFC3339))
-		gamesToCleanup = append(gamesToCleanup, gameID)
+	waiting, err := c.inactiveGames(models.GameStatusWaiting, time.Now().Add(-c.options.WaitingTimeout))
+	if err != nil {
+		return result, err
 	}
+	for _, g := range waiting {
+		if !dryRun {
+			if err := c.gameService.CancelGame(g.ID); err != nil {
+				log.Printf("Error cancelling waiting game %d: %v", g.ID, err)
+				continue
+			}
+			c.gameEnded(g.ID)
+		}
+		result.CancelledGames++
+		result.Games = append(result.Games, GameAction{GameID: g.ID, Action: ActionCancel})
+	}
+
+	if !dryRun && (result.ForfeitedGames > 0 || result.CancelledGames > 0) {
+		log.Printf("Cleaned up inactive games: %d forfeited, %d cancelled", result.ForfeitedGames, result.CancelledGames)
+	}
+	return result, nil
+}
 
-	// Clean up each game
-	for _, gameID := range gamesToCleanup {
-		if err := c.cleanupGame(gameID); err != nil {
-			log.Printf("Error cleaning up game %d: %v", gameID, err)
-		} else {
-			log.Printf("Successfully cleaned up inactive game %d", gameID)
+// inactiveGames returns the games in a status without activity since idleSince.
+func (c *CleanupService) inactiveGames(status string, idleSince time.Time) ([]models.Game, error) {
+	rows, err := c.db.Query(inactiveGamesQuery, status, idleSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []models.Game
+	for rows.Next() {
+		var g models.Game
+		if err := rows.Scan(&g.ID, &g.Player1ID, &g.Player2ID, &g.CurrentTurn); err != nil {
+			return nil, err
+		}
+		g.Status = status
+		games = append(games, g)
+	}
+	return games, rows.Err()
+}
+
+// forfeitWinner picks the winner of a stale active game: the opponent of
+// the player whose turn it is. Before the first move, a player who placed
+// their ships wins against one who did not. Otherwise there is no winner.
+func (c *CleanupService) forfeitWinner(g models.Game) (*int, error) {
+	if g.Player2ID == nil {
+		return nil, nil
+	}
+	opponent := func(playerID int) *int {
+		if playerID == g.Player1ID {
+			return g.Player2ID
 		}
+		return &g.Player1ID
+	}
+	if g.CurrentTurn != nil {
+		return opponent(*g.CurrentTurn), nil
 	}
 
-	if len(gamesToCleanup) > 0 {
-		log.Printf("Cleaned up %d inactive games", len(gamesToCleanup))
+	var placed []int
+	for _, playerID := range []int{g.Player1ID, *g.Player2ID} {
+		var ships int
+		err := c.db.QueryRow("SELECT COUNT(*) FROM ships WHERE game_id = $1 AND player_id = $2", g.ID, playerID).Scan(&ships)
+		if err != nil {
+			return nil, err
+		}
+		if ships == len(models.ShipTypes) {
+			placed = append(placed, playerID)
+		}
 	}
+	if len(placed) == 1 {
+		return &placed[0], nil
+	}
+	return nil, nil
+}
 
-	return nil
+func (c *CleanupService) gameEnded(gameID int) {
+	if c.onGameEnded != nil {
+		c.onGameEnded(gameID)
+	}
 }
 
-// cleanupGame removes a specific game and all its related data
-func (c *CleanupService) cleanupGame(gameID int) error {
+// archivableGames selects finished and cancelled games last updated before $3.
+const archivableGames = `
+	SELECT id FROM games
+	WHERE status IN ($1, $2) AND archived_at IS NULL AND updated_at < $3`
+
+// ArchiveFinishedGames archives finished and cancelled games older than
+// ArchiveAfter and drops their event log, which only serves reconnecting
+// clients. Moves, ships and chat are kept. A dry run only counts the games.
+func (c *CleanupService) ArchiveFinishedGames(dryRun bool) (int, error) {
+	cutoff := time.Now().Add(-c.options.ArchiveAfter)
+	if dryRun {
+		var count int
+		err := c.db.QueryRow(`SELECT COUNT(*) FROM (`+archivableGames+`) AS archivable_games`,
+			models.GameStatusFinished, models.GameStatusCancelled, cutoff).Scan(&count)
+		return count, err
+	}
+
 	tx, err := c.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
 
-	// Delete in the correct order to respect foreign key constraints
-	// 1. Delete moves (references ships and games)
-	if _, err := tx.Exec("DELETE FROM moves WHERE game_id = $1", gameID); err != nil {
-		return err
+	if _, err := tx.Exec(`DELETE FROM game_events WHERE game_id IN (`+archivableGames+`)`,
+		models.GameStatusFinished, models.GameStatusCancelled, cutoff); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`
+		UPDATE games SET archived_at = CURRENT_TIMESTAMP
+		WHERE status IN ($1, $2) AND archived_at IS NULL AND updated_at < $3`,
+		models.GameStatusFinished, models.GameStatusCancelled, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
 
-	// 2. Delete chat messages (references games)
-	if _, err := tx.Exec("DELETE FROM chat_messages WHERE game_id = $1", gameID); err != nil {
-		return err
+	archived, _ := result.RowsAffected()
+	if archived > 0 {
+		log.Printf("Archived %d finished games", archived)
 	}
+	return int(archived), nil
+}
 
-	// 3. Delete ships (references games)
-	if _, err := tx.Exec("DELETE FROM ships WHERE game_id = $1", gameID); err != nil {
-		return err
+// GetInactiveGamesCount returns the number of games the next run would forfeit or cancel
+func (c *CleanupService) GetInactiveGamesCount() (int, error) {
+	count := 0
+	for status, timeout := range map[string]time.Duration{
+		models.GameStatusActive:  c.options.ActiveTimeout,
+		models.GameStatusWaiting: c.options.WaitingTimeout,
+	} {
+		var n int
+		err := c.db.QueryRow(`SELECT COUNT(*) FROM (`+inactiveGamesQuery+`) AS inactive_games`,
+			status, time.Now().Add(-timeout)).Scan(&n)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// PurgeAbandonedGuests deletes abandoned guest accounts and returns how many were removed.
+func (c *CleanupService) PurgeAbandonedGuests() (int, error) {
+	guestIDs, err := c.expiredGuests(abandonedGuestsQuery)
+	if err != nil {
+		return 0, err
 	}
 
-	// 4. Finally delete the game itself
-	if _, err := tx.Exec("DELETE FROM games WHERE id = $1", gameID); err != nil {
+	purged := 0
+	for _, id := range guestIDs {
+		if err := c.purgeUser(id); err != nil {
+			log.Printf("Error purging guest %d: %v", id, err)
+			continue
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d abandoned guest accounts", purged)
+	}
+	return purged, nil
+}
+
+// purgeUser removes a user and the rows that reference it.
+func (c *CleanupService) purgeUser(userID int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM scores WHERE player_id = $1",
+		"DELETE FROM auth_tokens WHERE user_id = $1",
+		"DELETE FROM recovery_codes WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1",
+		"DELETE FROM notifications WHERE user_id = $1",
+		"DELETE FROM chat_reports WHERE reporter_id = $1",
+		"DELETE FROM chat_read_markers WHERE user_id = $1",
+		"DELETE FROM users WHERE id = $1 AND is_guest = TRUE",
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
 
-// GetInactiveGamesCount returns the number of games that would be cleaned up
-func (c *CleanupService) GetInactiveGamesCount() (int, error) {
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
-
+// GetAbandonedGuestsCount returns the number of guest accounts that would be purged
+func (c *CleanupService) GetAbandonedGuestsCount() (int, error) {
 	var count int
-	err := c.db.QueryRow(`
-		SELECT COUNT(*)
-		FROM (
-			SELECT g.id
-			FROM games g
-			LEFT JOIN moves m ON g.id = m.game_id
-			WHERE g.status != $1 
-			GROUP BY g.id, g.created_at
-			HAVING COALESCE(MAX(m.created_at), g.created_at) < $2
-		) as inactive_games
-	`, models.GameStatusFinished, oneHourAgo).Scan(&count)
-
-	if err != nil && err != sql.ErrNoRows {
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM (`+abandonedGuestsQuery+`) AS abandoned_guests`,
+		time.Now().Add(-auth.GuestTokenTTL)).Scan(&count)
+	return count, err
+}
+
+// AnonymizeExpiredGuests anonymizes expired guests that played or chatted and
+// returns how many were anonymized. It does nothing without an anonymizer.
+func (c *CleanupService) AnonymizeExpiredGuests() (int, error) {
+	if c.anonymize == nil {
+		return 0, nil
+	}
+	guestIDs, err := c.expiredGuests(expiredGuestsQuery)
+	if err != nil {
 		return 0, err
 	}
 
-	return count, nil
+	anonymized := 0
+	for _, id := range guestIDs {
+		if err := c.anonymize(id); err != nil {
+			log.Printf("Error anonymizing guest %d: %v", id, err)
+			continue
+		}
+		anonymized++
+	}
+
+	if anonymized > 0 {
+		log.Printf("Anonymized %d expired guest accounts", anonymized)
+	}
+	return anonymized, nil
+}
+
+// GetExpiredGuestsCount returns the number of guest accounts that would be anonymized
+func (c *CleanupService) GetExpiredGuestsCount() (int, error) {
+	if c.anonymize == nil {
+		return 0, nil
+	}
+	var count int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM (`+expiredGuestsQuery+`) AS expired_guests`,
+		time.Now().Add(-auth.GuestTokenTTL)).Scan(&count)
+	return count, err
+}
+
+// expiredGuests returns the IDs selected by one of the expired guest queries.
+func (c *CleanupService) expiredGuests(query string) ([]int, error) {
+	rows, err := c.db.Query(query, time.Now().Add(-auth.GuestTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guestIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		guestIDs = append(guestIDs, id)
+	}
+	return guestIDs, rows.Err()
 }
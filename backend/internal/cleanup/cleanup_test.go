@@ -0,0 +1,393 @@
+package cleanup
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"battleship-go/internal/auth"
+	"battleship-go/internal/game"
+	"battleship-go/internal/models"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+			deleted_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE chat_messages (id INTEGER PRIMARY KEY AUTOINCREMENT, player_id INTEGER NOT NULL);
+
+		CREATE TABLE games (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player1_id INTEGER NOT NULL,
+			player2_id INTEGER,
+			status TEXT DEFAULT 'waiting',
+			current_turn INTEGER,
+			winner_id INTEGER,
+			invited_player_id INTEGER,
+			archived_at DATETIME,
+			inactivity_warned_at DATETIME,
+			inactivity_warning INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE ships (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER NOT NULL, player_id INTEGER NOT NULL);
+		CREATE TABLE moves (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE game_events (id INTEGER PRIMARY KEY AUTOINCREMENT, game_id INTEGER NOT NULL);
+
+		CREATE TABLE cleanup_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			triggered_by INTEGER,
+			dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+			instance TEXT NOT NULL DEFAULT '',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME NOT NULL,
+			forfeited_games INTEGER NOT NULL DEFAULT 0,
+			cancelled_games INTEGER NOT NULL DEFAULT 0,
+			archived_games INTEGER NOT NULL DEFAULT 0,
+			purged_guests INTEGER NOT NULL DEFAULT 0,
+			anonymized_guests INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE scores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_id INTEGER UNIQUE NOT NULL,
+			wins INTEGER DEFAULT 0,
+			losses INTEGER DEFAULT 0,
+			points INTEGER DEFAULT 0
+		);
+
+		INSERT INTO users (id, username) VALUES (1, 'alice'), (2, 'bob');
+		INSERT INTO scores (player_id) VALUES (1), (2);
+	`)
+	require.NoError(t, err)
+	return db
+}
+
+func TestCleanupService_CleanupInactiveGames(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	stale := time.Now().Add(-2 * time.Hour)
+	recent := time.Now().Add(-10 * time.Minute)
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn, updated_at) VALUES
+		(1, 1, 2, 'active', 2, $1),
+		(2, 1, 2, 'active', 1, $1),
+		(3, 1, 2, 'active', NULL, $1),
+		(4, 1, NULL, 'waiting', NULL, $1),
+		(5, 1, NULL, 'waiting', NULL, $2)`, stale, recent)
+	require.NoError(t, err)
+
+	// Game 2 had a recent move; in game 3 only alice placed her ships
+	_, err = db.Exec(`INSERT INTO moves (game_id, created_at) VALUES (2, $1)`, recent)
+	require.NoError(t, err)
+	for i := 0; i < len(models.ShipTypes); i++ {
+		_, err = db.Exec(`INSERT INTO ships (game_id, player_id) VALUES (3, 1)`)
+		require.NoError(t, err)
+	}
+
+	service := NewCleanupService(db, game.NewGameService(db), DefaultOptions)
+	var ended []int
+	service.SetGameEndedHandler(func(gameID int) { ended = append(ended, gameID) })
+
+	count, err := service.GetInactiveGamesCount()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	result, err := service.CleanupInactiveGames(false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.ForfeitedGames)
+	assert.Equal(t, 1, result.CancelledGames)
+	assert.ElementsMatch(t, []int{1, 3, 4}, ended)
+
+	status := func(gameID int) (string, *int) {
+		var s string
+		var winner *int
+		require.NoError(t, db.QueryRow("SELECT status, winner_id FROM games WHERE id = $1", gameID).Scan(&s, &winner))
+		return s, winner
+	}
+
+	t.Run("player whose turn it was forfeits", func(t *testing.T) {
+		s, winner := status(1)
+		assert.Equal(t, models.GameStatusFinished, s)
+		require.NotNil(t, winner)
+		assert.Equal(t, 1, *winner)
+
+		var wins, losses int
+		require.NoError(t, db.QueryRow("SELECT wins FROM scores WHERE player_id = 1").Scan(&wins))
+		require.NoError(t, db.QueryRow("SELECT losses FROM scores WHERE player_id = 2").Scan(&losses))
+		assert.Equal(t, 2, wins)
+		assert.Equal(t, 2, losses)
+	})
+
+	t.Run("player without ships forfeits", func(t *testing.T) {
+		s, winner := status(3)
+		assert.Equal(t, models.GameStatusFinished, s)
+		require.NotNil(t, winner)
+		assert.Equal(t, 1, *winner)
+	})
+
+	t.Run("recent games are left alone", func(t *testing.T) {
+		s, _ := status(2)
+		assert.Equal(t, models.GameStatusActive, s)
+		s, _ = status(5)
+		assert.Equal(t, models.GameStatusWaiting, s)
+	})
+
+	t.Run("stale waiting game is cancelled, not deleted", func(t *testing.T) {
+		s, winner := status(4)
+		assert.Equal(t, models.GameStatusCancelled, s)
+		assert.Nil(t, winner)
+	})
+}
+
+func TestCleanupService_ArchiveFinishedGames(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	old := time.Now().Add(-8 * 24 * time.Hour)
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, updated_at) VALUES
+		(1, 1, 2, 'finished', $1),
+		(2, 1, NULL, 'cancelled', $1),
+		(3, 1, 2, 'finished', $2),
+		(4, 1, 2, 'active', $1)`, old, time.Now())
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO game_events (game_id) VALUES (1), (3)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO moves (game_id) VALUES (1)`)
+	require.NoError(t, err)
+
+	service := NewCleanupService(db, game.NewGameService(db), DefaultOptions)
+	archived, err := service.ArchiveFinishedGames(true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, archived)
+
+	archived, err = service.ArchiveFinishedGames(false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, archived)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM games WHERE archived_at IS NOT NULL").Scan(&count))
+	assert.Equal(t, 2, count)
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM game_events").Scan(&count))
+	assert.Equal(t, 1, count)
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM moves").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	// Archiving again finds nothing new
+	archived, err = service.ArchiveFinishedGames(false)
+	require.NoError(t, err)
+	assert.Zero(t, archived)
+}
+
+func TestCleanupService_Run(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn, updated_at) VALUES
+		(1, 1, 2, 'active', 2, $1)`, time.Now().Add(-2*time.Hour))
+	require.NoError(t, err)
+
+	service := NewCleanupService(db, game.NewGameService(db), DefaultOptions)
+	ctx := context.Background()
+	adminID := 1
+
+	t.Run("dry run changes nothing", func(t *testing.T) {
+		run, err := service.Run(ctx, RunOptions{DryRun: true, TriggeredBy: &adminID})
+		require.NoError(t, err)
+		assert.True(t, run.DryRun)
+		assert.Equal(t, 1, run.ForfeitedGames)
+		require.Len(t, run.Games, 1)
+		assert.Equal(t, ActionForfeit, run.Games[0].Action)
+		assert.Equal(t, 1, *run.Games[0].WinnerID)
+
+		var status string
+		require.NoError(t, db.QueryRow("SELECT status FROM games WHERE id = 1").Scan(&status))
+		assert.Equal(t, models.GameStatusActive, status)
+	})
+
+	t.Run("run forfeits and is recorded", func(t *testing.T) {
+		run, err := service.Run(ctx, RunOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, run.ForfeitedGames)
+
+		runs, err := service.History(0)
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		assert.Equal(t, run.ID, runs[0].ID)
+		assert.Nil(t, runs[0].TriggeredBy)
+		assert.Equal(t, 1, runs[0].ForfeitedGames)
+		assert.True(t, runs[1].DryRun)
+		assert.Equal(t, adminID, *runs[1].TriggeredBy)
+	})
+
+	t.Run("scheduled run skipped after a recent one", func(t *testing.T) {
+		run, err := service.Run(ctx, RunOptions{SkipIfRanWithin: time.Hour})
+		require.NoError(t, err)
+		assert.Nil(t, run)
+	})
+
+	t.Run("runs do not overlap", func(t *testing.T) {
+		lock := &LocalLock{}
+		service.SetLocker(lock)
+		unlock, ok, err := lock.TryLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		defer unlock()
+
+		_, err = service.Run(ctx, RunOptions{})
+		assert.ErrorIs(t, err, ErrRunInProgress)
+	})
+}
+
+func TestCleanupService_WarnInactivePlayers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn, updated_at) VALUES
+		(1, 1, 2, 'active', 2, $1),
+		(2, 1, 2, 'active', NULL, $1),
+		(3, 1, NULL, 'waiting', NULL, $1),
+		(4, 1, 2, 'active', 1, $2)`, time.Now().Add(-52*time.Minute), time.Now())
+	require.NoError(t, err)
+
+	service := NewCleanupService(db, game.NewGameService(db), DefaultOptions)
+	type warning struct {
+		userID, gameID int
+		left           time.Duration
+	}
+	var warnings []warning
+	service.SetInactivityWarningHandler(func(userID, gameID int, left time.Duration) {
+		warnings = append(warnings, warning{userID, gameID, left})
+	})
+	warn := func() []warning {
+		warnings = nil
+		_, err := service.WarnInactivePlayers(context.Background())
+		require.NoError(t, err)
+		return warnings
+	}
+
+	// The player to move is warned; without ships placed both are
+	assert.ElementsMatch(t, []warning{
+		{2, 1, 10 * time.Minute},
+		{1, 2, 10 * time.Minute},
+		{2, 2, 10 * time.Minute},
+	}, warn())
+	assert.Empty(t, warn())
+
+	// Closer to the forfeit comes the last warning
+	_, err = db.Exec(`UPDATE games SET updated_at = $1 WHERE id = 1`, time.Now().Add(-59*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []warning{{2, 1, 2 * time.Minute}}, warn())
+	assert.Empty(t, warn())
+
+	// A new idle period after the player came back warns again
+	_, err = db.Exec(`UPDATE games SET inactivity_warned_at = $1, updated_at = $2 WHERE id = 1`,
+		time.Now().Add(-2*time.Hour), time.Now().Add(-52*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []warning{{2, 1, 10 * time.Minute}}, warn())
+
+	t.Run("still here resets the timer", func(t *testing.T) {
+		gameService := game.NewGameService(db)
+		require.NoError(t, gameService.MarkActive(4, 1))
+		assert.Error(t, gameService.MarkActive(3, 1))
+		assert.Error(t, gameService.MarkActive(1, 3))
+
+		count, err := service.GetInactiveGamesCount()
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
+
+func TestCleanupService_ExpiredGuests(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec(`
+		CREATE TABLE auth_tokens (user_id INTEGER NOT NULL);
+		CREATE TABLE recovery_codes (user_id INTEGER NOT NULL);
+		CREATE TABLE user_identities (user_id INTEGER NOT NULL);
+		CREATE TABLE user_blocks (blocker_id INTEGER NOT NULL, blocked_id INTEGER NOT NULL);
+		CREATE TABLE notifications (user_id INTEGER NOT NULL);
+		CREATE TABLE chat_reports (reporter_id INTEGER NOT NULL);
+		CREATE TABLE chat_read_markers (user_id INTEGER NOT NULL);`)
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-2 * auth.GuestTokenTTL)
+	_, err = db.Exec(`INSERT INTO users (id, username, is_guest, created_at) VALUES
+		(3, 'idle_guest', TRUE, $1), (4, 'played_guest', TRUE, $1), (5, 'chatty_guest', TRUE, $1),
+		(6, 'fresh_guest', TRUE, CURRENT_TIMESTAMP)`, expired)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		INSERT INTO games (id, player1_id, player2_id, status) VALUES (1, 4, 1, 'finished'), (2, 6, 2, 'finished');
+		INSERT INTO chat_messages (player_id) VALUES (5);`)
+	require.NoError(t, err)
+
+	service := NewCleanupService(db, game.NewGameService(db), DefaultOptions)
+	var anonymized []int
+	service.SetGuestAnonymizer(func(userID int) error {
+		anonymized = append(anonymized, userID)
+		_, err := db.Exec("UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", userID)
+		return err
+	})
+
+	t.Run("dry run counts both kinds", func(t *testing.T) {
+		run, err := service.Run(context.Background(), RunOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 1, run.PurgedGuests)
+		assert.Equal(t, 2, run.AnonymizedGuests)
+		assert.Empty(t, anonymized)
+	})
+
+	t.Run("guests that played are anonymized, the others purged", func(t *testing.T) {
+		run, err := service.Run(context.Background(), RunOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, run.PurgedGuests)
+		assert.Equal(t, 2, run.AnonymizedGuests)
+		assert.ElementsMatch(t, []int{4, 5}, anonymized)
+
+		var ids []int
+		rows, err := db.Query("SELECT id FROM users ORDER BY id")
+		require.NoError(t, err)
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		assert.Equal(t, []int{1, 2, 4, 5, 6}, ids)
+
+		runs, err := service.History(1)
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		assert.Equal(t, 2, runs[0].AnonymizedGuests)
+	})
+
+	t.Run("anonymized guests are not picked again", func(t *testing.T) {
+		anonymized = nil
+		count, err := service.AnonymizeExpiredGuests()
+		require.NoError(t, err)
+		assert.Zero(t, count)
+		assert.Empty(t, anonymized)
+	})
+}
@@ -0,0 +1,63 @@
+package cleanup
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// AdvisoryLockKey identifies the cleanup's Postgres advisory lock.
+const AdvisoryLockKey int64 = 0x62737031 // "bsp1"
+
+// Locker keeps cleanup runs from overlapping.
+type Locker interface {
+	// TryLock takes the lock if it is free and returns the function that
+	// releases it. It reports false when the lock is held elsewhere.
+	TryLock(ctx context.Context) (unlock func(), ok bool, err error)
+}
+
+// LocalLock only keeps the runs of one instance from overlapping.
+type LocalLock struct {
+	mu sync.Mutex
+}
+
+func (l *LocalLock) TryLock(ctx context.Context) (func(), bool, error) {
+	if !l.mu.TryLock() {
+		return nil, false, nil
+	}
+	return l.mu.Unlock, true, nil
+}
+
+// AdvisoryLock is a Postgres session advisory lock shared by every instance
+// using the database. The session is held on a dedicated connection, so the
+// lock is released even if the instance dies during a run.
+type AdvisoryLock struct {
+	db  *sql.DB
+	key int64
+}
+
+func NewAdvisoryLock(db *sql.DB, key int64) *AdvisoryLock {
+	return &AdvisoryLock{db: db, key: key}
+}
+
+func (l *AdvisoryLock) TryLock(ctx context.Context) (func(), bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+		conn.Close()
+	}, true, nil
+}
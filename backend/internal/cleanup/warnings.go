@@ -0,0 +1,117 @@
+package cleanup
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"battleship-go/internal/models"
+)
+
+// warningCheckInterval is how often the scheduler looks for players to warn.
+const warningCheckInterval = time.Minute
+
+// warnableGamesQuery selects active games without activity since $2 whose
+// players were not yet warned $3 seconds or less before the forfeit during
+// this idle period.
+const warnableGamesQuery = `
+	SELECT g.id, g.player1_id, g.player2_id, g.current_turn
+	FROM games g
+	LEFT JOIN moves m ON g.id = m.game_id
+	WHERE g.status = $1 AND g.archived_at IS NULL
+	GROUP BY g.id, g.player1_id, g.player2_id, g.current_turn, g.updated_at, g.inactivity_warned_at, g.inactivity_warning
+	HAVING ` + lastActivity + ` < $2
+	   AND (g.inactivity_warned_at IS NULL OR g.inactivity_warned_at < ` + lastActivity + ` OR g.inactivity_warning > $3)`
+
+// SetInactivityWarningHandler registers the function that warns a player
+// their game is forfeited in about left unless they move or say they are
+// still there.
+func (c *CleanupService) SetInactivityWarningHandler(handler func(userID, gameID int, left time.Duration)) {
+	c.onWarning = handler
+}
+
+// WarnInactivePlayers warns the players about to forfeit an inactive game,
+// once per WarnBefore offset each time the game goes idle. A game found late
+// gets only the warning closest to its forfeit. It returns the number of
+// warnings sent.
+func (c *CleanupService) WarnInactivePlayers(ctx context.Context) (int, error) {
+	if c.onWarning == nil {
+		return 0, nil
+	}
+	unlock, ok, err := c.locker.TryLock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrRunInProgress
+	}
+	defer unlock()
+
+	offsets := append([]time.Duration(nil), c.options.WarnBefore...)
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	sent := 0
+	for _, before := range offsets {
+		if before <= 0 || before >= c.options.ActiveTimeout {
+			continue
+		}
+		games, err := c.warnableGames(c.options.ActiveTimeout-before, before)
+		if err != nil {
+			return sent, err
+		}
+		for _, g := range games {
+			if _, err := c.db.Exec(`UPDATE games SET inactivity_warned_at = $1, inactivity_warning = $2 WHERE id = $3`,
+				time.Now(), int(before.Seconds()), g.ID); err != nil {
+				log.Printf("Error recording inactivity warning for game %d: %v", g.ID, err)
+				continue
+			}
+			losers, err := c.forfeitLosers(g)
+			if err != nil {
+				log.Printf("Error picking the losers of inactive game %d: %v", g.ID, err)
+				continue
+			}
+			for _, userID := range losers {
+				c.onWarning(userID, g.ID, before)
+				sent++
+			}
+		}
+	}
+	return sent, nil
+}
+
+func (c *CleanupService) warnableGames(idle, before time.Duration) ([]models.Game, error) {
+	rows, err := c.db.Query(warnableGamesQuery, models.GameStatusActive, time.Now().Add(-idle), int(before.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []models.Game
+	for rows.Next() {
+		var g models.Game
+		if err := rows.Scan(&g.ID, &g.Player1ID, &g.Player2ID, &g.CurrentTurn); err != nil {
+			return nil, err
+		}
+		games = append(games, g)
+	}
+	return games, rows.Err()
+}
+
+// forfeitLosers returns the players who would lose an inactive game: the
+// opponent of the winner, or everyone when there would be no winner.
+func (c *CleanupService) forfeitLosers(g models.Game) ([]int, error) {
+	winnerID, err := c.forfeitWinner(g)
+	switch {
+	case err != nil:
+		return nil, err
+	case g.Player2ID == nil:
+		return []int{g.Player1ID}, nil
+	case winnerID == nil:
+		return []int{g.Player1ID, *g.Player2ID}, nil
+	case *winnerID == g.Player1ID:
+		return []int{*g.Player2ID}, nil
+	default:
+		return []int{g.Player1ID}, nil
+	}
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Environment = EnvProduction
+	cfg.JWT.Secret = strings.Repeat("s", MinJWTSecretLength)
+	return cfg
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Run("valid production config", func(t *testing.T) {
+		assert.NoError(t, validConfig().Validate())
+	})
+
+	t.Run("unknown rate limit store", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.RateLimit.Store = "redis"
+		assert.ErrorContains(t, cfg.Validate(), "RATE_LIMIT_STORE")
+	})
+
+	t.Run("invalid chat settings", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Chat.MaxLength = 0
+		cfg.Chat.FilterMode = "censor"
+		err := cfg.Validate()
+		assert.ErrorContains(t, err, "CHAT_MAX_LENGTH")
+		assert.ErrorContains(t, err, "CHAT_FILTER_MODE")
+	})
+
+	t.Run("ping interval must be shorter than the pong timeout", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.WebSocket.PingInterval = time.Minute
+		assert.ErrorContains(t, cfg.Validate(), "WS_PING_INTERVAL")
+
+		cfg = validConfig()
+		cfg.WebSocket.WriteTimeout = 0
+		cfg.WebSocket.MaxMessageSize = 0
+		err := cfg.Validate()
+		assert.ErrorContains(t, err, "WS_WRITE_TIMEOUT")
+		assert.ErrorContains(t, err, "WS_MAX_MESSAGE_SIZE")
+
+		cfg = validConfig()
+		cfg.WebSocket.SlowConsumer = "block"
+		assert.ErrorContains(t, cfg.Validate(), "WS_SLOW_CONSUMER")
+
+		cfg = validConfig()
+		cfg.WebSocket.SendQueueSize = 0
+		assert.ErrorContains(t, cfg.Validate(), "WS_SEND_QUEUE_SIZE")
+	})
+
+	t.Run("cleanup thresholds must be positive", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Cleanup.ArchiveAfter = 0
+		assert.ErrorContains(t, cfg.Validate(), "CLEANUP_ARCHIVE_AFTER")
+
+		cfg = validConfig()
+		cfg.Cleanup.WarnBefore = []time.Duration{10 * time.Minute, 2 * time.Hour}
+		assert.ErrorContains(t, cfg.Validate(), "CLEANUP_WARN_BEFORE 2h0m0s")
+	})
+
+	t.Run("board size and token lifetime", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Game.BoardSize = 4
+		cfg.JWT.TokenTTL = 0
+		err := cfg.Validate()
+		assert.ErrorContains(t, err, "GAME_BOARD_SIZE must be between 5 and 26")
+		assert.ErrorContains(t, err, "JWT_TOKEN_TTL")
+	})
+
+	t.Run("default secret allowed in development", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Environment = EnvDevelopment
+		cfg.JWT.Secret = DefaultJWTSecret
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("default secret rejected in production", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.JWT.Secret = DefaultJWTSecret
+		err := cfg.Validate()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "well-known default")
+	})
+
+	t.Run("short secret rejected in production", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.JWT.Secret = "short"
+		err := cfg.Validate()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "at least")
+	})
+
+	t.Run("short verification key rejected in production", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.JWT.VerifyKeys = map[string]string{"old": "short"}
+		assert.Error(t, cfg.Validate())
+	})
+
+	t.Run("asymmetric algorithm requires a private key", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.JWT.Algorithm = JWTAlgorithmRS256
+		err := cfg.Validate()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "JWT_PRIVATE_KEY_FILE")
+	})
+
+	t.Run("unknown algorithm", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.JWT.Algorithm = "none"
+		assert.Error(t, cfg.Validate())
+	})
+
+	t.Run("smtp driver requires a host", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Mail.Driver = MailDriverSMTP
+		err := cfg.Validate()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "SMTP_HOST")
+	})
+
+	t.Run("unknown environment", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Environment = "staging"
+		assert.Error(t, cfg.Validate())
+	})
+}
+
+func TestLoadOAuthProviders(t *testing.T) {
+	t.Setenv("OAUTH_PROVIDERS", "SSO, github")
+	t.Setenv("OAUTH_SSO_ISSUER_URL", "https://sso.example.com")
+	t.Setenv("OAUTH_SSO_CLIENT_ID", "battleship")
+	t.Setenv("OAUTH_SSO_REDIRECT_URL", "https://battleship.example.com/api/auth/oauth/sso/callback")
+	t.Setenv("OAUTH_GITHUB_CLIENT_ID", "gh-client")
+	t.Setenv("OAUTH_GITHUB_SCOPES", "read:user")
+
+	providers := loadOAuthProviders()
+	assert.Len(t, providers, 2)
+
+	assert.Equal(t, "sso", providers[0].Name)
+	assert.Equal(t, "https://sso.example.com", providers[0].IssuerURL)
+	assert.Equal(t, []string{"openid", "profile", "email"}, providers[0].Scopes)
+
+	assert.Equal(t, "github", providers[1].Name)
+	assert.Equal(t, "https://github.com/login/oauth/access_token", providers[1].TokenURL)
+	assert.Equal(t, []string{"read:user"}, providers[1].Scopes)
+
+	cfg := validConfig()
+	cfg.OAuth.Providers = providers
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"github" requires a client ID and redirect URL`)
+}
+
+func TestConfig_Print(t *testing.T) {
+	cfg := Default()
+	cfg.Database.URL = "postgres://battleship:hunter2@db:5432/battleship"
+	cfg.JWT.VerifyKeys = map[string]string{"old": "retired-secret"}
+	cfg.Mail.SMTP.Password = "smtp-password"
+	cfg.OAuth.Providers = []OAuthProviderConfig{{Name: "sso", ClientID: "battleship", ClientSecret: "client-secret"}}
+
+	var out strings.Builder
+	require.NoError(t, cfg.Print(&out))
+	printed := out.String()
+
+	for _, secret := range []string{"hunter2", DefaultJWTSecret, "retired-secret", "smtp-password", "client-secret"} {
+		assert.NotContains(t, printed, secret)
+	}
+	assert.Contains(t, printed, "postgres://battleship:xxxxx@db:5432/battleship")
+	assert.Contains(t, printed, "client_id: battleship")
+	assert.Contains(t, printed, "ping_interval: 30s # WS_PING_INTERVAL")
+	assert.Contains(t, printed, "cors_origins: ['http://localhost:3000'] # CORS_ORIGINS")
+	assert.Contains(t, printed, "verify_keys: # JWT_VERIFY_KEYS\n    old: REDACTED\n")
+
+	// The output is a valid config file
+	path := filepath.Join(t.TempDir(), "battleship.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(printed), 0o600))
+	loaded, err := Load([]string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, cfg.WebSocket, loaded.WebSocket)
+	assert.Equal(t, cfg.Cleanup, loaded.Cleanup)
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the command line flags, each overriding the one before.
+// The config file is given by -config or CONFIG_FILE and may be YAML or
+// TOML. Every setting has a flag named after its key in the file, such as
+// -websocket.ping_interval=45s. Load reports values that cannot be parsed;
+// call Validate to check the result.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("battleship-go", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "config file (.yaml, .yml or .toml)")
+	byKey := make(map[string]setting)
+	values := make(map[string]*string)
+	for _, s := range settings {
+		if s.value.Kind() == reflect.Slice && s.value.Type().Elem().Kind() == reflect.Struct {
+			continue
+		}
+		usage := s.key
+		if s.env != "" {
+			usage = "overrides " + s.env
+		}
+		byKey[s.key] = s
+		values[s.key] = flags.String(s.key, "", usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := loadFile(cfg, *path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		value := os.Getenv(s.env)
+		if s.env == "" || value == "" {
+			continue
+		}
+		if err := parse(s.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+		}
+	}
+	if os.Getenv("OAUTH_PROVIDERS") != "" {
+		cfg.OAuth.Providers = loadOAuthProviders()
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		s, ok := byKey[f.Name]
+		if !ok {
+			return
+		}
+		if err := parse(s.value, *values[f.Name]); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for i, provider := range cfg.OAuth.Providers {
+		cfg.OAuth.Providers[i] = provider.withDefaults()
+	}
+	return cfg, nil
+}
+
+// setting is a single value of the configuration tree.
+type setting struct {
+	key   string // dotted path in the config file, e.g. websocket.ping_interval
+	env   string // environment variable, empty if none
+	value reflect.Value
+}
+
+// settings lists every value of the configuration, in file order.
+func (c *Config) settings() []setting {
+	var result []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			result = append(result, setting{
+				key:   key,
+				env:   field.Tag.Get("env"),
+				value: v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return result
+}
+
+// loadFile applies the settings of a YAML or TOML config file. Unknown keys
+// are errors, so typos do not go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("unsupported config file %s: use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return decodeStruct(reflect.ValueOf(cfg).Elem(), raw, "")
+}
+
+// decodeStruct sets the fields of v from a table of the config file.
+func decodeStruct(v reflect.Value, raw map[string]interface{}, prefix string) error {
+	fields := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("yaml")] = i
+	}
+
+	var errs []error
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		i, ok := fields[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %s%s", prefix, key))
+			continue
+		}
+		if err := decode(v.Field(i), raw[key], prefix+key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decode sets v from a value of the config file. Scalars are parsed like
+// environment variables, so durations are written as "30s" in both formats.
+func decode(v reflect.Value, raw interface{}, key string) error {
+	switch raw := raw.(type) {
+	case nil:
+		v.Set(reflect.Zero(v.Type()))
+	case map[string]interface{}:
+		switch {
+		case v.Kind() == reflect.Struct && v.Type() != durationType:
+			return decodeStruct(v, raw, key+".")
+		case v.Kind() == reflect.Map:
+			m := reflect.MakeMapWithSize(v.Type(), len(raw))
+			for k, item := range raw {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := decode(elem, item, key+"."+k); err != nil {
+					return err
+				}
+				m.SetMapIndex(reflect.ValueOf(k), elem)
+			}
+			v.Set(m)
+		default:
+			return fmt.Errorf("%s: expected a single value, got a table", key)
+		}
+	case []interface{}:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("%s: expected a single value, got a list", key)
+		}
+		slice := reflect.MakeSlice(v.Type(), len(raw), len(raw))
+		for i, item := range raw {
+			if err := decode(slice.Index(i), item, fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		if err := parse(v, fmt.Sprint(raw)); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parse sets v from its text form. Lists are comma separated, with "none"
+// for an empty list, and maps are comma separated key=value pairs.
+func parse(v reflect.Value, text string) error {
+	text = strings.TrimSpace(text)
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(text)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Struct:
+		var items []string
+		if text != "none" {
+			items = splitList(text)
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := parse(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case v.Kind() == reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range splitList(text) {
+			k, value, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(value))
+		}
+		v.Set(m)
+	default:
+		return errors.New("cannot be set from text")
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, skipping empty entries.
+func splitList(text string) []string {
+	var result []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := Load(nil)
+		require.NoError(t, err)
+		assert.Equal(t, Default(), cfg)
+	})
+
+	t.Run("file, environment and flags", func(t *testing.T) {
+		path := writeConfigFile(t, "battleship.yaml", `
+server:
+  port: 9090
+  cors_origins: [https://battleship.example.com]
+jwt:
+  token_ttl: 2h
+  verify_keys:
+    old: retired-secret
+websocket:
+  ping_interval: 20s
+  send_queue_size: 64
+cleanup:
+  warn_before: [5m]
+oauth:
+  providers:
+    - name: GitHub
+      client_id: gh-client
+`)
+		t.Setenv("WS_PING_INTERVAL", "25s")
+		t.Setenv("CHAT_STRIP_LINKS", "false")
+
+		cfg, err := Load([]string{"-config", path, "-websocket.ping_interval=15s", "-game.board_size", "12"})
+		require.NoError(t, err)
+		assert.Equal(t, "9090", cfg.Server.Port)
+		assert.Equal(t, []string{"https://battleship.example.com"}, cfg.Server.CORSOrigins)
+		assert.Equal(t, 2*time.Hour, cfg.JWT.TokenTTL)
+		assert.Equal(t, map[string]string{"old": "retired-secret"}, cfg.JWT.VerifyKeys)
+		assert.Equal(t, 15*time.Second, cfg.WebSocket.PingInterval)
+		assert.Equal(t, 64, cfg.WebSocket.SendQueueSize)
+		assert.Equal(t, []time.Duration{5 * time.Minute}, cfg.Cleanup.WarnBefore)
+		assert.False(t, cfg.Chat.StripLinks)
+		assert.Equal(t, 12, cfg.Game.BoardSize)
+
+		// Providers from the file get the same defaults as from the environment
+		require.Len(t, cfg.OAuth.Providers, 1)
+		assert.Equal(t, "github", cfg.OAuth.Providers[0].Name)
+		assert.Equal(t, "https://github.com/login/oauth/access_token", cfg.OAuth.Providers[0].TokenURL)
+	})
+
+	t.Run("toml file", func(t *testing.T) {
+		path := writeConfigFile(t, "battleship.toml", `
+environment = "production"
+
+[cleanup]
+interval = "5m"
+active_timeout = "30m"
+`)
+		t.Setenv("CONFIG_FILE", path)
+
+		cfg, err := Load(nil)
+		require.NoError(t, err)
+		assert.Equal(t, EnvProduction, cfg.Environment)
+		assert.Equal(t, 5*time.Minute, cfg.Cleanup.Interval)
+		assert.Equal(t, 30*time.Minute, cfg.Cleanup.ActiveTimeout)
+	})
+
+	t.Run("unknown settings and bad values", func(t *testing.T) {
+		path := writeConfigFile(t, "battleship.yaml", `
+websocket:
+  ping_intervall: 20s
+  pong_timeout: 60
+`)
+		_, err := Load([]string{"-config", path})
+		assert.ErrorContains(t, err, "unknown setting websocket.ping_intervall")
+		assert.ErrorContains(t, err, "websocket.pong_timeout: time: missing unit")
+
+		t.Setenv("CLEANUP_WARN_BEFORE", "10m,soon")
+		_, err = Load(nil)
+		assert.ErrorContains(t, err, "CLEANUP_WARN_BEFORE")
+
+		_, err = Load([]string{"-websocket.send_queue_size=many"})
+		assert.ErrorContains(t, err, "-websocket.send_queue_size")
+	})
+
+	t.Run("lists and maps from text", func(t *testing.T) {
+		t.Setenv("CLEANUP_WARN_BEFORE", "none")
+		t.Setenv("JWT_VERIFY_KEYS", "a=one, b=two")
+		cfg, err := Load(nil)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Cleanup.WarnBefore)
+		assert.Equal(t, map[string]string{"a": "one", "b": "two"}, cfg.JWT.VerifyKeys)
+
+		t.Setenv("JWT_VERIFY_KEYS", "a=one,invalid")
+		_, err = Load(nil)
+		assert.ErrorContains(t, err, `expected key=value, got "invalid"`)
+	})
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in printed configurations.
+const redacted = "REDACTED"
+
+// Print writes the configuration as a YAML config file with secrets
+// redacted. Each setting is followed by its environment variable.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(encodeStruct(reflect.ValueOf(c).Elem(), false)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func encodeStruct(v reflect.Value, secret bool) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := scalar("!!str", field.Tag.Get("yaml"))
+		value := encode(v.Field(i), secret || field.Tag.Get("secret") == "true")
+		if env := field.Tag.Get("env"); env != "" {
+			// Comments on a block mapping's value end up inside it
+			if value.Kind == yaml.MappingNode && len(value.Content) > 0 {
+				key.LineComment = env
+			} else {
+				value.LineComment = env
+			}
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node
+}
+
+func encode(v reflect.Value, secret bool) *yaml.Node {
+	switch {
+	case v.Type() == durationType:
+		return scalar("!!str", time.Duration(v.Int()).String())
+	case v.Kind() == reflect.Struct:
+		return encodeStruct(v, secret)
+	case v.Kind() == reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		if v.Type().Elem().Kind() != reflect.Struct {
+			node.Style = yaml.FlowStyle
+		}
+		for i := 0; i < v.Len(); i++ {
+			node.Content = append(node.Content, encode(v.Index(i), secret))
+		}
+		return node
+	case v.Kind() == reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		if v.Len() == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			node.Content = append(node.Content, scalar("!!str", key), encode(v.MapIndex(reflect.ValueOf(key)), secret))
+		}
+		return node
+	case v.Kind() == reflect.Bool:
+		return scalar("!!bool", strconv.FormatBool(v.Bool()))
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		return scalar("!!int", strconv.FormatInt(v.Int(), 10))
+	case secret:
+		return scalar("!!str", redact(v.String()))
+	default:
+		return scalar("!!str", fmt.Sprint(v.Interface()))
+	}
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// redact hides a secret. URLs keep everything but their password, so the
+// database host stays visible.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if u, err := url.Parse(secret); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+		return secret
+	}
+	return redacted
+}
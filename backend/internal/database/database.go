@@ -29,6 +29,36 @@ func RunMigrations(db *sql.DB) error {
 		createChatMessagesTable,
 		createScoresTable,
 		createIndexes,
+		addUserRoleColumns,
+		createAdminAuditLogTable,
+		addUserEmailVerificationColumns,
+		createAuthTokensTable,
+		createUserIdentitiesTable,
+		createOAuthStatesTable,
+		addUserTOTPColumns,
+		createRecoveryCodesTable,
+		createRateLimitBucketsTable,
+		addUserLockoutColumns,
+		addUserGuestColumn,
+		addUserDeletedAtColumn,
+		createFriendshipsTable,
+		createUserBlocksTable,
+		addGameInvitedPlayerColumn,
+		addUserMuteColumns,
+		addChatMessageDeletedColumns,
+		createChatReportsTable,
+		createChatChannelsTable,
+		createChatReadMarkersTable,
+		addGameChatModeColumn,
+		createQuickChatEventsTable,
+		createNotificationsTable,
+		createGameEventsTable,
+		addGameArchivedColumn,
+		createCleanupRunsTable,
+		addGameInactivityWarningColumns,
+		addOAuthStateLinkUserColumn,
+		addGameBoardSizeColumn,
+		addCleanupRunAnonymizedGuestsColumn,
 	}
 
 	for _, migration := range migrations {
@@ -123,3 +153,258 @@ DO $$ BEGIN
         ALTER TABLE moves ADD CONSTRAINT moves_game_player_position_key UNIQUE (game_id, player_id, x, y);
     END IF;
 END $$;`
+
+const addUserRoleColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'player';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS banned_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ban_reason TEXT;
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);`
+
+const createAdminAuditLogTable = `
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id SERIAL PRIMARY KEY,
+    actor_id INTEGER NOT NULL REFERENCES users(id),
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id INTEGER,
+    details TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created_at ON admin_audit_log(created_at DESC);`
+
+const addUserEmailVerificationColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;`
+
+const createAuthTokensTable = `
+CREATE TABLE IF NOT EXISTS auth_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    purpose VARCHAR(30) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user_purpose ON auth_tokens(user_id, purpose);`
+
+const createUserIdentitiesTable = `
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);`
+
+const createOAuthStatesTable = `
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state VARCHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+const addUserTOTPColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;`
+
+const createRecoveryCodesTable = `
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);`
+
+const createRateLimitBucketsTable = `
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed SMALLINT NOT NULL,
+    refilled_at DOUBLE PRECISION NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_refilled ON rate_limit_buckets(refilled_at);`
+
+const addUserLockoutColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;`
+
+const addUserGuestColumn = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_guest BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_users_guest ON users(created_at) WHERE is_guest;`
+
+const addUserDeletedAtColumn = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;`
+
+const createFriendshipsTable = `
+CREATE TABLE IF NOT EXISTS friendships (
+    id SERIAL PRIMARY KEY,
+    requester_id INTEGER NOT NULL REFERENCES users(id),
+    addressee_id INTEGER NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMP,
+    UNIQUE (requester_id, addressee_id)
+);
+CREATE INDEX IF NOT EXISTS idx_friendships_addressee ON friendships(addressee_id);`
+
+const createUserBlocksTable = `
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id INTEGER NOT NULL REFERENCES users(id),
+    blocked_id INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked ON user_blocks(blocked_id);`
+
+const addGameInvitedPlayerColumn = `
+ALTER TABLE games ADD COLUMN IF NOT EXISTS invited_player_id INTEGER REFERENCES users(id);`
+
+const addUserMuteColumns = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mute_reason TEXT;`
+
+const addChatMessageDeletedColumns = `
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id);`
+
+const createChatReportsTable = `
+CREATE TABLE IF NOT EXISTS chat_reports (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES chat_messages(id),
+    reporter_id INTEGER NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    resolved_by INTEGER REFERENCES users(id),
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (message_id, reporter_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_reports_status ON chat_reports(status, created_at);`
+
+// createChatChannelsTable generalizes chat from games to channels and moves
+// existing game chat into one channel per game.
+const createChatChannelsTable = `
+CREATE TABLE IF NOT EXISTS chat_channels (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(10) NOT NULL,
+    channel_key VARCHAR(64) UNIQUE NOT NULL,
+    game_id INTEGER REFERENCES games(id),
+    user1_id INTEGER REFERENCES users(id),
+    user2_id INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO chat_channels (type, channel_key) VALUES ('lobby', 'lobby') ON CONFLICT (channel_key) DO NOTHING;
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS channel_id INTEGER REFERENCES chat_channels(id);
+ALTER TABLE chat_messages ALTER COLUMN game_id DROP NOT NULL;
+INSERT INTO chat_channels (type, channel_key, game_id)
+SELECT DISTINCT 'game', 'game:' || game_id, game_id FROM chat_messages WHERE channel_id IS NULL AND game_id IS NOT NULL
+ON CONFLICT (channel_key) DO NOTHING;
+UPDATE chat_messages SET channel_id = c.id FROM chat_channels c
+WHERE chat_messages.channel_id IS NULL AND c.channel_key = 'game:' || chat_messages.game_id;
+ALTER TABLE chat_messages ALTER COLUMN channel_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_chat_channel ON chat_messages(channel_id, id);
+CREATE INDEX IF NOT EXISTS idx_chat_channels_users ON chat_channels(user1_id, user2_id);`
+
+const createChatReadMarkersTable = `
+CREATE TABLE IF NOT EXISTS chat_read_markers (
+    channel_id INTEGER NOT NULL REFERENCES chat_channels(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (channel_id, user_id)
+);`
+
+// addGameArchivedColumn marks finished and cancelled games the cleanup
+// archived. Archived games are kept for history and scores but no longer listed.
+const addGameArchivedColumn = `
+ALTER TABLE games ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_games_unarchived ON games(status, updated_at) WHERE archived_at IS NULL;`
+
+// createCleanupRunsTable records every cleanup run. triggered_by is the admin
+// who started it, NULL for the scheduler.
+const createCleanupRunsTable = `
+CREATE TABLE IF NOT EXISTS cleanup_runs (
+    id SERIAL PRIMARY KEY,
+    triggered_by INTEGER REFERENCES users(id),
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    instance VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    forfeited_games INTEGER NOT NULL DEFAULT 0,
+    cancelled_games INTEGER NOT NULL DEFAULT 0,
+    archived_games INTEGER NOT NULL DEFAULT 0,
+    purged_guests INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_cleanup_runs_started ON cleanup_runs(started_at);`
+
+// addGameInactivityWarningColumns remember the last inactivity warning sent
+// for a game: when, and how long before the forfeit in seconds.
+const addGameInactivityWarningColumns = `
+ALTER TABLE games ADD COLUMN IF NOT EXISTS inactivity_warned_at TIMESTAMP;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS inactivity_warning INTEGER;`
+
+// addOAuthStateLinkUserColumn marks logins started by a signed-in user to
+// link the provider to their account.
+const addOAuthStateLinkUserColumn = `
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS link_user_id INTEGER REFERENCES users(id);`
+
+// addGameBoardSizeColumn stores the board size each game was created with,
+// so changing GAME_BOARD_SIZE leaves games in progress alone. Earlier games
+// were played on 10x10.
+const addGameBoardSizeColumn = `
+ALTER TABLE games ADD COLUMN IF NOT EXISTS board_size INTEGER NOT NULL DEFAULT 10;`
+
+// addCleanupRunAnonymizedGuestsColumn counts the expired guests a cleanup run
+// anonymized because they played.
+const addCleanupRunAnonymizedGuestsColumn = `
+ALTER TABLE cleanup_runs ADD COLUMN IF NOT EXISTS anonymized_guests INTEGER NOT NULL DEFAULT 0;`
+
+const addGameChatModeColumn = `
+ALTER TABLE games ADD COLUMN IF NOT EXISTS chat_mode VARCHAR(10) NOT NULL DEFAULT 'free';`
+
+const createQuickChatEventsTable = `
+CREATE TABLE IF NOT EXISTS quick_chat_events (
+    id SERIAL PRIMARY KEY,
+    game_id INTEGER NOT NULL REFERENCES games(id),
+    player_id INTEGER NOT NULL REFERENCES users(id),
+    kind VARCHAR(10) NOT NULL,
+    code VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_quick_chat_game ON quick_chat_events(game_id, id);`
+
+const createNotificationsTable = `
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    type VARCHAR(40) NOT NULL,
+    data TEXT,
+    delivered_at TIMESTAMP,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id);`
+
+const createGameEventsTable = `
+CREATE TABLE IF NOT EXISTS game_events (
+    id SERIAL PRIMARY KEY,
+    game_id INTEGER NOT NULL REFERENCES games(id),
+    seq BIGINT NOT NULL,
+    type VARCHAR(40) NOT NULL,
+    channel_id INTEGER,
+    sender_id INTEGER,
+    data TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (game_id, seq)
+);`
@@ -0,0 +1,289 @@
+// Package events keeps the per-game event log behind WebSocket resume: every
+// message broadcast to a game gets the next sequence number of that game and
+// is stored, so clients that reconnect can be sent what they missed.
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"battleship-go/internal/protocol"
+)
+
+// ErrResyncRequired is returned by Since when the missed events cannot be
+// replayed and the client must reload the game instead.
+var ErrResyncRequired = errors.New("events cannot be replayed")
+
+// Defaults for NewLog
+const (
+	DefaultBufferSize = 128
+	DefaultMaxReplay  = 128
+	maxCachedGames    = 1000
+)
+
+// Log assigns sequence numbers to game events and replays them. Recent events
+// of each game are served from memory, older ones from the database.
+type Log struct {
+	db         *sql.DB
+	bufferSize int
+	maxReplay  int
+
+	mu    sync.Mutex
+	games map[int]*gameLog
+}
+
+// gameLog is the state of one game. Its lock orders appends so sequence
+// numbers are assigned without gaps and duplicates.
+type gameLog struct {
+	mu      sync.Mutex
+	loaded  bool
+	lastSeq int64
+	buffer  []*protocol.Envelope // the most recent events, oldest first
+	used    time.Time            // guarded by Log.mu
+	evicted bool
+}
+
+// NewLog creates an event log keeping bufferSize events per game in memory
+// and replaying at most maxReplay events to a client.
+func NewLog(db *sql.DB, bufferSize, maxReplay int) *Log {
+	return &Log{
+		db:         db,
+		bufferSize: bufferSize,
+		maxReplay:  maxReplay,
+		games:      make(map[int]*gameLog),
+	}
+}
+
+// game returns the state of a game, locked. When too many games are kept, the
+// least recently used one that is not busy is evicted.
+func (l *Log) game(gameID int) *gameLog {
+	for {
+		l.mu.Lock()
+		g, ok := l.games[gameID]
+		if !ok {
+			g = &gameLog{}
+			l.games[gameID] = g
+		}
+		g.used = time.Now()
+		if len(l.games) > maxCachedGames {
+			l.evictLocked(g)
+		}
+		l.mu.Unlock()
+
+		g.mu.Lock()
+		if !g.evicted {
+			return g
+		}
+		// Evicted between the lookup and the lock; look it up again
+		g.mu.Unlock()
+	}
+}
+
+func (l *Log) evictLocked(keep *gameLog) {
+	var oldestID int
+	var oldest *gameLog
+	for id, g := range l.games {
+		if g != keep && (oldest == nil || g.used.Before(oldest.used)) {
+			oldestID, oldest = id, g
+		}
+	}
+	if oldest != nil && oldest.mu.TryLock() {
+		oldest.evicted = true
+		delete(l.games, oldestID)
+		oldest.mu.Unlock()
+	}
+}
+
+// load reads the last sequence number of a game the first time it is used.
+func (l *Log) load(gameID int, g *gameLog) error {
+	if g.loaded {
+		return nil
+	}
+	err := l.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM game_events WHERE game_id = $1", gameID).Scan(&g.lastSeq)
+	if err != nil {
+		return err
+	}
+	g.loaded = true
+	return nil
+}
+
+// Append stores an event of a game and sets its Seq.
+func (l *Log) Append(gameID int, envelope *protocol.Envelope) error {
+	g := l.game(gameID)
+	defer g.mu.Unlock()
+
+	if err := l.load(gameID, g); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(envelope.Data)
+	if err != nil {
+		return err
+	}
+
+	seq := g.lastSeq + 1
+	_, err = l.db.Exec(`
+		INSERT INTO game_events (game_id, seq, type, channel_id, sender_id, data)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		gameID, seq, envelope.Type, nullInt(envelope.ChannelID), nullInt(envelope.Sender), string(data))
+	if err != nil {
+		return err
+	}
+
+	envelope.Seq = seq
+	g.lastSeq = seq
+	g.buffer = append(g.buffer, envelope)
+	if len(g.buffer) > l.bufferSize {
+		g.buffer = g.buffer[len(g.buffer)-l.bufferSize:]
+	}
+	return nil
+}
+
+// LastSeq returns the sequence number of a game's latest event.
+func (l *Log) LastSeq(gameID int) (int64, error) {
+	g := l.game(gameID)
+	defer g.mu.Unlock()
+
+	if err := l.load(gameID, g); err != nil {
+		return 0, err
+	}
+	return g.lastSeq, nil
+}
+
+// Since returns a game's events after afterSeq, oldest first. It returns
+// ErrResyncRequired when more than the replay limit were missed or afterSeq
+// is ahead of the log, e.g. a sequence number from another game.
+func (l *Log) Since(gameID int, afterSeq int64) ([]*protocol.Envelope, error) {
+	g := l.game(gameID)
+	defer g.mu.Unlock()
+
+	if err := l.load(gameID, g); err != nil {
+		return nil, err
+	}
+	switch {
+	case afterSeq > g.lastSeq || afterSeq < 0:
+		return nil, ErrResyncRequired
+	case afterSeq == g.lastSeq:
+		return nil, nil
+	case g.lastSeq-afterSeq > int64(l.maxReplay):
+		return nil, ErrResyncRequired
+	}
+
+	if len(g.buffer) > 0 && g.buffer[0].Seq <= afterSeq+1 {
+		start := int(afterSeq + 1 - g.buffer[0].Seq)
+		return append([]*protocol.Envelope(nil), g.buffer[start:]...), nil
+	}
+	return l.stored(gameID, afterSeq)
+}
+
+// Forget drops the events of games kept in memory, so replays read them from
+// the database again, e.g. after RedactChat.
+func (l *Log) Forget(gameIDs ...int) {
+	for _, gameID := range gameIDs {
+		l.mu.Lock()
+		g := l.games[gameID]
+		l.mu.Unlock()
+		if g == nil {
+			continue
+		}
+		g.mu.Lock()
+		g.buffer = nil
+		g.mu.Unlock()
+	}
+}
+
+// RedactChat replaces the text of the game chat messages userID sent with
+// text in the stored events, within tx, and returns the games affected.
+func RedactChat(tx *sql.Tx, userID int, text string) ([]int, error) {
+	type storedChat struct {
+		id, gameID int
+		data       string
+	}
+	rows, err := tx.Query(`
+		SELECT id, game_id, data FROM game_events WHERE sender_id = $1 AND type = $2`,
+		userID, protocol.TypeChat)
+	if err != nil {
+		return nil, err
+	}
+	var chats []storedChat
+	for rows.Next() {
+		var chat storedChat
+		var data sql.NullString
+		if err := rows.Scan(&chat.id, &chat.gameID, &data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chat.data = data.String
+		chats = append(chats, chat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var gameIDs []int
+	seen := make(map[int]bool)
+	for _, chat := range chats {
+		var payload protocol.GameChat
+		if err := json.Unmarshal([]byte(chat.data), &payload); err != nil {
+			return nil, err
+		}
+		payload.Message = text
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE game_events SET data = $1 WHERE id = $2", string(data), chat.id); err != nil {
+			return nil, err
+		}
+		if !seen[chat.gameID] {
+			seen[chat.gameID] = true
+			gameIDs = append(gameIDs, chat.gameID)
+		}
+	}
+	return gameIDs, nil
+}
+
+// stored reads events from the database.
+func (l *Log) stored(gameID int, afterSeq int64) ([]*protocol.Envelope, error) {
+	rows, err := l.db.Query(`
+		SELECT seq, type, channel_id, sender_id, data FROM game_events
+		WHERE game_id = $1 AND seq > $2
+		ORDER BY seq`, gameID, afterSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envelopes []*protocol.Envelope
+	for rows.Next() {
+		var seq int64
+		var msgType string
+		var channelID, senderID sql.NullInt64
+		var data sql.NullString
+		if err := rows.Scan(&seq, &msgType, &channelID, &senderID, &data); err != nil {
+			return nil, err
+		}
+
+		payload, err := protocol.DecodePayload(protocol.MessageType(msgType), []byte(data.String))
+		if err != nil {
+			return nil, err
+		}
+		envelope := protocol.New(payload).ForGame(gameID)
+		envelope.Seq = seq
+		envelope.ChannelID = int(channelID.Int64)
+		envelope.Sender = int(senderID.Int64)
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, rows.Err()
+}
+
+func nullInt(value int) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"database/sql"
+	"testing"
+
+	"battleship-go/internal/models"
+	"battleship-go/internal/protocol"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE game_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			channel_id INTEGER,
+			sender_id INTEGER,
+			data TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (game_id, seq)
+		);
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func moveEvent(x int) *protocol.Envelope {
+	return protocol.New(protocol.GameUpdate{Reason: protocol.ReasonMove, Move: &models.Move{X: x}}).ForGame(1)
+}
+
+func TestLog_Append(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	log := NewLog(db, 2, 10)
+
+	for x := 0; x < 3; x++ {
+		event := moveEvent(x)
+		require.NoError(t, log.Append(1, event))
+		assert.EqualValues(t, x+1, event.Seq)
+	}
+
+	// Every game counts on its own
+	other := protocol.New(protocol.ShipPlacementUpdate{UserID: 2}).ForGame(2)
+	require.NoError(t, log.Append(2, other))
+	assert.EqualValues(t, 1, other.Seq)
+
+	// Numbering continues after a restart
+	restarted := NewLog(db, 2, 10)
+	event := moveEvent(3)
+	require.NoError(t, restarted.Append(1, event))
+	assert.EqualValues(t, 4, event.Seq)
+
+	last, err := restarted.LastSeq(1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, last)
+}
+
+func TestLog_Since(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	log := NewLog(db, 2, 3)
+	for x := 0; x < 4; x++ {
+		require.NoError(t, log.Append(1, moveEvent(x)))
+	}
+	chat := protocol.New(protocol.GameChat{ChatMessage: models.ChatMessage{ID: 9, Message: "hi"}}).ForGame(1)
+	chat.ChannelID = 7
+	chat.Sender = 2
+	require.NoError(t, log.Append(1, chat))
+
+	t.Run("up to date", func(t *testing.T) {
+		missed, err := log.Since(1, 5)
+		require.NoError(t, err)
+		assert.Empty(t, missed)
+	})
+
+	t.Run("from the buffer", func(t *testing.T) {
+		missed, err := log.Since(1, 4)
+		require.NoError(t, err)
+		require.Len(t, missed, 1)
+		assert.Same(t, chat, missed[0])
+	})
+
+	t.Run("from the database", func(t *testing.T) {
+		missed, err := log.Since(1, 2)
+		require.NoError(t, err)
+		require.Len(t, missed, 3)
+		assert.EqualValues(t, []int64{3, 4, 5}, []int64{missed[0].Seq, missed[1].Seq, missed[2].Seq})
+
+		assert.Equal(t, protocol.TypeGameUpdate, missed[0].Type)
+		assert.Equal(t, 2, missed[0].Data.(protocol.GameUpdate).Move.X)
+		assert.Equal(t, 1, missed[0].GameID)
+
+		assert.Equal(t, 7, missed[2].ChannelID)
+		assert.Equal(t, 2, missed[2].Sender)
+		assert.Equal(t, "hi", missed[2].Data.(protocol.GameChat).Message)
+	})
+
+	t.Run("too far behind", func(t *testing.T) {
+		_, err := log.Since(1, 1)
+		assert.ErrorIs(t, err, ErrResyncRequired)
+	})
+
+	t.Run("ahead of the log", func(t *testing.T) {
+		_, err := log.Since(1, 6)
+		assert.ErrorIs(t, err, ErrResyncRequired)
+	})
+}
@@ -4,29 +4,95 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"battleship-go/internal/models"
 )
 
+// DefaultBoardSize is the width and height of the board unless
+// SetBoardSize is called. Games keep the size they were created with.
+const DefaultBoardSize = 10
+
 type GameService struct {
-	db *sql.DB
+	db        *sql.DB
+	boardSize int
 }
 
 func NewGameService(db *sql.DB) *GameService {
-	return &GameService{db: db}
+	return &GameService{db: db, boardSize: DefaultBoardSize}
+}
+
+// SetBoardSize sets the width and height of the board of new games.
+func (g *GameService) SetBoardSize(size int) {
+	g.boardSize = size
+}
+
+func onBoard(boardSize, x, y int) bool {
+	return x >= 0 && x < boardSize && y >= 0 && y < boardSize
 }
 
-func (g *GameService) CreateGame(playerID int) (*models.Game, error) {
+func (g *GameService) CreateGame(playerID int, chatMode string) (*models.Game, error) {
+	if chatMode == "" {
+		chatMode = models.ChatModeFree
+	}
+	if chatMode != models.ChatModeFree && chatMode != models.ChatModeQuick {
+		return nil, errors.New("invalid chat mode")
+	}
+
 	var game models.Game
 	err := g.db.QueryRow(`
-		INSERT INTO games (player1_id, status) 
-		VALUES ($1, $2) 
-		RETURNING id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at`,
-		playerID, models.GameStatusWaiting).Scan(
-		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.CreatedAt, &game.UpdatedAt)
+		INSERT INTO games (player1_id, status, chat_mode, board_size) 
+		VALUES ($1, $2, $3, $4) 
+		RETURNING id, player1_id, player2_id, status, current_turn, winner_id, chat_mode, board_size, created_at, updated_at`,
+		playerID, models.GameStatusWaiting, chatMode, g.boardSize).Scan(
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.ChatMode, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+// CreateInviteGame creates a waiting game that only invitedID may join.
+func (g *GameService) CreateInviteGame(playerID, invitedID int) (*models.Game, error) {
+	if playerID == invitedID {
+		return nil, errors.New("cannot invite yourself")
+	}
+
+	var game models.Game
+	err := g.db.QueryRow(`
+		INSERT INTO games (player1_id, status, invited_player_id, board_size) 
+		VALUES ($1, $2, $3, $4) 
+		RETURNING id, player1_id, player2_id, status, current_turn, winner_id, invited_player_id, board_size, created_at, updated_at`,
+		playerID, models.GameStatusWaiting, invitedID, g.boardSize).Scan(
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.InvitedPlayerID, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+// DeclineInvite lets the invited player turn down an invite game, which
+// removes it. It returns the declined game.
+func (g *GameService) DeclineInvite(gameID, playerID int) (*models.Game, error) {
+	var game models.Game
+	err := g.db.QueryRow(`
+		SELECT id, player1_id, status, invited_player_id 
+		FROM games WHERE id = $1`, gameID).Scan(
+		&game.ID, &game.Player1ID, &game.Status, &game.InvitedPlayerID)
 	if err != nil {
 		return nil, err
 	}
+
+	if game.InvitedPlayerID == nil || *game.InvitedPlayerID != playerID {
+		return nil, errors.New("you were not invited to this game")
+	}
+	if game.Status != models.GameStatusWaiting {
+		return nil, errors.New("invite has already been accepted")
+	}
+
+	if err := g.DeleteGame(gameID); err != nil {
+		return nil, err
+	}
 	return &game, nil
 }
 
@@ -34,9 +100,9 @@ func (g *GameService) JoinGame(gameID, playerID int) (*models.Game, error) {
 	// Check if game exists and is waiting for players
 	var game models.Game
 	err := g.db.QueryRow(`
-		SELECT id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at 
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, invited_player_id, board_size, created_at, updated_at 
 		FROM games WHERE id = $1`, gameID).Scan(
-		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.CreatedAt, &game.UpdatedAt)
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.InvitedPlayerID, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -49,13 +115,17 @@ func (g *GameService) JoinGame(gameID, playerID int) (*models.Game, error) {
 		return nil, errors.New("cannot join your own game")
 	}
 
+	if game.InvitedPlayerID != nil && *game.InvitedPlayerID != playerID {
+		return nil, errors.New("game is reserved for an invited player")
+	}
+
 	// Update game with second player
 	err = g.db.QueryRow(`
 		UPDATE games SET player2_id = $1, status = $2, current_turn = $3, updated_at = CURRENT_TIMESTAMP 
 		WHERE id = $4 
-		RETURNING id, player1_id, player2_id, status, current_turn, winner_id, created_at, updated_at`,
+		RETURNING id, player1_id, player2_id, status, current_turn, winner_id, invited_player_id, board_size, created_at, updated_at`,
 		playerID, models.GameStatusActive, game.Player1ID, gameID).Scan(
-		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.CreatedAt, &game.UpdatedAt)
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.InvitedPlayerID, &game.BoardSize, &game.CreatedAt, &game.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -64,14 +134,23 @@ func (g *GameService) JoinGame(gameID, playerID int) (*models.Game, error) {
 }
 
 func (g *GameService) PlaceShips(gameID, playerID int, ships []models.Ship) error {
+	var boardSize int
+	err := g.db.QueryRow("SELECT board_size FROM games WHERE id = $1", gameID).Scan(&boardSize)
+	if err == sql.ErrNoRows {
+		return errors.New("game not found")
+	}
+	if err != nil {
+		return err
+	}
+
 	// Validate ship placement
-	if err := g.validateShipPlacement(ships); err != nil {
+	if err := validateShipPlacement(ships, boardSize); err != nil {
 		return err
 	}
 
 	// Check if ships are already placed for this player
 	var count int
-	err := g.db.QueryRow("SELECT COUNT(*) FROM ships WHERE game_id = $1 AND player_id = $2", gameID, playerID).Scan(&count)
+	err = g.db.QueryRow("SELECT COUNT(*) FROM ships WHERE game_id = $1 AND player_id = $2", gameID, playerID).Scan(&count)
 	if err != nil {
 		return err
 	}
@@ -132,16 +211,32 @@ func (g *GameService) checkAndStartGame(gameID int) error {
 }
 
 func (g *GameService) MakeMove(gameID, playerID, x, y int) (*models.Move, error) {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Writing the game row first locks it, so the move is ordered against
+	// other moves and forfeits of the game and sees their outcome
+	if _, err := tx.Exec("UPDATE games SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", gameID); err != nil {
+		return nil, err
+	}
+
 	// Check if it's the player's turn
 	var game models.Game
-	err := g.db.QueryRow(`
-		SELECT id, player1_id, player2_id, status, current_turn, winner_id 
+	err = tx.QueryRow(`
+		SELECT id, player1_id, player2_id, status, current_turn, winner_id, board_size 
 		FROM games WHERE id = $1`, gameID).Scan(
-		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID)
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status, &game.CurrentTurn, &game.WinnerID, &game.BoardSize)
 	if err != nil {
 		return nil, err
 	}
 
+	if !onBoard(game.BoardSize, x, y) {
+		return nil, errors.New("position out of bounds")
+	}
+
 	if game.Status != models.GameStatusActive {
 		return nil, errors.New("game is not active")
 	}
@@ -152,7 +247,7 @@ func (g *GameService) MakeMove(gameID, playerID, x, y int) (*models.Move, error)
 
 	// Check if move already exists by this player
 	var existingMoveCount int
-	err = g.db.QueryRow("SELECT COUNT(*) FROM moves WHERE game_id = $1 AND player_id = $2 AND x = $3 AND y = $4", gameID, playerID, x, y).Scan(&existingMoveCount)
+	err = tx.QueryRow("SELECT COUNT(*) FROM moves WHERE game_id = $1 AND player_id = $2 AND x = $3 AND y = $4", gameID, playerID, x, y).Scan(&existingMoveCount)
 	if err != nil {
 		return nil, err
 	}
@@ -169,7 +264,7 @@ func (g *GameService) MakeMove(gameID, playerID, x, y int) (*models.Move, error)
 	// Check for hit
 	var shipID *int
 	var isHit bool
-	err = g.db.QueryRow(`
+	err = tx.QueryRow(`
 		SELECT id FROM ships 
 		WHERE game_id = $1 AND player_id = $2 
 		AND ((is_vertical = true AND start_x = $3 AND $4 BETWEEN start_y AND end_y) 
@@ -182,7 +277,7 @@ func (g *GameService) MakeMove(gameID, playerID, x, y int) (*models.Move, error)
 
 	// Insert move
 	var move models.Move
-	err = g.db.QueryRow(`
+	err = tx.QueryRow(`
 		INSERT INTO moves (game_id, player_id, x, y, is_hit, ship_id) 
 		VALUES ($1, $2, $3, $4, $5, $6) 
 		RETURNING id, game_id, player_id, x, y, is_hit, ship_id, created_at`,
@@ -194,25 +289,218 @@ func (g *GameService) MakeMove(gameID, playerID, x, y int) (*models.Move, error)
 
 	// Check if ship is sunk
 	if isHit && shipID != nil {
-		g.checkAndUpdateSunkShip(gameID, *shipID)
+		if err := checkAndUpdateSunkShip(tx, gameID, *shipID); err != nil {
+			return nil, err
+		}
 	}
 
 	// Check for game end
-	if g.checkGameEnd(gameID, opponentID) {
-		g.endGame(gameID, playerID)
+	ended, err := checkGameEnd(tx, gameID, opponentID)
+	if err != nil {
+		return nil, err
+	}
+	if ended {
+		if err := finishActiveGame(tx, game, playerID); err != nil {
+			return nil, err
+		}
 	} else {
 		// Switch turns
 		nextPlayer := opponentID
 		if isHit {
 			nextPlayer = playerID // Player gets another turn on hit
 		}
-		g.db.Exec("UPDATE games SET current_turn = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", nextPlayer, gameID)
+		result, err := tx.Exec(`
+			UPDATE games SET current_turn = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND status = $3`, nextPlayer, gameID, models.GameStatusActive)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n != 1 {
+			return nil, ErrGameOver
+		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return &move, nil
 }
 
-func (g *GameService) validateShipPlacement(ships []models.Ship) error {
+// ErrGameOver is returned when a game ended before it could be finished,
+// for example by a concurrent forfeit or the last move.
+var ErrGameOver = errors.New("game is already over")
+
+// FinishGame ends an unfinished game immediately. With a winner the game is
+// scored like a normal win; without one it is closed without changing scores.
+func (g *GameService) FinishGame(gameID int, winnerID *int) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := g.FinishGameTx(tx, gameID, winnerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishGameTx is FinishGame within a transaction, so callers can commit the
+// game's end together with their own changes.
+func (g *GameService) FinishGameTx(tx *sql.Tx, gameID int, winnerID *int) error {
+	var game models.Game
+	err := tx.QueryRow(`
+		SELECT id, player1_id, player2_id, status 
+		FROM games WHERE id = $1`, gameID).Scan(
+		&game.ID, &game.Player1ID, &game.Player2ID, &game.Status)
+	if err != nil {
+		return err
+	}
+
+	if game.Status == models.GameStatusFinished {
+		return errors.New("game is already finished")
+	}
+	if game.Status == models.GameStatusCancelled {
+		return errors.New("game was cancelled")
+	}
+
+	if winnerID == nil {
+		result, err := tx.Exec(`
+			UPDATE games SET status = $1, current_turn = NULL, updated_at = CURRENT_TIMESTAMP 
+			WHERE id = $2 AND status IN ($3, $4)`,
+			models.GameStatusFinished, gameID, models.GameStatusWaiting, models.GameStatusActive)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n != 1 {
+			return ErrGameOver
+		}
+		return nil
+	}
+
+	if game.Player2ID == nil {
+		return errors.New("game has no opponent")
+	}
+	if *winnerID != game.Player1ID && *winnerID != *game.Player2ID {
+		return errors.New("winner must be a player in the game")
+	}
+
+	return finishActiveGame(tx, game, *winnerID)
+}
+
+// ErrGameNotIdle is returned by FinishIdleGame when the game had activity
+// after all.
+var ErrGameNotIdle = errors.New("game is no longer idle")
+
+// FinishIdleGame is FinishGame for an active game found without activity
+// since idleSince on currentTurn's turn. The game is only finished if that
+// still holds, so a move made in the meantime is never forfeited.
+func (g *GameService) FinishIdleGame(gameID int, winnerID, currentTurn *int, idleSince time.Time) error {
+	turn := 0
+	if currentTurn != nil {
+		turn = *currentTurn
+	}
+
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A move writes the game row, so a concurrent one either commits first
+	// and fails the check or waits for the forfeit and finds the game over
+	result, err := tx.Exec(`
+		UPDATE games SET updated_at = updated_at
+		WHERE id = $1 AND status = $2 AND updated_at < $3 AND COALESCE(current_turn, 0) = $4
+		  AND NOT EXISTS (SELECT 1 FROM moves m WHERE m.game_id = $1 AND m.created_at >= $3)`,
+		gameID, models.GameStatusActive, idleSince, turn)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return ErrGameNotIdle
+	}
+
+	if err := g.FinishGameTx(tx, gameID, winnerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelGame closes a game that is still waiting for an opponent. Unlike an
+// invite being declined, the game is kept.
+func (g *GameService) CancelGame(gameID int) error {
+	result, err := g.db.Exec(`
+		UPDATE games SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3`, models.GameStatusCancelled, gameID, models.GameStatusWaiting)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("game is not waiting for an opponent")
+	}
+	return nil
+}
+
+// MarkActive records that a player of an active game is still there, which
+// resets the game's inactivity timer like a move does.
+func (g *GameService) MarkActive(gameID, playerID int) error {
+	result, err := g.db.Exec(`
+		UPDATE games SET updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $2 AND (player1_id = $3 OR player2_id = $3)`,
+		gameID, models.GameStatusActive, playerID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("not an active game you play in")
+	}
+	return nil
+}
+
+// DeleteGame removes a game and all of its moves, chat messages, events and ships.
+func (g *GameService) DeleteGame(gameID int) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := g.DeleteGameTx(tx, gameID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteGameTx is DeleteGame within a transaction.
+func (g *GameService) DeleteGameTx(tx *sql.Tx, gameID int) error {
+	// Delete in the correct order to respect foreign key constraints
+	for _, query := range []string{
+		"DELETE FROM moves WHERE game_id = $1",
+		"DELETE FROM chat_reports WHERE message_id IN (SELECT id FROM chat_messages WHERE game_id = $1)",
+		"DELETE FROM chat_messages WHERE game_id = $1",
+		"DELETE FROM chat_read_markers WHERE channel_id IN (SELECT id FROM chat_channels WHERE game_id = $1)",
+		"DELETE FROM chat_channels WHERE game_id = $1",
+		"DELETE FROM quick_chat_events WHERE game_id = $1",
+		"DELETE FROM game_events WHERE game_id = $1",
+		"DELETE FROM ships WHERE game_id = $1",
+	} {
+		if _, err := tx.Exec(query, gameID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM games WHERE id = $1", gameID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func validateShipPlacement(ships []models.Ship, boardSize int) error {
 	if len(ships) != 5 {
 		return errors.New("must place exactly 5 ships")
 	}
@@ -244,8 +532,7 @@ func (g *GameService) validateShipPlacement(ships []models.Ship) error {
 	// Check for overlaps and valid positions
 	positions := make(map[string]bool)
 	for _, ship := range ships {
-		if ship.StartX < 0 || ship.StartX > 9 || ship.StartY < 0 || ship.StartY > 9 ||
-			ship.EndX < 0 || ship.EndX > 9 || ship.EndY < 0 || ship.EndY > 9 {
+		if !onBoard(boardSize, ship.StartX, ship.StartY) || !onBoard(boardSize, ship.EndX, ship.EndY) {
 			return errors.New("ship position out of bounds")
 		}
 
@@ -272,16 +559,16 @@ func (g *GameService) validateShipPlacement(ships []models.Ship) error {
 	return nil
 }
 
-func (g *GameService) checkAndUpdateSunkShip(gameID, shipID int) {
+func checkAndUpdateSunkShip(tx *sql.Tx, gameID, shipID int) error {
 	// Get ship details
 	var ship models.Ship
-	err := g.db.QueryRow(`
+	err := tx.QueryRow(`
 		SELECT id, game_id, player_id, type, size, start_x, start_y, end_x, end_y, is_vertical, is_sunk
 		FROM ships WHERE id = $1`, shipID).Scan(
 		&ship.ID, &ship.GameID, &ship.PlayerID, &ship.Type, &ship.Size,
 		&ship.StartX, &ship.StartY, &ship.EndX, &ship.EndY, &ship.IsVertical, &ship.IsSunk)
 	if err != nil {
-		return
+		return err
 	}
 
 	// Count hits on each position of this ship
@@ -289,7 +576,7 @@ func (g *GameService) checkAndUpdateSunkShip(gameID, shipID int) {
 	if ship.IsVertical {
 		for y := ship.StartY; y <= ship.EndY; y++ {
 			var moveExists int
-			g.db.QueryRow(`
+			tx.QueryRow(`
 				SELECT COUNT(*) FROM moves 
 				WHERE game_id = $1 AND x = $2 AND y = $3 AND is_hit = true AND player_id != $4`,
 				gameID, ship.StartX, y, ship.PlayerID).Scan(&moveExists)
@@ -300,7 +587,7 @@ func (g *GameService) checkAndUpdateSunkShip(gameID, shipID int) {
 	} else {
 		for x := ship.StartX; x <= ship.EndX; x++ {
 			var moveExists int
-			g.db.QueryRow(`
+			tx.QueryRow(`
 				SELECT COUNT(*) FROM moves 
 				WHERE game_id = $1 AND x = $2 AND y = $3 AND is_hit = true AND player_id != $4`,
 				gameID, x, ship.StartY, ship.PlayerID).Scan(&moveExists)
@@ -314,47 +601,52 @@ func (g *GameService) checkAndUpdateSunkShip(gameID, shipID int) {
 
 	if hitCount >= ship.Size {
 		fmt.Printf("Ship %d is sunk!\n", shipID)
-		g.db.Exec("UPDATE ships SET is_sunk = true WHERE id = $1", shipID)
+		_, err = tx.Exec("UPDATE ships SET is_sunk = true WHERE id = $1", shipID)
 	}
+	return err
 }
 
-func (g *GameService) checkGameEnd(gameID, playerID int) bool {
+func checkGameEnd(tx *sql.Tx, gameID, playerID int) (bool, error) {
 	var sunkShips, totalShips int
-	g.db.QueryRow(`
+	err := tx.QueryRow(`
 		SELECT COUNT(CASE WHEN is_sunk THEN 1 END), COUNT(*) 
 		FROM ships WHERE game_id = $1 AND player_id = $2`, gameID, playerID).Scan(&sunkShips, &totalShips)
+	if err != nil {
+		return false, err
+	}
 
 	fmt.Printf("Game end check for player %d: %d sunk ships out of %d total\n", playerID, sunkShips, totalShips)
 	gameEnded := sunkShips == totalShips
 	fmt.Printf("Game ended: %v\n", gameEnded)
 
-	return gameEnded
+	return gameEnded, nil
 }
 
-func (g *GameService) endGame(gameID, winnerID int) {
-	g.db.Exec(`
+// finishActiveGame marks an active game as won and scores it. Only the
+// caller that moves the game out of active scores it, so concurrent forfeits
+// and moves cannot count a game twice or change its winner.
+func finishActiveGame(tx *sql.Tx, game models.Game, winnerID int) error {
+	result, err := tx.Exec(`
 		UPDATE games SET status = $1, winner_id = $2, updated_at = CURRENT_TIMESTAMP 
-		WHERE id = $3`, models.GameStatusFinished, winnerID, gameID)
-
-	// Update scores
-	g.updatePlayerScore(winnerID, true)
-
-	// Get loser ID
-	var loserID int
-	g.db.QueryRow(`
-		SELECT CASE WHEN player1_id = $1 THEN player2_id ELSE player1_id END 
-		FROM games WHERE id = $2`, winnerID, gameID).Scan(&loserID)
-	g.updatePlayerScore(loserID, false)
-}
+		WHERE id = $3 AND status = $4`, models.GameStatusFinished, winnerID, game.ID, models.GameStatusActive)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return ErrGameOver
+	}
 
-func (g *GameService) updatePlayerScore(playerID int, won bool) {
-	if won {
-		g.db.Exec(`
-			UPDATE scores SET wins = wins + 1, points = points + 100 
-			WHERE player_id = $1`, playerID)
-	} else {
-		g.db.Exec(`
-			UPDATE scores SET losses = losses + 1 
-			WHERE player_id = $1`, playerID)
+	loserID := game.Player1ID
+	if winnerID == game.Player1ID {
+		loserID = *game.Player2ID
+	}
+	if _, err := tx.Exec(`
+		UPDATE scores SET wins = wins + 1, points = points + 100 
+		WHERE player_id = $1`, winnerID); err != nil {
+		return err
 	}
+	_, err = tx.Exec(`
+		UPDATE scores SET losses = losses + 1 
+		WHERE player_id = $1`, loserID)
+	return err
 }
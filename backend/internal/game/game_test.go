@@ -3,6 +3,7 @@ package game
 import (
 	"database/sql"
 	"testing"
+	"time"
 
 	"battleship-go/internal/models"
 
@@ -14,6 +15,7 @@ import (
 func setupTestDB(t *testing.T) *sql.DB {
 	db, err := sql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
 
 	// Create tables
 	_, err = db.Exec(`
@@ -33,6 +35,9 @@ func setupTestDB(t *testing.T) *sql.DB {
 			status TEXT DEFAULT 'waiting',
 			current_turn INTEGER,
 			winner_id INTEGER,
+			invited_player_id INTEGER,
+			chat_mode TEXT NOT NULL DEFAULT 'free',
+			board_size INTEGER NOT NULL DEFAULT 10,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		);
@@ -61,6 +66,47 @@ func setupTestDB(t *testing.T) *sql.DB {
 			ship_id INTEGER,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		);
+		
+		CREATE TABLE chat_channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER
+		);
+
+		CREATE TABLE chat_read_markers (
+			channel_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE quick_chat_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE game_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE chat_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE scores (
+			player_id INTEGER PRIMARY KEY,
+			wins INTEGER NOT NULL DEFAULT 0,
+			losses INTEGER NOT NULL DEFAULT 0,
+			points INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE TABLE chat_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL DEFAULT 0,
+			game_id INTEGER,
+			player_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
 	`)
 	require.NoError(t, err)
 
@@ -71,6 +117,8 @@ func setupTestDB(t *testing.T) *sql.DB {
 		(2, 'player2', 'player2@test.com', 'hash2')
 	`)
 	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO scores (player_id) VALUES (1), (2)`)
+	require.NoError(t, err)
 
 	return db
 }
@@ -82,13 +130,24 @@ func TestGameService_CreateGame(t *testing.T) {
 	gameService := NewGameService(db)
 
 	t.Run("successful game creation", func(t *testing.T) {
-		game, err := gameService.CreateGame(1)
+		game, err := gameService.CreateGame(1, "")
 
 		assert.NoError(t, err)
 		assert.NotNil(t, game)
 		assert.Equal(t, 1, game.Player1ID)
 		assert.Nil(t, game.Player2ID)
 		assert.Equal(t, models.GameStatusWaiting, game.Status)
+		assert.Equal(t, models.ChatModeFree, game.ChatMode)
+	})
+
+	t.Run("quick-chat only", func(t *testing.T) {
+		game, err := gameService.CreateGame(1, models.ChatModeQuick)
+
+		require.NoError(t, err)
+		assert.Equal(t, models.ChatModeQuick, game.ChatMode)
+
+		_, err = gameService.CreateGame(1, "silent")
+		assert.Error(t, err)
 	})
 }
 
@@ -99,7 +158,7 @@ func TestGameService_JoinGame(t *testing.T) {
 	gameService := NewGameService(db)
 
 	// Create a game first
-	game, err := gameService.CreateGame(1)
+	game, err := gameService.CreateGame(1, "")
 	require.NoError(t, err)
 
 	t.Run("successful join", func(t *testing.T) {
@@ -114,7 +173,7 @@ func TestGameService_JoinGame(t *testing.T) {
 	})
 
 	t.Run("cannot join own game", func(t *testing.T) {
-		newGame, err := gameService.CreateGame(1)
+		newGame, err := gameService.CreateGame(1, "")
 		require.NoError(t, err)
 
 		_, err = gameService.JoinGame(newGame.ID, 1)
@@ -123,6 +182,37 @@ func TestGameService_JoinGame(t *testing.T) {
 	})
 }
 
+func TestGameService_InviteGame(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	gameService := NewGameService(db)
+
+	invite, err := gameService.CreateInviteGame(1, 2)
+	require.NoError(t, err)
+	require.NotNil(t, invite.InvitedPlayerID)
+	assert.Equal(t, 2, *invite.InvitedPlayerID)
+
+	_, err = gameService.JoinGame(invite.ID, 3)
+	assert.Error(t, err)
+
+	_, err = gameService.DeclineInvite(invite.ID, 3)
+	assert.Error(t, err)
+
+	joined, err := gameService.JoinGame(invite.ID, 2)
+	require.NoError(t, err)
+	assert.Equal(t, models.GameStatusActive, joined.Status)
+
+	declined, err := gameService.CreateInviteGame(1, 2)
+	require.NoError(t, err)
+	_, err = gameService.DeclineInvite(declined.ID, 2)
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM games WHERE id = $1", declined.ID).Scan(&count))
+	assert.Zero(t, count)
+}
+
 func TestGameService_ValidateShipPlacement(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -138,7 +228,7 @@ func TestGameService_ValidateShipPlacement(t *testing.T) {
 			{Type: "destroyer", Size: 2, StartX: 0, StartY: 4, EndX: 1, EndY: 4, IsVertical: false},
 		}
 
-		err := gameService.validateShipPlacement(ships)
+		err := validateShipPlacement(ships, DefaultBoardSize)
 		assert.NoError(t, err)
 	})
 
@@ -147,7 +237,7 @@ func TestGameService_ValidateShipPlacement(t *testing.T) {
 			{Type: "carrier", Size: 5, StartX: 0, StartY: 0, EndX: 4, EndY: 0, IsVertical: false},
 		}
 
-		err := gameService.validateShipPlacement(ships)
+		err := validateShipPlacement(ships, DefaultBoardSize)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "must place exactly 5 ships")
 	})
@@ -161,7 +251,7 @@ func TestGameService_ValidateShipPlacement(t *testing.T) {
 			{Type: "destroyer", Size: 2, StartX: 0, StartY: 4, EndX: 1, EndY: 4, IsVertical: false},
 		}
 
-		err := gameService.validateShipPlacement(ships)
+		err := validateShipPlacement(ships, DefaultBoardSize)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "ships cannot overlap")
 	})
@@ -175,8 +265,116 @@ func TestGameService_ValidateShipPlacement(t *testing.T) {
 			{Type: "destroyer", Size: 2, StartX: 0, StartY: 4, EndX: 1, EndY: 4, IsVertical: false},
 		}
 
-		err := gameService.validateShipPlacement(ships)
+		err := validateShipPlacement(ships, DefaultBoardSize)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "ship position out of bounds")
 	})
+	t.Run("larger board", func(t *testing.T) {
+		ships := []models.Ship{
+			{Type: "carrier", Size: 5, StartX: 6, StartY: 0, EndX: 10, EndY: 0, IsVertical: false},
+			{Type: "battleship", Size: 4, StartX: 0, StartY: 1, EndX: 3, EndY: 1, IsVertical: false},
+			{Type: "cruiser", Size: 3, StartX: 0, StartY: 2, EndX: 2, EndY: 2, IsVertical: false},
+			{Type: "submarine", Size: 3, StartX: 0, StartY: 3, EndX: 2, EndY: 3, IsVertical: false},
+			{Type: "destroyer", Size: 2, StartX: 0, StartY: 4, EndX: 1, EndY: 4, IsVertical: false},
+		}
+
+		assert.NoError(t, validateShipPlacement(ships, 12))
+
+		largeBoard := NewGameService(db)
+		largeBoard.SetBoardSize(12)
+		game, err := largeBoard.CreateGame(1, "")
+		require.NoError(t, err)
+		assert.Equal(t, 12, game.BoardSize)
+		game, err = largeBoard.JoinGame(game.ID, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 12, game.BoardSize)
+
+		// The game keeps its size when the configured one changes
+		require.NoError(t, gameService.PlaceShips(game.ID, 1, ships))
+		_, err = gameService.MakeMove(game.ID, 1, 12, 0)
+		assert.EqualError(t, err, "position out of bounds")
+	})
+}
+
+func TestGameService_FinishGame(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	gameService := NewGameService(db)
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn) VALUES (1, 1, 2, 'active', 1)`)
+	require.NoError(t, err)
+
+	score := func(playerID int) (wins, losses, points int) {
+		require.NoError(t, db.QueryRow(`SELECT wins, losses, points FROM scores WHERE player_id = $1`, playerID).Scan(&wins, &losses, &points))
+		return
+	}
+
+	winner := 2
+	require.NoError(t, gameService.FinishGame(1, &winner))
+
+	// Whoever comes second, a forfeit or the last move, neither rescores
+	// the game nor changes its winner
+	other := 1
+	assert.Error(t, gameService.FinishGame(1, &other))
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	player2 := 2
+	assert.ErrorIs(t, finishActiveGame(tx, models.Game{ID: 1, Player1ID: 1, Player2ID: &player2}, 1), ErrGameOver)
+	require.NoError(t, tx.Rollback())
+
+	var winnerID int
+	require.NoError(t, db.QueryRow(`SELECT winner_id FROM games WHERE id = 1`).Scan(&winnerID))
+	assert.Equal(t, 2, winnerID)
+
+	wins, losses, points := score(2)
+	assert.Equal(t, []int{1, 0, 100}, []int{wins, losses, points})
+	wins, losses, points = score(1)
+	assert.Equal(t, []int{0, 1, 0}, []int{wins, losses, points})
+}
+
+func TestGameService_FinishIdleGame(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	gameService := NewGameService(db)
+	idle := time.Now().Add(-2 * time.Hour)
+	_, err := db.Exec(`INSERT INTO games (id, player1_id, player2_id, status, current_turn, updated_at) VALUES
+		(1, 1, 2, 'active', 1, $1), (2, 1, 2, 'active', 1, $1), (3, 1, 2, 'active', 1, $1)`, idle)
+	require.NoError(t, err)
+
+	idleSince := time.Now().Add(-time.Hour)
+	one, two := 1, 2
+	status := func(gameID int) string {
+		var status string
+		require.NoError(t, db.QueryRow("SELECT status FROM games WHERE id = $1", gameID).Scan(&status))
+		return status
+	}
+
+	t.Run("a turn taken since the snapshot is not forfeited", func(t *testing.T) {
+		_, err := db.Exec("UPDATE games SET current_turn = 2, updated_at = CURRENT_TIMESTAMP WHERE id = 1")
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, gameService.FinishIdleGame(1, &two, &one, idleSince), ErrGameNotIdle)
+		assert.Equal(t, models.GameStatusActive, status(1))
+	})
+
+	t.Run("a move since the snapshot is not forfeited", func(t *testing.T) {
+		_, err := db.Exec("INSERT INTO moves (game_id, player_id, x, y, is_hit) VALUES (2, 1, 0, 0, TRUE)")
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, gameService.FinishIdleGame(2, &two, &one, idleSince), ErrGameNotIdle)
+		assert.Equal(t, models.GameStatusActive, status(2))
+	})
+
+	t.Run("an idle game is forfeited and takes no more moves", func(t *testing.T) {
+		require.NoError(t, gameService.FinishIdleGame(3, &two, &one, idleSince))
+		assert.Equal(t, models.GameStatusFinished, status(3))
+
+		_, err := gameService.MakeMove(3, 1, 0, 0)
+		assert.EqualError(t, err, "game is not active")
+
+		var moves int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM moves WHERE game_id = 3").Scan(&moves))
+		assert.Zero(t, moves)
+	})
 }
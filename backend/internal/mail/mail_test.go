@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"battleship-go/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewFileMailer(dir, "noreply@battleship.local")
+	require.NoError(t, err)
+
+	require.NoError(t, mailer.Send(Message{To: "player@example.com", Subject: "Hello", Body: "Ahoy!"}))
+	require.NoError(t, mailer.Send(Message{To: "../evil", Subject: "Second", Body: "x"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	content, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "To: player@example.com\r\n")
+	assert.Contains(t, string(content), "Subject: Hello\r\n")
+	assert.Contains(t, string(content), "\r\n\r\nAhoy!")
+}
+
+func TestNew(t *testing.T) {
+	mailer, err := New(&config.Config{Mail: config.MailConfig{Driver: config.MailDriverLog}})
+	require.NoError(t, err)
+	assert.IsType(t, &LogMailer{}, mailer)
+
+	_, err = New(&config.Config{Mail: config.MailConfig{Driver: "carrier-pigeon"}})
+	assert.Error(t, err)
+}
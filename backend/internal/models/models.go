@@ -1,27 +1,39 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type User struct {
-	ID        int       `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Email     string    `json:"email" db:"email"`
-	Password  string    `json:"-" db:"password_hash"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID                int        `json:"id" db:"id"`
+	Username          string     `json:"username" db:"username"`
+	Email             string     `json:"email" db:"email"`
+	Password          string     `json:"-" db:"password_hash"`
+	Role              string     `json:"role" db:"role"` // player, moderator, admin
+	BannedAt          *time.Time `json:"banned_at,omitempty" db:"banned_at"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	PasswordChangedAt *time.Time `json:"-" db:"password_changed_at"`
+	TwoFactorEnabled  bool       `json:"two_factor_enabled"`
+	IsGuest           bool       `json:"is_guest" db:"is_guest"`
+	DeletedAt         *time.Time `json:"-" db:"deleted_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 type Game struct {
-	ID          int       `json:"id" db:"id"`
-	Player1ID   int       `json:"player1_id" db:"player1_id"`
-	Player2ID   *int      `json:"player2_id" db:"player2_id"`
-	Status      string    `json:"status" db:"status"` // waiting, active, finished
-	CurrentTurn *int      `json:"current_turn" db:"current_turn"`
-	WinnerID    *int      `json:"winner_id" db:"winner_id"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID              int       `json:"id" db:"id"`
+	Player1ID       int       `json:"player1_id" db:"player1_id"`
+	Player2ID       *int      `json:"player2_id" db:"player2_id"`
+	Status          string    `json:"status" db:"status"` // waiting, active, finished, cancelled
+	CurrentTurn     *int      `json:"current_turn" db:"current_turn"`
+	WinnerID        *int      `json:"winner_id" db:"winner_id"`
+	InvitedPlayerID *int      `json:"invited_player_id,omitempty" db:"invited_player_id"` // reserves a waiting game
+	ChatMode        string    `json:"chat_mode,omitempty" db:"chat_mode"`                 // free or quick
+	BoardSize       int       `json:"board_size,omitempty" db:"board_size"`               // width and height of the board
+	UnreadChat      int       `json:"unread_chat,omitempty" db:"-"`                       // only set when listing your games
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 }
 
 type Ship struct {
@@ -51,7 +63,8 @@ type Move struct {
 
 type ChatMessage struct {
 	ID        int       `json:"id" db:"id"`
-	GameID    int       `json:"game_id" db:"game_id"`
+	ChannelID int       `json:"channel_id" db:"channel_id"`
+	GameID    *int      `json:"game_id,omitempty" db:"game_id"` // set for game chat only
 	PlayerID  int       `json:"player_id" db:"player_id"`
 	Message   string    `json:"message" db:"message"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
@@ -67,11 +80,42 @@ type Score struct {
 	Points   int `json:"points" db:"points"`
 }
 
+type AuditEntry struct {
+	ID            int             `json:"id" db:"id"`
+	ActorID       int             `json:"actor_id" db:"actor_id"`
+	ActorUsername string          `json:"actor_username"`
+	Action        string          `json:"action" db:"action"`
+	TargetType    string          `json:"target_type" db:"target_type"`
+	TargetID      *int            `json:"target_id" db:"target_id"`
+	Details       json.RawMessage `json:"details,omitempty" db:"details"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+}
+
+// User role constants
+const (
+	RolePlayer    = "player"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// ValidRole reports whether role is one of the known user roles.
+func ValidRole(role string) bool {
+	return role == RolePlayer || role == RoleModerator || role == RoleAdmin
+}
+
 // Game status constants
 const (
-	GameStatusWaiting  = "waiting"
-	GameStatusActive   = "active"
-	GameStatusFinished = "finished"
+	GameStatusWaiting   = "waiting"
+	GameStatusActive    = "active"
+	GameStatusFinished  = "finished"
+	GameStatusCancelled = "cancelled" // closed by the cleanup before anyone joined
+)
+
+// Game chat modes. Quick-chat only games, such as kids or tournament games,
+// allow catalog phrases and reactions but no free text.
+const (
+	ChatModeFree  = "free"
+	ChatModeQuick = "quick"
 )
 
 // Ship types and sizes
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"battleship-go/internal/mail"
+)
+
+// EmailChannel emails notifications of the selected types to users who are
+// not connected, for example "it's your turn" in long-running games.
+type EmailChannel struct {
+	db      *sql.DB
+	mailer  mail.Mailer
+	baseURL string
+	types   map[string]bool
+	online  func(userID int) bool
+}
+
+// NewEmailChannel creates an email channel for the given notification types.
+// online reports whether a user is connected and will see the notification
+// live; such users are not emailed.
+func NewEmailChannel(db *sql.DB, mailer mail.Mailer, baseURL string, types []string, online func(userID int) bool) *EmailChannel {
+	selected := make(map[string]bool, len(types))
+	for _, t := range types {
+		selected[t] = true
+	}
+	return &EmailChannel{db: db, mailer: mailer, baseURL: baseURL, types: selected, online: online}
+}
+
+func (e *EmailChannel) Name() string {
+	return "email"
+}
+
+// Deliver emails the notification if its type is selected. Email never
+// counts as live delivery.
+func (e *EmailChannel) Deliver(n *Notification) (bool, error) {
+	if !e.types[n.Type] || (e.online != nil && e.online(n.UserID)) {
+		return false, nil
+	}
+
+	// Guests and deleted accounts have no usable address, and unverified
+	// addresses are not mailed
+	var username, email string
+	err := e.db.QueryRow(`
+		SELECT username, email FROM users
+		WHERE id = $1 AND is_guest = FALSE AND deleted_at IS NULL AND email_verified_at IS NOT NULL`,
+		n.UserID).Scan(&username, &email)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	subject, body := e.compose(n, username)
+	return false, e.mailer.Send(mail.Message{To: email, Subject: subject, Body: body})
+}
+
+func (e *EmailChannel) compose(n *Notification, username string) (string, string) {
+	var data struct {
+		GameID   int    `json:"game_id"`
+		Username string `json:"username"`
+	}
+	json.Unmarshal(n.Data, &data)
+
+	switch n.Type {
+	case TypeYourTurn:
+		return "It's your turn in Battleship",
+			fmt.Sprintf("Hi %s,\n\nYour opponent has moved. It's your turn:\n\n%s/game/%d\n", username, e.baseURL, data.GameID)
+	case TypeGameInvite:
+		return "You have been challenged in Battleship",
+			fmt.Sprintf("Hi %s,\n\n%s challenged you to a game:\n\n%s/game/%d\n", username, data.Username, e.baseURL, data.GameID)
+	default:
+		return "New Battleship notification",
+			fmt.Sprintf("Hi %s,\n\nYou have a new notification. Open Battleship to see it:\n\n%s\n", username, e.baseURL)
+	}
+}
@@ -0,0 +1,205 @@
+// Package notify keeps a per-user inbox of notifications and delivers each
+// one through pluggable channels, such as the WebSocket or email.
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// Notification types
+const (
+	TypeFriendRequest      = "friend_request"
+	TypeFriendAccepted     = "friend_accepted"
+	TypeGameInvite         = "game_invite"
+	TypeGameInviteDeclined = "game_invite_declined"
+	TypeYourTurn           = "your_turn"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// Notification is an event kept for a user until they read it.
+type Notification struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"user_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Channel delivers notifications outside the inbox. Deliver reports whether
+// the user received the notification live, in which case it is not pushed
+// again when they next connect.
+type Channel interface {
+	Name() string
+	Deliver(n *Notification) (bool, error)
+}
+
+type NotificationService struct {
+	db       *sql.DB
+	channels []Channel
+}
+
+func NewNotificationService(db *sql.DB, channels ...Channel) *NotificationService {
+	return &NotificationService{db: db, channels: channels}
+}
+
+// AddChannel registers another delivery channel. It must be called before
+// notifications are sent.
+func (s *NotificationService) AddChannel(channel Channel) {
+	s.channels = append(s.channels, channel)
+}
+
+const notificationColumns = "id, user_id, type, data, read_at, created_at"
+
+func scanNotification(row interface{ Scan(...interface{}) error }, n *Notification) error {
+	var data []byte
+	if err := row.Scan(&n.ID, &n.UserID, &n.Type, &data, &n.ReadAt, &n.CreatedAt); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		n.Data = json.RawMessage(data)
+	}
+	return nil
+}
+
+// Notify stores a notification for userID and hands it to every channel.
+// Channel failures are logged; the notification stays in the inbox.
+func (s *NotificationService) Notify(userID int, kind string, data interface{}) (*Notification, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var n Notification
+	err = scanNotification(s.db.QueryRow(`
+		INSERT INTO notifications (user_id, type, data) VALUES ($1, $2, $3)
+		RETURNING `+notificationColumns, userID, kind, string(payload)), &n)
+	if err != nil {
+		return nil, err
+	}
+
+	delivered := false
+	for _, channel := range s.channels {
+		ok, err := channel.Deliver(&n)
+		if err != nil {
+			log.Printf("Failed to deliver notification %d via %s: %v", n.ID, channel.Name(), err)
+			continue
+		}
+		delivered = delivered || ok
+	}
+	if delivered {
+		if _, err := s.db.Exec("UPDATE notifications SET delivered_at = $1 WHERE id = $2", time.Now(), n.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &n, nil
+}
+
+// List returns userID's notifications, newest first.
+func (s *NotificationService) List(userID int, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	rows, err := s.db.Query(`
+		SELECT `+notificationColumns+` FROM notifications
+		WHERE user_id = $1 AND ($2 = FALSE OR read_at IS NULL)
+		ORDER BY id DESC LIMIT $3 OFFSET $4`, userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return collect(rows)
+}
+
+// UnreadCount returns how many of userID's notifications are unread.
+func (s *NotificationService) UnreadCount(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks one of userID's notifications read.
+func (s *NotificationService) MarkRead(userID, notificationID int) error {
+	result, err := s.db.Exec(`
+		UPDATE notifications SET read_at = COALESCE(read_at, $1)
+		WHERE id = $2 AND user_id = $3`, time.Now(), notificationID, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks all of userID's notifications read.
+func (s *NotificationService) MarkAllRead(userID int) error {
+	_, err := s.db.Exec("UPDATE notifications SET read_at = $1 WHERE user_id = $2 AND read_at IS NULL",
+		time.Now(), userID)
+	return err
+}
+
+// MarkUndelivered clears the delivered mark of notifications that were sent
+// live but never reached the user, so Missed returns them again.
+func (s *NotificationService) MarkUndelivered(userID int, notificationIDs []int) error {
+	for _, id := range notificationIDs {
+		_, err := s.db.Exec("UPDATE notifications SET delivered_at = NULL WHERE id = $1 AND user_id = $2", id, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missed returns userID's unread notifications that were never delivered
+// live, oldest first, and marks them delivered. It is called when the user
+// connects.
+func (s *NotificationService) Missed(userID int) ([]Notification, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT `+notificationColumns+` FROM notifications
+		WHERE user_id = $1 AND delivered_at IS NULL AND read_at IS NULL
+		ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	missed, err := collect(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(missed) > 0 {
+		_, err = tx.Exec(`
+			UPDATE notifications SET delivered_at = $1
+			WHERE user_id = $2 AND delivered_at IS NULL AND id <= $3`, time.Now(), userID, missed[len(missed)-1].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return missed, tx.Commit()
+}
+
+func collect(rows *sql.Rows) ([]Notification, error) {
+	defer rows.Close()
+
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var n Notification
+		if err := scanNotification(rows, &n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"battleship-go/internal/mail"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			email TEXT UNIQUE NOT NULL,
+			is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+			email_verified_at DATETIME,
+			deleted_at DATETIME
+		);
+
+		CREATE TABLE notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			data TEXT,
+			delivered_at DATETIME,
+			read_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		INSERT INTO users (id, username, email, email_verified_at) VALUES
+		(1, 'alice', 'alice@test.com', CURRENT_TIMESTAMP),
+		(2, 'bob', 'bob@test.com', NULL);
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+// fakeChannel records deliveries and reports users in online as reached live.
+type fakeChannel struct {
+	online    map[int]bool
+	delivered []*Notification
+	err       error
+}
+
+func (f *fakeChannel) Name() string { return "fake" }
+
+func (f *fakeChannel) Deliver(n *Notification) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	f.delivered = append(f.delivered, n)
+	return f.online[n.UserID], nil
+}
+
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestNotificationService_Inbox(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	channel := &fakeChannel{online: map[int]bool{1: true}}
+	service := NewNotificationService(db, channel)
+
+	first, err := service.Notify(1, TypeFriendRequest, map[string]interface{}{"username": "bob"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"username":"bob"}`, string(first.Data))
+	_, err = service.Notify(2, TypeYourTurn, map[string]int{"game_id": 3})
+	require.NoError(t, err)
+	_, err = service.Notify(2, TypeGameInvite, map[string]int{"game_id": 4})
+	require.NoError(t, err)
+	assert.Len(t, channel.delivered, 3)
+
+	t.Run("missed notifications are pushed once", func(t *testing.T) {
+		missed, err := service.Missed(1)
+		require.NoError(t, err)
+		assert.Empty(t, missed, "alice was online")
+
+		missed, err = service.Missed(2)
+		require.NoError(t, err)
+		require.Len(t, missed, 2)
+		assert.Equal(t, TypeYourTurn, missed[0].Type)
+
+		missed, err = service.Missed(2)
+		require.NoError(t, err)
+		assert.Empty(t, missed)
+	})
+
+	t.Run("unwritten notifications are pushed again", func(t *testing.T) {
+		require.NoError(t, service.MarkUndelivered(1, []int{first.ID}))
+		require.NoError(t, service.MarkUndelivered(2, []int{first.ID}), "other users' notifications are untouched")
+
+		missed, err := service.Missed(1)
+		require.NoError(t, err)
+		require.Len(t, missed, 1)
+		assert.Equal(t, first.ID, missed[0].ID)
+
+		missed, err = service.Missed(2)
+		require.NoError(t, err)
+		assert.Empty(t, missed)
+	})
+
+	t.Run("read state", func(t *testing.T) {
+		unread, err := service.UnreadCount(2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, unread)
+
+		list, err := service.List(2, false, 0, 0)
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+		assert.Equal(t, TypeGameInvite, list[0].Type, "newest first")
+
+		require.NoError(t, service.MarkRead(2, list[0].ID))
+		assert.ErrorIs(t, service.MarkRead(1, list[1].ID), ErrNotificationNotFound, "only your own")
+
+		list, err = service.List(2, true, 0, 0)
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, TypeYourTurn, list[0].Type)
+
+		require.NoError(t, service.MarkAllRead(2))
+		unread, err = service.UnreadCount(2)
+		require.NoError(t, err)
+		assert.Zero(t, unread)
+	})
+
+	t.Run("channel failures keep the notification", func(t *testing.T) {
+		service := NewNotificationService(db, &fakeChannel{err: errors.New("down")})
+		_, err := service.Notify(1, TypeYourTurn, nil)
+		require.NoError(t, err)
+
+		missed, err := service.Missed(1)
+		require.NoError(t, err)
+		assert.Len(t, missed, 1)
+	})
+}
+
+func TestEmailChannel(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	mailer := &recordingMailer{}
+	online := map[int]bool{}
+	channel := NewEmailChannel(db, mailer, "http://app", []string{TypeYourTurn},
+		func(userID int) bool { return online[userID] })
+	service := NewNotificationService(db, channel)
+
+	_, err := service.Notify(1, TypeYourTurn, map[string]int{"game_id": 7})
+	require.NoError(t, err)
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "alice@test.com", mailer.sent[0].To)
+	assert.Contains(t, mailer.sent[0].Body, "http://app/game/7")
+
+	_, err = service.Notify(1, TypeFriendRequest, nil)
+	require.NoError(t, err)
+	_, err = service.Notify(2, TypeYourTurn, nil)
+	require.NoError(t, err)
+	online[1] = true
+	_, err = service.Notify(1, TypeYourTurn, nil)
+	require.NoError(t, err)
+	assert.Len(t, mailer.sent, 1, "unselected types, unverified addresses and online users are not mailed")
+
+	missed, err := service.Missed(1)
+	require.NoError(t, err)
+	assert.Len(t, missed, 3, "email is not live delivery")
+}
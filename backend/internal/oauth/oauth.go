@@ -0,0 +1,517 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"battleship-go/internal/auth"
+	"battleship-go/internal/config"
+	"battleship-go/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// loginStateTTL bounds how long a user may take at the provider's login page.
+const loginStateTTL = 10 * time.Minute
+
+var (
+	// ErrUnknownProvider is returned for providers that are not configured.
+	ErrUnknownProvider = errors.New("unknown login provider")
+	// ErrInvalidState is returned when the callback state is unknown, expired or
+	// belongs to a different provider.
+	ErrInvalidState = errors.New("invalid or expired login state")
+	// ErrLinkRequired is returned when the provider's email belongs to a local
+	// account whose address was never verified. Anyone could have registered
+	// it, so the owner has to log in and link the provider explicitly.
+	ErrLinkRequired = errors.New("an account with this email already exists; log in and link this provider from your account")
+	// ErrIdentityInUse is returned when linking an identity that already
+	// belongs to another account.
+	ErrIdentityInUse = errors.New("this login is already linked to another account")
+)
+
+// Identity is the user information obtained from an external provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+type endpoints struct {
+	issuer   string
+	auth     string
+	token    string
+	userInfo string
+}
+
+type provider struct {
+	cfg config.OAuthProviderConfig
+
+	mu        sync.Mutex
+	endpoints *endpoints
+}
+
+// Service implements the authorization code flow with PKCE against external
+// OAuth2 / OpenID Connect providers and maps their identities to local users.
+type Service struct {
+	db          *sql.DB
+	authService *auth.AuthService
+	providers   map[string]*provider
+	httpClient  *http.Client
+}
+
+func NewService(db *sql.DB, authService *auth.AuthService, providers []config.OAuthProviderConfig) *Service {
+	s := &Service{
+		db:          db,
+		authService: authService,
+		providers:   make(map[string]*provider),
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, cfg := range providers {
+		s.providers[cfg.Name] = &provider{cfg: cfg}
+	}
+	return s
+}
+
+// Providers returns the names of the configured providers.
+func (s *Service) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AuthorizationURL starts a login and returns the provider URL to redirect
+// the user to, along with the state that the callback must present.
+func (s *Service) AuthorizationURL(ctx context.Context, providerName string) (string, string, error) {
+	return s.startLogin(ctx, providerName, nil)
+}
+
+// LinkURL is AuthorizationURL for a signed-in user linking the provider to
+// their account. The callback attaches the identity to that user.
+func (s *Service) LinkURL(ctx context.Context, providerName string, userID int) (string, string, error) {
+	return s.startLogin(ctx, providerName, &userID)
+}
+
+func (s *Service) startLogin(ctx context.Context, providerName string, linkUserID *int) (string, string, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	ep, err := s.discover(ctx, p)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM oauth_states WHERE expires_at < $1", now); err != nil {
+		return "", "", err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO oauth_states (state, provider, code_verifier, nonce, expires_at, link_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`, state, providerName, verifier, nonce, now.Add(loginStateTTL), linkUserID)
+	if err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if ep.issuer != "" {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(ep.auth, "?") {
+		separator = "&"
+	}
+	return ep.auth + separator + params.Encode(), state, nil
+}
+
+// HandleCallback completes a login: it redeems the code, resolves the external
+// identity to a local user (linking or provisioning as needed) and issues our JWT.
+func (s *Service) HandleCallback(ctx context.Context, providerName, state, code string) (*models.User, string, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+
+	var stateProvider, verifier, nonce string
+	var expiresAt time.Time
+	var linkUserID *int
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM oauth_states WHERE state = $1
+		RETURNING provider, code_verifier, nonce, expires_at, link_user_id`, state).Scan(
+		&stateProvider, &verifier, &nonce, &expiresAt, &linkUserID)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrInvalidState
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if stateProvider != providerName || time.Now().After(expiresAt) {
+		return nil, "", ErrInvalidState
+	}
+
+	ep, err := s.discover(ctx, p)
+	if err != nil {
+		return nil, "", err
+	}
+
+	identity, err := s.exchange(ctx, p, ep, code, verifier, nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var user *models.User
+	if linkUserID != nil {
+		user, err = s.linkIdentity(*linkUserID, providerName, identity)
+	} else {
+		user, err = s.resolveUser(providerName, identity)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if user.BannedAt != nil {
+		return nil, "", auth.ErrUserBanned
+	}
+
+	// Accounts with two-factor authentication still need their second factor
+	token, err := s.authService.IssueLoginToken(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+// discover resolves the provider endpoints, fetching the OpenID configuration
+// once when an issuer is configured. Explicit endpoints take precedence.
+func (s *Service) discover(ctx context.Context, p *provider) (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	ep := &endpoints{auth: p.cfg.AuthURL, token: p.cfg.TokenURL, userInfo: p.cfg.UserInfoURL}
+	if p.cfg.IssuerURL != "" {
+		var doc struct {
+			Issuer                string `json:"issuer"`
+			AuthorizationEndpoint string `json:"authorization_endpoint"`
+			TokenEndpoint         string `json:"token_endpoint"`
+			UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		}
+		discoveryURL := strings.TrimRight(p.cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+		if err := s.getJSON(ctx, discoveryURL, "", &doc); err != nil {
+			return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+		}
+
+		ep.issuer = doc.Issuer
+		if ep.auth == "" {
+			ep.auth = doc.AuthorizationEndpoint
+		}
+		if ep.token == "" {
+			ep.token = doc.TokenEndpoint
+		}
+		if ep.userInfo == "" {
+			ep.userInfo = doc.UserInfoEndpoint
+		}
+	}
+
+	if ep.auth == "" || ep.token == "" {
+		return nil, fmt.Errorf("provider %q has no authorization or token endpoint", p.cfg.Name)
+	}
+
+	p.endpoints = ep
+	return ep, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+type idTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func (s *Service) exchange(ctx context.Context, p *provider, ep *endpoints, code, verifier, nonce string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.AuthStyle == "post" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.AuthStyle != "post" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" || tokens.AccessToken == "" {
+		return nil, fmt.Errorf("token request rejected: %s %s", tokens.Error, tokens.ErrorDesc)
+	}
+
+	identity := &Identity{}
+	if ep.issuer != "" {
+		if tokens.IDToken == "" {
+			return nil, errors.New("provider returned no ID token")
+		}
+		claims, err := validateIDToken(tokens.IDToken, ep.issuer, p.cfg.ClientID, nonce)
+		if err != nil {
+			return nil, err
+		}
+		identity.Subject = claims.Subject
+		identity.Email = claims.Email
+		identity.EmailVerified = claims.EmailVerified
+		identity.Username = claims.PreferredUsername
+	}
+
+	if ep.userInfo != "" {
+		if err := s.fetchUserInfo(ctx, ep.userInfo, tokens.AccessToken, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	if identity.Subject == "" {
+		return nil, errors.New("provider returned no subject")
+	}
+	return identity, nil
+}
+
+// validateIDToken checks the ID token claims. The token came directly from the
+// token endpoint over TLS, so per OpenID Connect Core 3.1.3.7 the TLS server
+// validation stands in for verifying the signature.
+func validateIDToken(idToken, issuer, clientID, nonce string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	validator := jwt.NewValidator(jwt.WithIssuer(issuer), jwt.WithAudience(clientID), jwt.WithExpirationRequired())
+	if err := validator.Validate(claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// fetchUserInfo fills in missing identity fields from the userinfo endpoint.
+// GitHub-style responses use a numeric id and login instead of sub and
+// preferred_username.
+func (s *Service) fetchUserInfo(ctx context.Context, userInfoURL, accessToken string, identity *Identity) error {
+	var info struct {
+		Sub               string          `json:"sub"`
+		ID                json.RawMessage `json:"id"`
+		Email             string          `json:"email"`
+		EmailVerified     *bool           `json:"email_verified"`
+		PreferredUsername string          `json:"preferred_username"`
+		Login             string          `json:"login"`
+	}
+	if err := s.getJSON(ctx, userInfoURL, accessToken, &info); err != nil {
+		return fmt.Errorf("userinfo request failed: %w", err)
+	}
+
+	subject := info.Sub
+	if subject == "" {
+		subject = strings.Trim(string(info.ID), `"`)
+	}
+	if identity.Subject != "" && subject != "" && subject != identity.Subject {
+		return errors.New("userinfo subject does not match ID token")
+	}
+	if identity.Subject == "" {
+		identity.Subject = subject
+	}
+	if identity.Email == "" {
+		identity.Email = info.Email
+		identity.EmailVerified = info.EmailVerified != nil && *info.EmailVerified
+	}
+	if identity.Username == "" {
+		identity.Username = info.PreferredUsername
+	}
+	if identity.Username == "" {
+		identity.Username = info.Login
+	}
+
+	return nil
+}
+
+// resolveUser finds the local user for an external identity. Known identities
+// log in directly; a verified email matching an existing account with a
+// verified email links to it; otherwise a new account is provisioned.
+func (s *Service) resolveUser(providerName string, identity *Identity) (*models.User, error) {
+	var userID int
+	err := s.db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		providerName, identity.Subject).Scan(&userID)
+	if err == nil {
+		return s.authService.GetUserByID(userID)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var user *models.User
+	if identity.EmailVerified && identity.Email != "" {
+		err = s.db.QueryRow("SELECT id FROM users WHERE LOWER(email) = LOWER($1)", identity.Email).Scan(&userID)
+		if err == nil {
+			if user, err = s.authService.GetUserByID(userID); err != nil {
+				return nil, err
+			}
+			if user.EmailVerifiedAt == nil {
+				return nil, ErrLinkRequired
+			}
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		base := identity.Username
+		if base == "" {
+			base, _, _ = strings.Cut(identity.Email, "@")
+		}
+		username, err := s.authService.UniqueUsername(base)
+		if err != nil {
+			return nil, err
+		}
+
+		email := identity.Email
+		if email == "" || !identity.EmailVerified {
+			// Unverified addresses could collide with or claim someone else's account
+			email = fmt.Sprintf("%s-%s@users.noreply.invalid", providerName, identity.Subject)
+		}
+
+		if user, err = s.authService.ProvisionUser(username, email, identity.EmailVerified); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`, user.ID, providerName, identity.Subject, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// linkIdentity attaches an external identity to a signed-in user.
+func (s *Service) linkIdentity(userID int, providerName string, identity *Identity) (*models.User, error) {
+	var ownerID int
+	err := s.db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		providerName, identity.Subject).Scan(&ownerID)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = s.db.Exec(`
+			INSERT INTO user_identities (user_id, provider, subject, email)
+			VALUES ($1, $2, $3, $4)`, userID, providerName, identity.Subject, identity.Email)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case ownerID != userID:
+		return nil, ErrIdentityInUse
+	}
+	return s.authService.GetUserByID(userID)
+}
+
+func (s *Service) getJSON(ctx context.Context, target, bearer string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, target)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func randomString(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,388 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"battleship-go/internal/auth"
+	"battleship-go/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			email TEXT UNIQUE NOT NULL,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'player',
+			banned_at DATETIME,
+			ban_reason TEXT,
+			email_verified_at DATETIME,
+			password_changed_at DATETIME,
+			totp_secret TEXT,
+			totp_enabled_at DATETIME,
+			totp_last_step INTEGER NOT NULL DEFAULT 0,
+			is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+			deleted_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE scores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_id INTEGER UNIQUE NOT NULL,
+			wins INTEGER DEFAULT 0,
+			losses INTEGER DEFAULT 0,
+			hits INTEGER DEFAULT 0,
+			misses INTEGER DEFAULT 0,
+			points INTEGER DEFAULT 0
+		);
+
+		CREATE TABLE user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, subject)
+		);
+
+		CREATE TABLE oauth_states (
+			state TEXT PRIMARY KEY,
+			provider TEXT NOT NULL,
+			code_verifier TEXT NOT NULL,
+			nonce TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			link_user_id INTEGER REFERENCES users(id)
+		);
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+// stubUser is what the stub provider reports for the next login.
+type stubUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// stubProvider is a minimal OpenID Connect provider. Authorization requests
+// are simulated by the test, which hands the recorded code challenge and
+// nonce to the provider before calling the callback.
+type stubProvider struct {
+	server *httptest.Server
+	github bool
+
+	mu        sync.Mutex
+	user      stubUser
+	challenge string
+	nonce     string
+	badNonce  bool
+}
+
+func newStubProvider(t *testing.T, github bool) *stubProvider {
+	p := &stubProvider{github: github}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"userinfo_endpoint":      p.server.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		require.NoError(t, r.ParseForm())
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "valid-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		if p.github {
+			assert.Equal(t, "secret", r.PostForm.Get("client_secret"))
+		} else {
+			clientID, secret, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "client", clientID)
+			assert.Equal(t, "secret", secret)
+		}
+
+		response := map[string]string{"access_token": "access-" + p.user.Subject, "token_type": "Bearer"}
+		if !p.github {
+			nonce := p.nonce
+			if p.badNonce {
+				nonce = "forged"
+			}
+			idToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"iss":                p.server.URL,
+				"aud":                "client",
+				"sub":                p.user.Subject,
+				"exp":                time.Now().Add(time.Minute).Unix(),
+				"nonce":              nonce,
+				"email":              p.user.Email,
+				"email_verified":     p.user.EmailVerified,
+				"preferred_username": p.user.Username,
+			})
+			signed, err := idToken.SignedString([]byte("provider-key"))
+			require.NoError(t, err)
+			response["id_token"] = signed
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer access-"+p.user.Subject {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if p.github {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":    json.Number(p.user.Subject),
+				"login": p.user.Username,
+				"email": p.user.Email,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":   p.user.Subject,
+			"email": p.user.Email,
+		})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *stubProvider) config(name string) config.OAuthProviderConfig {
+	cfg := config.OAuthProviderConfig{
+		Name:         name,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://battleship.example/api/auth/oauth/" + name + "/callback",
+		Scopes:       []string{"openid", "email"},
+		AuthStyle:    "basic",
+	}
+	if p.github {
+		cfg.AuthURL = p.server.URL + "/authorize"
+		cfg.TokenURL = p.server.URL + "/token"
+		cfg.UserInfoURL = p.server.URL + "/userinfo"
+		cfg.AuthStyle = "post"
+	} else {
+		cfg.IssuerURL = p.server.URL
+	}
+	return cfg
+}
+
+// login runs the full flow for user and returns the callback result.
+func (p *stubProvider) login(t *testing.T, service *Service, name string, user stubUser) (string, error) {
+	authURL, state, err := service.AuthorizationURL(context.Background(), name)
+	require.NoError(t, err)
+	return p.complete(t, service, name, user, authURL, state)
+}
+
+// complete finishes a started login for user at the provider.
+func (p *stubProvider) complete(t *testing.T, service *Service, name string, user stubUser, authURL, state string) (string, error) {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Equal(t, state, query.Get("state"))
+
+	p.mu.Lock()
+	p.user = user
+	p.challenge = query.Get("code_challenge")
+	p.nonce = query.Get("nonce")
+	p.mu.Unlock()
+
+	_, token, err := service.HandleCallback(context.Background(), name, state, "valid-code")
+	return token, err
+}
+
+func TestService_OIDCLogin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	provider := newStubProvider(t, false)
+	authService := auth.NewAuthService(db, "test-secret")
+	service := NewService(db, authService, []config.OAuthProviderConfig{provider.config("sso")})
+
+	alice := stubUser{Subject: "alice-1", Email: "alice@corp.example", EmailVerified: true, Username: "alice"}
+
+	t.Run("first login provisions a user", func(t *testing.T) {
+		token, err := provider.login(t, service, "sso", alice)
+		require.NoError(t, err)
+
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", claims.Username)
+
+		user, err := authService.GetUserByID(claims.UserID)
+		require.NoError(t, err)
+		assert.Equal(t, "alice@corp.example", user.Email)
+		assert.NotNil(t, user.EmailVerifiedAt)
+	})
+
+	t.Run("second login reuses the linked user", func(t *testing.T) {
+		token, err := provider.login(t, service, "sso", alice)
+		require.NoError(t, err)
+
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, 1, claims.UserID)
+
+		var users int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users))
+		assert.Equal(t, 1, users)
+	})
+
+	t.Run("username collisions get a suffix", func(t *testing.T) {
+		token, err := provider.login(t, service, "sso",
+			stubUser{Subject: "alice-2", Email: "other@corp.example", EmailVerified: false, Username: "alice"})
+		require.NoError(t, err)
+
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, "alice2", claims.Username)
+
+		// Unverified emails are not stored as the account email
+		user, err := authService.GetUserByID(claims.UserID)
+		require.NoError(t, err)
+		assert.Equal(t, "sso-alice-2@users.noreply.invalid", user.Email)
+	})
+
+	t.Run("verified email links an existing account", func(t *testing.T) {
+		existing, err := authService.Register("bob", "bob@corp.example", "password123")
+		require.NoError(t, err)
+		_, err = db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = $1", existing.ID)
+		require.NoError(t, err)
+
+		token, err := provider.login(t, service, "sso",
+			stubUser{Subject: "bob-sso", Email: "BOB@corp.example", EmailVerified: true, Username: "bobby"})
+		require.NoError(t, err)
+
+		claims, err := authService.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, existing.ID, claims.UserID)
+	})
+
+	t.Run("unverified local account is not linked", func(t *testing.T) {
+		// Whoever registered the address first never proved they own it
+		squatter, err := authService.Register("carol", "carol@corp.example", "password123")
+		require.NoError(t, err)
+
+		carol := stubUser{Subject: "carol-sso", Email: "carol@corp.example", EmailVerified: true, Username: "carol"}
+		_, err = provider.login(t, service, "sso", carol)
+		assert.ErrorIs(t, err, ErrLinkRequired)
+
+		var identities int
+		require.NoError(t, db.QueryRow(
+			"SELECT COUNT(*) FROM user_identities WHERE user_id = $1", squatter.ID).Scan(&identities))
+		assert.Equal(t, 0, identities)
+
+		t.Run("signed-in owner links explicitly", func(t *testing.T) {
+			authURL, state, err := service.LinkURL(context.Background(), "sso", squatter.ID)
+			require.NoError(t, err)
+
+			token, err := provider.complete(t, service, "sso", carol, authURL, state)
+			require.NoError(t, err)
+
+			claims, err := authService.ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, squatter.ID, claims.UserID)
+
+			token, err = provider.login(t, service, "sso", carol)
+			require.NoError(t, err)
+			claims, err = authService.ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, squatter.ID, claims.UserID)
+		})
+
+		t.Run("identity of another account cannot be linked", func(t *testing.T) {
+			authURL, state, err := service.LinkURL(context.Background(), "sso", 1)
+			require.NoError(t, err)
+
+			_, err = provider.complete(t, service, "sso", carol, authURL, state)
+			assert.ErrorIs(t, err, ErrIdentityInUse)
+		})
+	})
+
+	t.Run("nonce mismatch is rejected", func(t *testing.T) {
+		provider.badNonce = true
+		defer func() { provider.badNonce = false }()
+
+		_, err := provider.login(t, service, "sso", alice)
+		assert.ErrorContains(t, err, "nonce")
+	})
+
+	t.Run("state is single use", func(t *testing.T) {
+		_, state, err := service.AuthorizationURL(context.Background(), "sso")
+		require.NoError(t, err)
+
+		_, _, err = service.HandleCallback(context.Background(), "sso", state, "wrong-code")
+		assert.Error(t, err)
+
+		_, _, err = service.HandleCallback(context.Background(), "sso", state, "valid-code")
+		assert.ErrorIs(t, err, ErrInvalidState)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, _, err := service.AuthorizationURL(context.Background(), "nope")
+		assert.ErrorIs(t, err, ErrUnknownProvider)
+	})
+}
+
+func TestService_GitHubStyleLogin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	provider := newStubProvider(t, true)
+	authService := auth.NewAuthService(db, "test-secret")
+	service := NewService(db, authService, []config.OAuthProviderConfig{provider.config("github")})
+
+	token, err := provider.login(t, service, "github", stubUser{Subject: "12345", Username: "octo cat", Email: "octo@example.com"})
+	require.NoError(t, err)
+
+	claims, err := authService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "octo_cat", claims.Username)
+
+	var subject string
+	require.NoError(t, db.QueryRow("SELECT subject FROM user_identities WHERE provider = 'github'").Scan(&subject))
+	assert.Equal(t, "12345", subject)
+}
@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Encoding is how messages are serialized on the wire.
+type Encoding string
+
+// Encodings. MessagePack carries exactly the JSON form of each message, so the
+// same schemas apply; it is only offered from version 2.
+const (
+	EncodingJSON    Encoding = "json"
+	EncodingMsgpack Encoding = "msgpack"
+)
+
+// subprotocolPrefix names formats as WebSocket subprotocols, e.g.
+// "battleship.v2" or "battleship.v2.msgpack".
+const subprotocolPrefix = "battleship.v"
+
+// Format is the negotiated protocol version and encoding of a connection.
+type Format struct {
+	Version  int
+	Encoding Encoding
+}
+
+// DefaultFormat is used for clients that do not ask for anything.
+var DefaultFormat = Format{Version: Version1, Encoding: EncodingJSON}
+
+// Binary reports whether messages in this format are sent as binary frames.
+func (f Format) Binary() bool {
+	return f.Encoding == EncodingMsgpack
+}
+
+// Subprotocol returns the WebSocket subprotocol name of the format.
+func (f Format) Subprotocol() string {
+	name := subprotocolPrefix + strconv.Itoa(f.Version)
+	if f.Encoding != EncodingJSON {
+		name += "." + string(f.Encoding)
+	}
+	return name
+}
+
+func (f Format) supported() bool {
+	if f.Version < MinVersion || f.Version > CurrentVersion {
+		return false
+	}
+	return f.Encoding == EncodingJSON || (f.Encoding == EncodingMsgpack && f.Version >= Version2)
+}
+
+// Encode serializes the envelope in this format.
+func (f Format) Encode(e *Envelope) ([]byte, error) {
+	msgBytes, err := Encode(e, f.Version)
+	if err != nil || f.Encoding == EncodingJSON {
+		return msgBytes, err
+	}
+	return jsonToMsgpack(msgBytes)
+}
+
+// Decode parses a client message sent in this format.
+func (f Format) Decode(raw []byte) (*Inbound, error) {
+	if f.Encoding == EncodingMsgpack {
+		var err error
+		if raw, err = msgpackToJSON(raw); err != nil {
+			return nil, err
+		}
+	}
+	return DecodeInbound(raw)
+}
+
+// Subprotocols lists the supported subprotocols, newest first.
+func Subprotocols() []string {
+	var names []string
+	for v := CurrentVersion; v >= MinVersion; v-- {
+		for _, encoding := range []Encoding{EncodingMsgpack, EncodingJSON} {
+			if format := (Format{Version: v, Encoding: encoding}); format.supported() {
+				names = append(names, format.Subprotocol())
+			}
+		}
+	}
+	return names
+}
+
+// parseSubprotocol reads a subprotocol name such as "battleship.v2.msgpack".
+func parseSubprotocol(name string) (Format, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(name), subprotocolPrefix)
+	if !ok {
+		return Format{}, false
+	}
+	versionStr, encoding, found := strings.Cut(rest, ".")
+	if !found {
+		encoding = string(EncodingJSON)
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return Format{}, false
+	}
+	return Format{Version: version, Encoding: Encoding(encoding)}, true
+}
+
+// Negotiate picks the format of a new connection. An explicit version or
+// encoding (the v and encoding query parameters) must be supported; an
+// encoding without a version gets the current version. Otherwise the newest
+// version among the offered subprotocols wins, in the client's order of
+// preference, and clients that offer none get version 1 in JSON.
+func Negotiate(requestedVersion, requestedEncoding string, offered []string) (Format, error) {
+	if requestedVersion != "" || requestedEncoding != "" {
+		format := Format{Version: CurrentVersion, Encoding: EncodingJSON}
+		if requestedEncoding != "" {
+			format.Encoding = Encoding(requestedEncoding)
+		}
+		if requestedVersion != "" {
+			version, err := strconv.Atoi(requestedVersion)
+			if err != nil {
+				return Format{}, fmt.Errorf("%w %q", ErrUnsupportedVersion, requestedVersion)
+			}
+			format.Version = version
+		}
+		if !format.supported() {
+			return Format{}, fmt.Errorf("%w: %s is not supported; versions are %d to %d, msgpack needs version %d",
+				ErrUnsupportedVersion, format.Subprotocol(), MinVersion, CurrentVersion, Version2)
+		}
+		return format, nil
+	}
+
+	var best Format
+	for _, name := range offered {
+		format, ok := parseSubprotocol(name)
+		if ok && format.supported() && format.Version > best.Version {
+			best = format
+		}
+	}
+	if best.Version == 0 {
+		if len(offered) > 0 {
+			return Format{}, fmt.Errorf("%w: none of %v", ErrUnsupportedVersion, offered)
+		}
+		return DefaultFormat, nil
+	}
+	return best, nil
+}
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = mapType
+	return h
+}()
+
+var mapType = reflect.TypeOf(map[string]interface{}(nil))
+
+// jsonToMsgpack re-encodes a JSON message as MessagePack, keeping integers
+// as integers.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(numbersToValues(value)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// msgpackToJSON re-encodes a MessagePack message as JSON.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// numbersToValues replaces JSON numbers with int64 or float64.
+func numbersToValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = numbersToValues(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = numbersToValues(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,286 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"battleship-go/internal/chat"
+	"battleship-go/internal/models"
+)
+
+// Welcome is the first message on a version 2 connection. It confirms the
+// negotiated version.
+type Welcome struct {
+	Version    int `json:"version"`
+	MinVersion int `json:"min_version"`
+	MaxVersion int `json:"max_version"`
+	UserID     int `json:"user_id"`
+}
+
+func (Welcome) MessageType() MessageType { return TypeWelcome }
+
+// Error reports a client message the server could not handle. Version 1
+// clients never get one.
+type Error struct {
+	Message string `json:"message"`
+}
+
+func (Error) MessageType() MessageType { return TypeError }
+
+// GameCreated announces a new game to everyone.
+type GameCreated struct {
+	models.Game
+}
+
+func (GameCreated) MessageType() MessageType { return TypeNewGameCreated }
+
+func (p GameCreated) legacy() legacyFields {
+	return legacyFields{data: p.Game, message: "new_game_available"}
+}
+
+// Reasons for a game update
+const (
+	ReasonPlayerJoined = "player_joined"
+	ReasonMove         = "move"
+	ReasonForfeit      = "forfeit"
+	ReasonInactive     = "inactive"
+)
+
+// GameUpdate tells the game room the game changed: a player joined, a
+// disconnected player forfeited or the cleanup ended an inactive game and
+// Game is set, or a shot was fired and Move is set.
+type GameUpdate struct {
+	Reason string       `json:"reason"`
+	Game   *models.Game `json:"game,omitempty"`
+	Move   *models.Move `json:"move,omitempty"`
+}
+
+func (GameUpdate) MessageType() MessageType { return TypeGameUpdate }
+
+func (p GameUpdate) legacy() legacyFields {
+	if p.Move != nil {
+		return legacyFields{data: p.Move}
+	}
+	return legacyFields{data: p.Game, message: p.Reason}
+}
+
+// ShipPlacementUpdate tells the game room a player placed their ships.
+type ShipPlacementUpdate struct {
+	UserID int `json:"user_id"`
+}
+
+func (ShipPlacementUpdate) MessageType() MessageType { return TypeShipPlacementUpdate }
+
+func (p ShipPlacementUpdate) legacy() legacyFields {
+	return legacyFields{userID: p.UserID, message: "ships_placed"}
+}
+
+// MoveRelay passes a client's move message on to the game room.
+type MoveRelay struct {
+	UserID int `json:"user_id"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+}
+
+func (MoveRelay) MessageType() MessageType { return TypeMove }
+
+func (p MoveRelay) legacy() legacyFields {
+	return legacyFields{data: MoveSend{X: p.X, Y: p.Y}, userID: p.UserID}
+}
+
+// GameChat is a new message in a game's chat.
+type GameChat struct {
+	models.ChatMessage
+}
+
+func (GameChat) MessageType() MessageType { return TypeChat }
+
+// LobbyChat is a new message in the lobby chat.
+type LobbyChat struct {
+	models.ChatMessage
+}
+
+func (LobbyChat) MessageType() MessageType { return TypeLobbyChat }
+
+// DirectMessage is a new message in a direct conversation.
+type DirectMessage struct {
+	models.ChatMessage
+}
+
+func (DirectMessage) MessageType() MessageType { return TypeDirectMessage }
+
+// ChatDeleted tells a channel's readers a moderator removed a message.
+type ChatDeleted struct {
+	ID int `json:"id"`
+}
+
+func (ChatDeleted) MessageType() MessageType { return TypeChatDeleted }
+
+// QuickChat is a quick-chat phrase or reaction sent in a game.
+type QuickChat struct {
+	chat.QuickChat
+}
+
+func (QuickChat) MessageType() MessageType { return TypeQuickChat }
+
+// Presence tells a user that a friend's presence changed.
+// Version grows with every change, so a client can drop an update older
+// than the presence it already has.
+type Presence struct {
+	UserID  int    `json:"user_id"`
+	Status  string `json:"status"`
+	GameID  int    `json:"game_id,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+func (Presence) MessageType() MessageType { return TypePresence }
+
+func (p Presence) legacy() legacyFields {
+	return legacyFields{
+		data: struct {
+			Status  string `json:"status"`
+			GameID  int    `json:"game_id,omitempty"`
+			Version uint64 `json:"version,omitempty"`
+		}{p.Status, p.GameID, p.Version},
+		userID: p.UserID,
+	}
+}
+
+// FriendRequest notifies a user of a new friend request.
+type FriendRequest struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func (FriendRequest) MessageType() MessageType { return TypeFriendRequest }
+
+// FriendAccepted notifies a user that a friend request was accepted.
+type FriendAccepted struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func (FriendAccepted) MessageType() MessageType { return TypeFriendAccepted }
+
+// GameInvite notifies a user that a friend challenged them to a game.
+type GameInvite struct {
+	Game     *models.Game `json:"game"`
+	GameID   int          `json:"game_id"`
+	UserID   int          `json:"user_id"`
+	Username string       `json:"username"`
+}
+
+func (GameInvite) MessageType() MessageType { return TypeGameInvite }
+
+// GameInviteDeclined notifies the challenger that an invite was declined.
+type GameInviteDeclined struct {
+	GameID   int    `json:"game_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func (GameInviteDeclined) MessageType() MessageType { return TypeGameInviteDeclined }
+
+// YourTurn notifies a player that it is their turn.
+type YourTurn struct {
+	GameID     int `json:"game_id"`
+	OpponentID int `json:"opponent_id"`
+}
+
+func (YourTurn) MessageType() MessageType { return TypeYourTurn }
+
+// InactivityWarning warns a player that an inactive game will be forfeited
+// against them in about SecondsLeft unless they move or send still_here.
+type InactivityWarning struct {
+	GameID      int `json:"game_id"`
+	SecondsLeft int `json:"seconds_left"`
+}
+
+func (InactivityWarning) MessageType() MessageType { return TypeInactivityWarning }
+
+// Resync tells a client that rejoined a game that its missed events could not
+// be replayed, for example because it fell too far behind. It should reload
+// the game over REST; live events continue after Seq.
+type Resync struct {
+	GameID int   `json:"game_id"`
+	Seq    int64 `json:"seq"`
+}
+
+func (Resync) MessageType() MessageType { return TypeResync }
+
+// Stored is a payload that was serialized earlier, such as a notification
+// read back from the inbox. It is sent as is.
+type Stored struct {
+	Type MessageType
+	Raw  json.RawMessage
+}
+
+func (p Stored) MessageType() MessageType { return p.Type }
+
+func (p Stored) MarshalJSON() ([]byte, error) {
+	if len(p.Raw) == 0 {
+		return []byte("null"), nil
+	}
+	return p.Raw, nil
+}
+
+// ChatSend is the data of a chat message from a client.
+type ChatSend struct {
+	Message string `json:"message"`
+}
+
+// QuickChatSend is the data of a quick_chat or reaction message from a client.
+type QuickChatSend struct {
+	Code string `json:"code"`
+}
+
+// JoinGame is the data of a join_game message from a client. A client that
+// rejoins after a drop sets LastSeq to the last seq it saw, and the events it
+// missed are replayed first.
+type JoinGame struct {
+	GameID  int    `json:"game_id"`
+	LastSeq *int64 `json:"last_seq,omitempty"`
+}
+
+// StillHere is the data of a still_here message from a client. It resets
+// the inactivity timer of the client's game.
+type StillHere struct{}
+
+// MoveSend is the data of a move message from a client.
+type MoveSend struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// ServerMessages has a sample payload for every message the server sends.
+// Schemas are generated from it.
+var ServerMessages = []Payload{
+	Welcome{},
+	Error{},
+	GameCreated{},
+	GameUpdate{},
+	ShipPlacementUpdate{},
+	MoveRelay{},
+	GameChat{},
+	LobbyChat{},
+	DirectMessage{},
+	ChatDeleted{},
+	QuickChat{},
+	Presence{},
+	FriendRequest{},
+	FriendAccepted{},
+	GameInvite{},
+	GameInviteDeclined{},
+	YourTurn{},
+	Resync{},
+	InactivityWarning{},
+}
+
+// ClientMessages maps every message type a client may send to its data.
+var ClientMessages = map[MessageType]interface{}{
+	TypeChat:      ChatSend{},
+	TypeQuickChat: QuickChatSend{},
+	TypeReaction:  QuickChatSend{},
+	TypeJoinGame:  JoinGame{},
+	TypeMove:      MoveSend{},
+	TypeStillHere: StillHere{},
+}
@@ -0,0 +1,237 @@
+// Package protocol defines the WebSocket wire protocol: a versioned envelope,
+// the message types with a typed payload for each, and JSON Schemas generated
+// from those payloads.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Protocol versions. Version 1 is the original untyped format, still spoken
+// to clients that do not ask for a newer one.
+const (
+	Version1       = 1
+	Version2       = 2
+	MinVersion     = Version1
+	CurrentVersion = Version2
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// MessageType identifies a WebSocket message.
+type MessageType string
+
+// Server to client message types
+const (
+	TypeWelcome             MessageType = "welcome"
+	TypeError               MessageType = "error"
+	TypeNewGameCreated      MessageType = "new_game_created"
+	TypeGameUpdate          MessageType = "game_update"
+	TypeShipPlacementUpdate MessageType = "ship_placement_update"
+	TypeLobbyChat           MessageType = "lobby_chat"
+	TypeDirectMessage       MessageType = "direct_message"
+	TypeChatDeleted         MessageType = "chat_deleted"
+	TypePresence            MessageType = "presence"
+	TypeFriendRequest       MessageType = "friend_request"
+	TypeFriendAccepted      MessageType = "friend_accepted"
+	TypeGameInvite          MessageType = "game_invite"
+	TypeGameInviteDeclined  MessageType = "game_invite_declined"
+	TypeYourTurn            MessageType = "your_turn"
+	TypeResync              MessageType = "resync"
+	TypeInactivityWarning   MessageType = "inactivity_warning"
+)
+
+// Message types sent both ways: clients send them and the server relays
+// them to the game room.
+const (
+	TypeChat      MessageType = "chat"
+	TypeQuickChat MessageType = "quick_chat"
+	TypeMove      MessageType = "move"
+)
+
+// Client to server message types
+const (
+	TypeJoinGame  MessageType = "join_game"
+	TypeReaction  MessageType = "reaction"
+	TypeStillHere MessageType = "still_here"
+)
+
+// Payload is the typed data of a server message.
+type Payload interface {
+	MessageType() MessageType
+}
+
+// Envelope is a server message. Its payload decides the type.
+type Envelope struct {
+	Version        int         `json:"v"`
+	Type           MessageType `json:"type"`
+	Seq            int64       `json:"seq,omitempty"` // position in the game's event log
+	GameID         int         `json:"game_id,omitempty"`
+	ChannelID      int         `json:"channel_id,omitempty"`
+	NotificationID int         `json:"notification_id,omitempty"`
+	CreatedAt      *time.Time  `json:"created_at,omitempty"` // set for notifications
+	Data           Payload     `json:"data,omitempty"`
+
+	// Sender is the user whose chat message this is, so that replays skip
+	// recipients who block them. It is never sent.
+	Sender int `json:"-"`
+}
+
+// New wraps a payload in an envelope.
+func New(payload Payload) *Envelope {
+	return &Envelope{Type: payload.MessageType(), Data: payload}
+}
+
+// ForGame sets the game the message is about.
+func (e *Envelope) ForGame(gameID int) *Envelope {
+	e.GameID = gameID
+	return e
+}
+
+// payloadTypes maps server message types to their payload types.
+var payloadTypes = func() map[MessageType]reflect.Type {
+	types := make(map[MessageType]reflect.Type, len(ServerMessages))
+	for _, payload := range ServerMessages {
+		types[payload.MessageType()] = reflect.TypeOf(payload)
+	}
+	return types
+}()
+
+// DecodePayload parses the JSON data of a server message back into its typed
+// payload, e.g. when replaying stored events.
+func DecodePayload(msgType MessageType, data []byte) (Payload, error) {
+	payloadType, ok := payloadTypes[msgType]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %q", msgType)
+	}
+	payload := reflect.New(payloadType)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, payload.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return payload.Elem().Interface().(Payload), nil
+}
+
+// legacyPayload is implemented by payloads whose version 1 form differs from
+// the typed one: version 1 put some fields next to the data.
+type legacyPayload interface {
+	legacy() legacyFields
+}
+
+type legacyFields struct {
+	data    interface{}
+	userID  int
+	message string
+}
+
+// Encode serializes the envelope in the given protocol version.
+func Encode(e *Envelope, version int) ([]byte, error) {
+	switch version {
+	case Version2:
+		typed := *e
+		typed.Version = Version2
+		return json.Marshal(&typed)
+	case Version1:
+		msg := map[string]interface{}{"type": e.Type}
+		if e.Seq != 0 {
+			msg["seq"] = e.Seq
+		}
+		if e.GameID != 0 {
+			msg["game_id"] = e.GameID
+		}
+		if e.ChannelID != 0 {
+			msg["channel_id"] = e.ChannelID
+		}
+		if e.NotificationID != 0 {
+			msg["notification_id"] = e.NotificationID
+		}
+		if e.CreatedAt != nil {
+			msg["created_at"] = e.CreatedAt
+		}
+		if lp, ok := e.Data.(legacyPayload); ok {
+			fields := lp.legacy()
+			if fields.data != nil {
+				msg["data"] = fields.data
+			}
+			if fields.userID != 0 {
+				msg["user_id"] = fields.userID
+			}
+			if fields.message != "" {
+				msg["message"] = fields.message
+			}
+		} else if e.Data != nil {
+			msg["data"] = e.Data
+		}
+		return json.Marshal(msg)
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+}
+
+// Inbound is a message from a client. Version 1 clients put chat text and
+// quick-chat codes in Message and a game ID in Data; version 2 clients send a
+// typed Data object for every type.
+type Inbound struct {
+	Type    MessageType     `json:"type"`
+	GameID  int             `json:"game_id,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// DecodeInbound parses a client message.
+func DecodeInbound(raw []byte) (*Inbound, error) {
+	var in Inbound
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return nil, err
+	}
+	if in.Type == "" {
+		return nil, errors.New("message type is required")
+	}
+	return &in, nil
+}
+
+// Text returns the text of a chat message.
+func (in *Inbound) Text() string {
+	var send ChatSend
+	if json.Unmarshal(in.Data, &send) == nil && send.Message != "" {
+		return send.Message
+	}
+	return in.Message
+}
+
+// Code returns the catalog code of a quick-chat message or reaction.
+func (in *Inbound) Code() string {
+	var send QuickChatSend
+	if json.Unmarshal(in.Data, &send) == nil && send.Code != "" {
+		return send.Code
+	}
+	return in.Message
+}
+
+// JoinGame returns the game a join_game message asks to join. Version 1
+// clients send just the game ID, which never resumes.
+func (in *Inbound) JoinGame() (JoinGame, bool) {
+	var join JoinGame
+	if json.Unmarshal(in.Data, &join) == nil && join.GameID > 0 {
+		return join, true
+	}
+	var gameID float64
+	if json.Unmarshal(in.Data, &gameID) == nil && gameID > 0 {
+		return JoinGame{GameID: int(gameID)}, true
+	}
+	return JoinGame{}, false
+}
+
+// Move returns the coordinates of a move message.
+func (in *Inbound) Move() (MoveSend, bool) {
+	var move MoveSend
+	if in.Data == nil || json.Unmarshal(in.Data, &move) != nil {
+		return move, false
+	}
+	return move, true
+}
@@ -0,0 +1,239 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"battleship-go/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+func TestNegotiate(t *testing.T) {
+	v1 := Format{Version: Version1, Encoding: EncodingJSON}
+	v2 := Format{Version: Version2, Encoding: EncodingJSON}
+	v2Msgpack := Format{Version: Version2, Encoding: EncodingMsgpack}
+
+	tests := []struct {
+		name      string
+		requested string
+		encoding  string
+		offered   []string
+		want      Format
+		wantErr   bool
+	}{
+		{name: "no preference gets version 1", want: v1},
+		{name: "explicit version", requested: "2", want: v2},
+		{name: "explicit version wins over subprotocols", requested: "1", offered: []string{"battleship.v2"}, want: v1},
+		{name: "newest offered subprotocol", offered: []string{"battleship.v1", "battleship.v2"}, want: v2},
+		{name: "unknown subprotocols are skipped", offered: []string{"chat", "battleship.v9", "battleship.v1"}, want: v1},
+		{name: "msgpack subprotocol", offered: []string{"battleship.v2.msgpack", "battleship.v2"}, want: v2Msgpack},
+		{name: "client order breaks ties", offered: []string{"battleship.v2", "battleship.v2.msgpack"}, want: v2},
+		{name: "explicit encoding gets the current version", encoding: "msgpack", want: v2Msgpack},
+		{name: "msgpack needs version 2", requested: "1", encoding: "msgpack", wantErr: true},
+		{name: "unknown encoding", encoding: "protobuf", wantErr: true},
+		{name: "unsupported explicit version", requested: "9", wantErr: true},
+		{name: "malformed explicit version", requested: "latest", wantErr: true},
+		{name: "no supported subprotocol", offered: []string{"battleship.v9", "battleship.v1.msgpack"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := Negotiate(tt.requested, tt.encoding, tt.offered)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnsupportedVersion)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, format)
+		})
+	}
+
+	assert.Equal(t, []string{"battleship.v2.msgpack", "battleship.v2", "battleship.v1"}, Subprotocols())
+}
+
+func TestFormat_Msgpack(t *testing.T) {
+	format := Format{Version: Version2, Encoding: EncodingMsgpack}
+	event := New(GameUpdate{Reason: ReasonMove, Move: &models.Move{ID: 7, X: 4, Y: 5, IsHit: true}}).ForGame(3)
+	event.Seq = 12
+
+	msgBytes, err := format.Encode(event)
+	require.NoError(t, err)
+	jsonBytes, err := Encode(event, Version2)
+	require.NoError(t, err)
+	assert.Less(t, len(msgBytes), len(jsonBytes))
+
+	// It decodes to the JSON form, with integers kept as integers
+	var msg map[string]interface{}
+	require.NoError(t, codec.NewDecoderBytes(msgBytes, msgpackHandle).Decode(&msg))
+	assert.Equal(t, int64(12), msg["seq"])
+	assert.Equal(t, "game_update", msg["type"])
+	move := msg["data"].(map[string]interface{})["move"].(map[string]interface{})
+	assert.Equal(t, int64(4), move["x"])
+	assert.Equal(t, true, move["is_hit"])
+
+	// Client messages are read the same way
+	var inbound []byte
+	require.NoError(t, codec.NewEncoderBytes(&inbound, msgpackHandle).Encode(map[string]interface{}{
+		"type": "chat", "data": map[string]interface{}{"message": "hi"},
+	}))
+	in, err := format.Decode(inbound)
+	require.NoError(t, err)
+	assert.Equal(t, TypeChat, in.Type)
+	assert.Equal(t, "hi", in.Text())
+}
+
+func TestEncode(t *testing.T) {
+	move := &models.Move{ID: 7, GameID: 3, PlayerID: 1, X: 4, Y: 5, IsHit: true}
+	update := New(GameUpdate{Reason: ReasonMove, Move: move}).ForGame(3)
+
+	t.Run("version 2 is the typed envelope", func(t *testing.T) {
+		msgBytes, err := Encode(update, Version2)
+		require.NoError(t, err)
+
+		var msg map[string]interface{}
+		require.NoError(t, json.Unmarshal(msgBytes, &msg))
+		assert.EqualValues(t, 2, msg["v"])
+		assert.Equal(t, "game_update", msg["type"])
+		assert.EqualValues(t, 3, msg["game_id"])
+		data := msg["data"].(map[string]interface{})
+		assert.Equal(t, "move", data["reason"])
+		assert.EqualValues(t, 4, data["move"].(map[string]interface{})["x"])
+	})
+
+	t.Run("version 1 keeps the legacy layout", func(t *testing.T) {
+		msgBytes, err := Encode(update, Version1)
+		require.NoError(t, err)
+
+		var msg map[string]interface{}
+		require.NoError(t, json.Unmarshal(msgBytes, &msg))
+		assert.NotContains(t, msg, "v")
+		assert.EqualValues(t, 4, msg["data"].(map[string]interface{})["x"])
+
+		msgBytes, err = Encode(New(ShipPlacementUpdate{UserID: 2}).ForGame(3), Version1)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type":"ship_placement_update","game_id":3,"user_id":2,"message":"ships_placed"}`, string(msgBytes))
+
+		msgBytes, err = Encode(New(Presence{UserID: 2, Status: "in_game", GameID: 3}), Version1)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type":"presence","user_id":2,"data":{"status":"in_game","game_id":3}}`, string(msgBytes))
+	})
+
+	t.Run("stored payloads are sent as is", func(t *testing.T) {
+		created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		event := New(Stored{Type: TypeYourTurn, Raw: json.RawMessage(`{"game_id":3,"opponent_id":2}`)})
+		event.NotificationID = 9
+		event.CreatedAt = &created
+
+		msgBytes, err := Encode(event, Version1)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type":"your_turn","notification_id":9,"created_at":"2024-01-02T03:04:05Z","data":{"game_id":3,"opponent_id":2}}`, string(msgBytes))
+	})
+
+	t.Run("sequence numbers", func(t *testing.T) {
+		event := New(ShipPlacementUpdate{UserID: 2}).ForGame(3)
+		event.Seq = 12
+		event.Sender = 2
+
+		for _, version := range []int{Version1, Version2} {
+			msgBytes, err := Encode(event, version)
+			require.NoError(t, err)
+			var msg map[string]interface{}
+			require.NoError(t, json.Unmarshal(msgBytes, &msg))
+			assert.EqualValues(t, 12, msg["seq"])
+			assert.NotContains(t, msg, "Sender")
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		_, err := Encode(update, 3)
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+}
+
+func TestDecodeInbound(t *testing.T) {
+	t.Run("version 1 forms", func(t *testing.T) {
+		msg, err := DecodeInbound([]byte(`{"type":"chat","message":"hi"}`))
+		require.NoError(t, err)
+		assert.Equal(t, "hi", msg.Text())
+
+		msg, err = DecodeInbound([]byte(`{"type":"join_game","data":12}`))
+		require.NoError(t, err)
+		join, ok := msg.JoinGame()
+		assert.True(t, ok)
+		assert.Equal(t, JoinGame{GameID: 12}, join)
+	})
+
+	t.Run("version 2 forms", func(t *testing.T) {
+		msg, err := DecodeInbound([]byte(`{"type":"reaction","data":{"code":"wave"}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "wave", msg.Code())
+
+		msg, err = DecodeInbound([]byte(`{"type":"join_game","data":{"game_id":12,"last_seq":40}}`))
+		require.NoError(t, err)
+		join, ok := msg.JoinGame()
+		assert.True(t, ok)
+		assert.Equal(t, 12, join.GameID)
+		require.NotNil(t, join.LastSeq)
+		assert.EqualValues(t, 40, *join.LastSeq)
+
+		msg, err = DecodeInbound([]byte(`{"type":"move","data":{"x":1,"y":2}}`))
+		require.NoError(t, err)
+		move, ok := msg.Move()
+		assert.True(t, ok)
+		assert.Equal(t, MoveSend{X: 1, Y: 2}, move)
+	})
+
+	t.Run("invalid messages", func(t *testing.T) {
+		_, err := DecodeInbound([]byte(`not json`))
+		assert.Error(t, err)
+		_, err = DecodeInbound([]byte(`{"message":"no type"}`))
+		assert.Error(t, err)
+
+		msg, err := DecodeInbound([]byte(`{"type":"join_game"}`))
+		require.NoError(t, err)
+		_, ok := msg.JoinGame()
+		assert.False(t, ok)
+	})
+}
+
+func TestDecodePayload(t *testing.T) {
+	original := GameUpdate{Reason: ReasonMove, Move: &models.Move{ID: 7, X: 4, Y: 5, IsHit: true}}
+	data, err := json.Marshal(original)
+	require.NoError(t, err)
+
+	payload, err := DecodePayload(TypeGameUpdate, data)
+	require.NoError(t, err)
+	assert.Equal(t, original, payload)
+
+	_, err = DecodePayload("unknown", data)
+	assert.Error(t, err)
+}
+
+func TestGenerateSchemas(t *testing.T) {
+	schemas := GenerateSchemas()
+	assert.Len(t, schemas.Server, len(ServerMessages))
+	assert.Len(t, schemas.Client, len(ClientMessages))
+
+	// Every server message type has exactly one payload
+	seen := map[MessageType]bool{}
+	for _, payload := range ServerMessages {
+		assert.False(t, seen[payload.MessageType()], "duplicate payload for %s", payload.MessageType())
+		seen[payload.MessageType()] = true
+	}
+
+	created := schemas.Server[TypeNewGameCreated]["properties"].(Schema)["data"].(Schema)
+	properties := created["properties"].(Schema)
+	assert.Equal(t, Schema{"type": "integer"}, properties["player1_id"], "embedded fields are promoted")
+	assert.Equal(t, Schema{"type": "string", "format": "date-time"}, properties["created_at"])
+	assert.Contains(t, properties["player2_id"].(Schema), "anyOf")
+	assert.Contains(t, created["required"], "status")
+	assert.NotContains(t, created["required"], "chat_mode", "omitempty fields are optional")
+
+	// Schemas are plain JSON
+	_, err := json.Marshal(schemas)
+	assert.NoError(t, err)
+}
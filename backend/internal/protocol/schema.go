@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaDialect is the JSON Schema draft the generated schemas follow.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document.
+type Schema map[string]interface{}
+
+// Schemas describes every message of the current protocol version.
+type Schemas struct {
+	Version int                    `json:"version"`
+	Server  map[MessageType]Schema `json:"server"`
+	Client  map[MessageType]Schema `json:"client"`
+}
+
+// GenerateSchemas builds the schema of every server and client message from
+// the Go types.
+func GenerateSchemas() *Schemas {
+	schemas := &Schemas{
+		Version: CurrentVersion,
+		Server:  make(map[MessageType]Schema, len(ServerMessages)),
+		Client:  make(map[MessageType]Schema, len(ClientMessages)),
+	}
+
+	for _, payload := range ServerMessages {
+		msgType := payload.MessageType()
+		schemas.Server[msgType] = Schema{
+			"$schema": schemaDialect,
+			"title":   string(msgType),
+			"type":    "object",
+			"properties": Schema{
+				"v":               Schema{"const": CurrentVersion},
+				"type":            Schema{"const": msgType},
+				"seq":             Schema{"type": "integer"},
+				"game_id":         Schema{"type": "integer"},
+				"channel_id":      Schema{"type": "integer"},
+				"notification_id": Schema{"type": "integer"},
+				"created_at":      Schema{"type": "string", "format": "date-time"},
+				"data":            TypeSchema(reflect.TypeOf(payload)),
+			},
+			"required": []string{"v", "type", "data"},
+		}
+	}
+
+	for msgType, data := range ClientMessages {
+		schemas.Client[msgType] = Schema{
+			"$schema": schemaDialect,
+			"title":   string(msgType),
+			"type":    "object",
+			"properties": Schema{
+				"type":    Schema{"const": msgType},
+				"game_id": Schema{"type": "integer"},
+				"data":    TypeSchema(reflect.TypeOf(data)),
+			},
+			"required": []string{"type", "data"},
+		}
+	}
+
+	return schemas
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// TypeSchema returns the JSON Schema of the JSON encoding of a Go type.
+func TypeSchema(t reflect.Type) Schema {
+	switch t {
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return Schema{"anyOf": []Schema{TypeSchema(t.Elem()), {"type": "null"}}}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": TypeSchema(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": TypeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		required := []string{}
+		addFields(t, properties, &required)
+		return Schema{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	default:
+		return Schema{}
+	}
+}
+
+// addFields adds the JSON fields of a struct, including those promoted from
+// embedded structs, to a schema's properties.
+func addFields(t reflect.Type, properties Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = TypeSchema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
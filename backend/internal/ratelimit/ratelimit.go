@@ -0,0 +1,134 @@
+// Package ratelimit implements token bucket rate limiting with pluggable
+// storage, so that limits can be shared between server instances.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy describes a token bucket: up to Burst requests at once, with one
+// token regained every Interval.
+type Policy struct {
+	Name     string
+	Burst    int
+	Interval time.Duration
+}
+
+// rate returns the refill rate in tokens per second.
+func (p Policy) rate() float64 {
+	return 1 / p.Interval.Seconds()
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token is available when the
+	// request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps bucket state. Implementations must take a token atomically.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error)
+}
+
+// Limiter checks requests against policies stored in a Store.
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes a token from the policy's bucket for key.
+func (l *Limiter) Allow(ctx context.Context, policy Policy, key string) (Result, error) {
+	return l.store.Take(ctx, policy.Name+":"+key, policy, l.now())
+}
+
+// Bucket is a single token bucket. It is not safe for concurrent use; it is
+// meant for state owned by one goroutine, such as a WebSocket connection.
+type Bucket struct {
+	policy  Policy
+	tokens  float64
+	updated time.Time
+}
+
+// NewBucket creates a full bucket.
+func NewBucket(policy Policy) *Bucket {
+	return &Bucket{policy: policy, tokens: float64(policy.Burst)}
+}
+
+// Take refills the bucket for the time elapsed since the last call and takes a token if one is available.
+func (b *Bucket) Take(now time.Time) Result {
+	tokens, allowed := refill(b.policy, b.tokens, b.updated, now)
+	b.tokens = tokens
+	if now.After(b.updated) {
+		b.updated = now
+	}
+	return result(b.policy, tokens, allowed)
+}
+
+// refill returns the bucket's tokens after refilling and taking one, and
+// whether a token was taken.
+func refill(policy Policy, tokens float64, updated, now time.Time) (float64, bool) {
+	if !updated.IsZero() && now.After(updated) {
+		tokens = math.Min(float64(policy.Burst), tokens+now.Sub(updated).Seconds()*policy.rate())
+	}
+	if tokens >= 1 {
+		return tokens - 1, true
+	}
+	return tokens, false
+}
+
+func result(policy Policy, tokens float64, allowed bool) Result {
+	res := Result{Allowed: allowed, Remaining: int(tokens)}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / policy.rate() * float64(time.Second))
+	}
+	return res
+}
+
+// idleBucketTTL is how long an unused bucket is kept. Any bucket idle for
+// longer would be full again, so forgetting it changes nothing.
+const idleBucketTTL = time.Hour
+
+// pruneEvery is the number of takes between sweeps of idle buckets.
+const pruneEvery = 1000
+
+// MemoryStore keeps buckets in process memory. Limits are per instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+	takes   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*Bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%pruneEvery == 0 {
+		for k, bucket := range s.buckets {
+			if now.Sub(bucket.updated) > idleBucketTTL {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = NewBucket(policy)
+		s.buckets[key] = bucket
+	}
+	return bucket.Take(now), nil
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = Policy{Name: "test", Burst: 3, Interval: time.Second}
+
+func TestBucket(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	bucket := NewBucket(testPolicy)
+
+	for i := 0; i < 3; i++ {
+		res := bucket.Take(start)
+		require.True(t, res.Allowed)
+		assert.Equal(t, 2-i, res.Remaining)
+	}
+
+	res := bucket.Take(start)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	res = bucket.Take(start.Add(500 * time.Millisecond))
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	assert.True(t, bucket.Take(start.Add(time.Second)).Allowed)
+
+	// Refill is capped at the burst size
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, bucket.Take(later).Allowed)
+	}
+	assert.False(t, bucket.Take(later).Allowed)
+}
+
+// testStore runs the same scenario against any Store implementation.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		res, err := store.Take(ctx, "a", testPolicy, start)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+
+	res, err := store.Take(ctx, "a", testPolicy, start)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.InDelta(t, time.Second, res.RetryAfter, float64(time.Millisecond))
+
+	// Keys are independent
+	res, err = store.Take(ctx, "b", testPolicy, start)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining)
+
+	// Rejected requests do not use up tokens
+	res, err = store.Take(ctx, "a", testPolicy, start.Add(time.Second))
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// A clock running behind does not drain the bucket
+	res, err = store.Take(ctx, "b", testPolicy, start.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE rate_limit_buckets (
+			bucket_key TEXT PRIMARY KEY,
+			tokens DOUBLE PRECISION NOT NULL,
+			allowed INTEGER NOT NULL,
+			refilled_at DOUBLE PRECISION NOT NULL
+		)`)
+	require.NoError(t, err)
+
+	testStore(t, NewSQLStore(db))
+}
+
+func TestLimiter_NamespacesPolicies(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore())
+	other := Policy{Name: "other", Burst: 1, Interval: time.Minute}
+	ctx := context.Background()
+
+	res, err := limiter.Allow(ctx, other, "ip:1.2.3.4")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = limiter.Allow(ctx, other, "ip:1.2.3.4")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	res, err = limiter.Allow(ctx, testPolicy, "ip:1.2.3.4")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// SQLStore keeps buckets in the rate_limit_buckets table so that every server
+// instance shares the same limits. Each take is a single upsert, which makes
+// it atomic without explicit locking.
+type SQLStore struct {
+	db    *sql.DB
+	takes atomic.Int64
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// Times are stored as Unix seconds so that the arithmetic is the same on
+// every database. Clocks of different instances may disagree slightly, so a
+// bucket never refills backwards.
+const (
+	sqlNow     = `CAST($3 AS DOUBLE PRECISION)`
+	sqlElapsed = `CASE WHEN ` + sqlNow + ` > rate_limit_buckets.refilled_at THEN ` + sqlNow + ` - rate_limit_buckets.refilled_at ELSE 0 END`
+	// sqlRefilled is the bucket's token count after refilling, capped at the burst size ($2)
+	sqlRefilled = `CASE
+        WHEN rate_limit_buckets.tokens + ` + sqlElapsed + ` * CAST($4 AS DOUBLE PRECISION) > CAST($2 AS DOUBLE PRECISION)
+        THEN CAST($2 AS DOUBLE PRECISION)
+        ELSE rate_limit_buckets.tokens + ` + sqlElapsed + ` * CAST($4 AS DOUBLE PRECISION)
+    END`
+)
+
+const takeQuery = `
+INSERT INTO rate_limit_buckets (bucket_key, tokens, allowed, refilled_at)
+VALUES ($1, CAST($2 AS DOUBLE PRECISION) - 1, 1, ` + sqlNow + `)
+ON CONFLICT (bucket_key) DO UPDATE SET
+    tokens = CASE WHEN ` + sqlRefilled + ` >= 1 THEN ` + sqlRefilled + ` - 1 ELSE ` + sqlRefilled + ` END,
+    allowed = CASE WHEN ` + sqlRefilled + ` >= 1 THEN 1 ELSE 0 END,
+    refilled_at = CASE WHEN ` + sqlNow + ` > rate_limit_buckets.refilled_at THEN ` + sqlNow + ` ELSE rate_limit_buckets.refilled_at END
+RETURNING tokens, allowed`
+
+func (s *SQLStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	if s.takes.Add(1)%pruneEvery == 0 {
+		if err := s.prune(ctx, now); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var tokens float64
+	var allowed int
+	err := s.db.QueryRowContext(ctx, takeQuery, key, float64(policy.Burst), unixSeconds(now), policy.rate()).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(policy, tokens, allowed == 1), nil
+}
+
+// prune removes buckets that have been idle long enough to be full again.
+func (s *SQLStore) prune(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE refilled_at < $1",
+		unixSeconds(now.Add(-idleBucketTTL)))
+	return err
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,366 @@
+// Package social manages friendships, blocks and chat mutes between users.
+package social
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Friendship statuses
+const (
+	FriendshipPending  = "pending"
+	FriendshipAccepted = "accepted"
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrSelf            = errors.New("cannot do this with yourself")
+	ErrBlocked         = errors.New("user is blocked")
+	ErrAlreadyFriends  = errors.New("already friends")
+	ErrRequestExists   = errors.New("friend request already sent")
+	ErrRequestNotFound = errors.New("friend request not found")
+	ErrNotFriends      = errors.New("not friends")
+)
+
+// Friend is another user connected to the current one.
+type Friend struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+// FriendRequest is a pending request, seen from the current user's side.
+type FriendRequest struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Incoming  bool      `json:"incoming"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlockedUser is a user the current user has blocked.
+type BlockedUser struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SocialService struct {
+	db *sql.DB
+}
+
+func NewSocialService(db *sql.DB) *SocialService {
+	return &SocialService{db: db}
+}
+
+// FindUser returns the ID of the full, non-deleted account with the given username.
+func (s *SocialService) FindUser(username string) (int, error) {
+	var id int
+	err := s.db.QueryRow(`
+		SELECT id FROM users
+		WHERE username = $1 AND is_guest = FALSE AND deleted_at IS NULL`, username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return id, err
+}
+
+// userExists reports whether userID is a non-deleted account. Guests only
+// count when includeGuests is set.
+func (s *SocialService) userExists(userID int, includeGuests bool) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users
+		WHERE id = $1 AND (is_guest = FALSE OR $2) AND deleted_at IS NULL)`, userID, includeGuests).Scan(&exists)
+	return exists, err
+}
+
+// SendFriendRequest asks targetID to become userID's friend. If targetID has
+// already asked userID, the two simply become friends. It returns whether the
+// friendship is now accepted.
+func (s *SocialService) SendFriendRequest(userID, targetID int) (bool, error) {
+	if userID == targetID {
+		return false, ErrSelf
+	}
+
+	exists, err := s.userExists(targetID, false)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrUserNotFound
+	}
+
+	blocked, err := s.IsBlocked(userID, targetID)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	var requesterID int
+	var status string
+	err = s.db.QueryRow(`
+		SELECT requester_id, status FROM friendships
+		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)`,
+		userID, targetID).Scan(&requesterID, &status)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = s.db.Exec(`
+			INSERT INTO friendships (requester_id, addressee_id, status) VALUES ($1, $2, $3)`,
+			userID, targetID, FriendshipPending)
+		return false, err
+	case err != nil:
+		return false, err
+	case status == FriendshipAccepted:
+		return false, ErrAlreadyFriends
+	case requesterID == userID:
+		return false, ErrRequestExists
+	default:
+		return true, s.AcceptFriendRequest(userID, targetID)
+	}
+}
+
+// AcceptFriendRequest accepts the pending request requesterID sent to userID.
+func (s *SocialService) AcceptFriendRequest(userID, requesterID int) error {
+	result, err := s.db.Exec(`
+		UPDATE friendships SET status = $1, responded_at = $2
+		WHERE requester_id = $3 AND addressee_id = $4 AND status = $5`,
+		FriendshipAccepted, time.Now(), requesterID, userID, FriendshipPending)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
+// CancelFriendRequest declines an incoming request or withdraws an outgoing one.
+func (s *SocialService) CancelFriendRequest(userID, otherID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM friendships
+		WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
+		  AND status = $3`, userID, otherID, FriendshipPending)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
+// RemoveFriend ends a friendship.
+func (s *SocialService) RemoveFriend(userID, friendID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM friendships
+		WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
+		  AND status = $3`, userID, friendID, FriendshipAccepted)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFriends
+	}
+	return nil
+}
+
+// AreFriends reports whether two users are friends.
+func (s *SocialService) AreFriends(userID, otherID int) (bool, error) {
+	var friends bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM friendships
+		WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
+		  AND status = $3)`, userID, otherID, FriendshipAccepted).Scan(&friends)
+	return friends, err
+}
+
+// ListFriends returns userID's friends ordered by username.
+func (s *SocialService) ListFriends(userID int) ([]Friend, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, f.responded_at
+		FROM friendships f
+		JOIN users u ON u.id = CASE WHEN f.requester_id = $1 THEN f.addressee_id ELSE f.requester_id END
+		WHERE (f.requester_id = $1 OR f.addressee_id = $1) AND f.status = $2
+		ORDER BY u.username`, userID, FriendshipAccepted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	friends := make([]Friend, 0)
+	for rows.Next() {
+		var friend Friend
+		if err := rows.Scan(&friend.UserID, &friend.Username, &friend.Since); err != nil {
+			return nil, err
+		}
+		friends = append(friends, friend)
+	}
+	return friends, rows.Err()
+}
+
+// FriendIDs returns the IDs of userID's friends.
+func (s *SocialService) FriendIDs(userID int) ([]int, error) {
+	rows, err := s.db.Query(`
+		SELECT CASE WHEN requester_id = $1 THEN addressee_id ELSE requester_id END
+		FROM friendships
+		WHERE (requester_id = $1 OR addressee_id = $1) AND status = $2`, userID, FriendshipAccepted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListFriendRequests returns userID's pending incoming and outgoing requests, newest first.
+func (s *SocialService) ListFriendRequests(userID int) ([]FriendRequest, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, f.addressee_id = $1, f.created_at
+		FROM friendships f
+		JOIN users u ON u.id = CASE WHEN f.requester_id = $1 THEN f.addressee_id ELSE f.requester_id END
+		WHERE (f.requester_id = $1 OR f.addressee_id = $1) AND f.status = $2
+		ORDER BY f.created_at DESC, f.id DESC`, userID, FriendshipPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]FriendRequest, 0)
+	for rows.Next() {
+		var request FriendRequest
+		if err := rows.Scan(&request.UserID, &request.Username, &request.Incoming, &request.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+// Block stops targetID from interacting with userID and ends any friendship
+// or pending request between them. Guests can be blocked too.
+func (s *SocialService) Block(userID, targetID int) error {
+	if userID == targetID {
+		return ErrSelf
+	}
+
+	exists, err := s.userExists(targetID, true)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM friendships
+		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)`,
+		userID, targetID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_blocks (blocker_id, blocked_id) VALUES ($1, $2)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING`, userID, targetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unblock lifts a block.
+func (s *SocialService) Unblock(userID, targetID int) error {
+	result, err := s.db.Exec("DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2", userID, targetID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (s *SocialService) IsBlocked(userID, otherID int) (bool, error) {
+	var blocked bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_blocks
+		WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1))`,
+		userID, otherID).Scan(&blocked)
+	return blocked, err
+}
+
+// BlockedWith returns the users who blocked userID or were blocked by them.
+func (s *SocialService) BlockedWith(userID int) (map[int]bool, error) {
+	rows, err := s.db.Query(`
+		SELECT blocked_id FROM user_blocks WHERE blocker_id = $1
+		UNION SELECT blocker_id FROM user_blocks WHERE blocked_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		blocked[id] = true
+	}
+	return blocked, rows.Err()
+}
+
+// MutedUntil returns when a user's chat mute expires, or nil if they are not muted.
+func (s *SocialService) MutedUntil(userID int) (*time.Time, error) {
+	var mutedUntil *time.Time
+	err := s.db.QueryRow("SELECT muted_until FROM users WHERE id = $1", userID).Scan(&mutedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if mutedUntil == nil || !mutedUntil.After(time.Now()) {
+		return nil, nil
+	}
+	return mutedUntil, nil
+}
+
+// ListBlocked returns the users userID has blocked.
+func (s *SocialService) ListBlocked(userID int) ([]BlockedUser, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, b.created_at
+		FROM user_blocks b JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY u.username`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := make([]BlockedUser, 0)
+	for rows.Next() {
+		var user BlockedUser
+		if err := rows.Scan(&user.UserID, &user.Username, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, user)
+	}
+	return blocked, rows.Err()
+}
@@ -0,0 +1,175 @@
+package social
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT UNIQUE NOT NULL,
+			is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+			deleted_at DATETIME,
+			muted_until DATETIME
+		);
+
+		CREATE TABLE friendships (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			requester_id INTEGER NOT NULL,
+			addressee_id INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			responded_at DATETIME,
+			UNIQUE (requester_id, addressee_id)
+		);
+
+		CREATE TABLE user_blocks (
+			blocker_id INTEGER NOT NULL,
+			blocked_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_id, blocked_id)
+		);
+
+		INSERT INTO users (id, username) VALUES (1, 'alice'), (2, 'bob'), (3, 'carol');
+		INSERT INTO users (id, username, is_guest) VALUES (4, 'guest000001', TRUE);
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestSocialService_FriendRequests(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewSocialService(db)
+
+	_, err := service.SendFriendRequest(1, 1)
+	assert.ErrorIs(t, err, ErrSelf)
+	_, err = service.SendFriendRequest(1, 4)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	accepted, err := service.SendFriendRequest(1, 2)
+	require.NoError(t, err)
+	assert.False(t, accepted)
+
+	_, err = service.SendFriendRequest(1, 2)
+	assert.ErrorIs(t, err, ErrRequestExists)
+
+	requests, err := service.ListFriendRequests(2)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "alice", requests[0].Username)
+	assert.True(t, requests[0].Incoming)
+
+	// Only the addressee can accept
+	assert.ErrorIs(t, service.AcceptFriendRequest(1, 2), ErrRequestNotFound)
+	require.NoError(t, service.AcceptFriendRequest(2, 1))
+
+	_, err = service.SendFriendRequest(2, 1)
+	assert.ErrorIs(t, err, ErrAlreadyFriends)
+
+	friends, err := service.ListFriends(1)
+	require.NoError(t, err)
+	require.Len(t, friends, 1)
+	assert.Equal(t, "bob", friends[0].Username)
+
+	ids, err := service.FriendIDs(2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, ids)
+
+	t.Run("crossed requests become a friendship", func(t *testing.T) {
+		_, err := service.SendFriendRequest(3, 1)
+		require.NoError(t, err)
+
+		accepted, err := service.SendFriendRequest(1, 3)
+		require.NoError(t, err)
+		assert.True(t, accepted)
+
+		friends, err := service.AreFriends(3, 1)
+		require.NoError(t, err)
+		assert.True(t, friends)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, service.RemoveFriend(2, 1))
+		assert.ErrorIs(t, service.RemoveFriend(2, 1), ErrNotFriends)
+	})
+}
+
+func TestSocialService_Block(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewSocialService(db)
+
+	_, err := service.SendFriendRequest(1, 2)
+	require.NoError(t, err)
+	require.NoError(t, service.AcceptFriendRequest(2, 1))
+
+	require.NoError(t, service.Block(2, 1))
+	require.NoError(t, service.Block(2, 1), "blocking twice is harmless")
+
+	friends, err := service.AreFriends(1, 2)
+	require.NoError(t, err)
+	assert.False(t, friends)
+
+	// Neither side can send a request while the block stands
+	_, err = service.SendFriendRequest(1, 2)
+	assert.ErrorIs(t, err, ErrBlocked)
+	_, err = service.SendFriendRequest(2, 1)
+	assert.ErrorIs(t, err, ErrBlocked)
+
+	blocked, err := service.ListBlocked(2)
+	require.NoError(t, err)
+	require.Len(t, blocked, 1)
+	assert.Equal(t, "alice", blocked[0].Username)
+
+	for _, userID := range []int{1, 2} {
+		with, err := service.BlockedWith(userID)
+		require.NoError(t, err)
+		assert.Equal(t, map[int]bool{3 - userID: true}, with, "blocks count in both directions")
+	}
+
+	require.NoError(t, service.Unblock(2, 1))
+	assert.Error(t, service.Unblock(2, 1))
+
+	// Guests cannot be befriended but can be blocked
+	require.NoError(t, service.Block(1, 4))
+	assert.ErrorIs(t, service.Block(1, 99), ErrUserNotFound)
+
+	_, err = service.SendFriendRequest(1, 2)
+	assert.NoError(t, err)
+}
+
+func TestSocialService_MutedUntil(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	service := NewSocialService(db)
+
+	mutedUntil, err := service.MutedUntil(1)
+	require.NoError(t, err)
+	assert.Nil(t, mutedUntil)
+
+	_, err = db.Exec("UPDATE users SET muted_until = $1 WHERE id = 1", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	mutedUntil, err = service.MutedUntil(1)
+	require.NoError(t, err)
+	assert.NotNil(t, mutedUntil)
+
+	// Expired mutes no longer apply
+	_, err = db.Exec("UPDATE users SET muted_until = $1 WHERE id = 1", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	mutedUntil, err = service.MutedUntil(1)
+	require.NoError(t, err)
+	assert.Nil(t, mutedUntil)
+}
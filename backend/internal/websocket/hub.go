@@ -1,146 +1,521 @@
 package websocket
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"battleship-go/internal/protocol"
+	"battleship-go/internal/ratelimit"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 )
 
+// messagePolicy limits how fast a single connection may send messages.
+var messagePolicy = ratelimit.Policy{Name: "ws_message", Burst: 20, Interval: 250 * time.Millisecond}
+
+// maxThrottledMessages is the number of consecutive messages over the limit
+// after which the connection is closed.
+const maxThrottledMessages = 50
+
+// KeepAlive controls how the hub detects dead peers and slow readers.
+type KeepAlive struct {
+	// PingInterval is how often each client is pinged.
+	PingInterval time.Duration
+	// PongTimeout is how long a client may send nothing, not even a pong,
+	// before its connection is closed. It must be longer than PingInterval.
+	PongTimeout time.Duration
+	// WriteTimeout bounds every write to a client.
+	WriteTimeout time.Duration
+	// MaxMessageSize is the largest message in bytes a client may send.
+	MaxMessageSize int64
+	// SendQueueSize is the number of messages a client may have waiting
+	// before the slow consumer policy applies.
+	SendQueueSize int
+	// SlowConsumer is what happens when a client's send queue is full.
+	SlowConsumer SlowConsumerPolicy
+}
+
+// DefaultKeepAlive is used unless SetKeepAlive is called.
+var DefaultKeepAlive = KeepAlive{
+	PingInterval:   30 * time.Second,
+	PongTimeout:    60 * time.Second,
+	WriteTimeout:   10 * time.Second,
+	MaxMessageSize: 8192,
+	SendQueueSize:  sendQueueSize,
+	SlowConsumer:   Coalesce,
+}
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow connections from any origin in development
 	},
 }
 
+// EventLog numbers and stores the events of each game so clients that
+// reconnect can be sent what they missed.
+type EventLog interface {
+	// Append sets the event's Seq and stores it.
+	Append(gameID int, envelope *protocol.Envelope) error
+	// Since returns the events after afterSeq, or an error when the client
+	// has to reload the game instead.
+	Since(gameID int, afterSeq int64) ([]*protocol.Envelope, error)
+	LastSeq(gameID int) (int64, error)
+}
+
 type Hub struct {
+	// clientsMu guards clients, which only Run changes.
+	clientsMu  sync.RWMutex
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan *protocol.Envelope
 	register   chan *Client
 	unregister chan *Client
-	gameRooms  map[int]map[*Client]bool // gameID -> clients
+	// rooms guards gameRooms, subscribers and gameLocks. It is only held
+	// while queueing, never across the event log.
+	rooms       sync.Mutex
+	gameRooms   map[int]map[*Client]bool       // gameID -> clients
+	subscribers map[int]map[*Subscription]bool // gameID -> event stream subscribers
+	// gameLocks order the events of each game: broadcasts hold a game's lock
+	// while numbering and delivering, so a replay never interleaves with
+	// live events of the same game.
+	gameLocks   map[int]*gameLock
+	events      EventLog
+	presence    *presenceTracker
+	onPresence  func(userID int, presence Presence)
+	chatFilter  func(senderID int) func(recipientID int) bool
+	onChat      func(userID, gameID int, text string)
+	onQuick     func(userID, gameID int, kind, code string)
+	onConnect   func(userID int) []*protocol.Envelope
+	onUnwritten func(userID int, notificationIDs []int)
+	onStillHere func(userID, gameID int)
+	keepAlive   KeepAlive
+
+	onGameConnection func(userID, gameID int, connected bool)
+	// presenceEvents calls the presence and game connection handlers in
+	// the order of the changes.
+	presenceEvents dispatcher
+
+	dropped         atomic.Uint64 // messages dropped for slow clients
+	slowDisconnects atomic.Uint64 // clients disconnected for being slow
 }
 
 type Client struct {
 	hub    *Hub
 	conn   *websocket.Conn
-	send   chan []byte
+	queue  *sendQueue
 	userID int
 	gameID int
+	lobby  bool
+	format protocol.Format // negotiated protocol version and encoding
 }
 
-type Message struct {
-	Type    string      `json:"type"`
-	GameID  int         `json:"game_id,omitempty"`
-	UserID  int         `json:"user_id,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Message string      `json:"message,omitempty"`
+func NewHub() *Hub {
+	h := &Hub{
+		clients:     make(map[*Client]bool),
+		broadcast:   make(chan *protocol.Envelope),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		gameRooms:   make(map[int]map[*Client]bool),
+		subscribers: make(map[int]map[*Subscription]bool),
+		gameLocks:   make(map[int]*gameLock),
+		keepAlive:   DefaultKeepAlive,
+	}
+	h.presence = newPresenceTracker(h.presenceChanged)
+	return h
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		gameRooms:  make(map[int]map[*Client]bool),
+// SetEventLog makes the hub number game events and replay them to clients
+// that rejoin with the last sequence number they saw. It must be set before
+// clients connect.
+func (h *Hub) SetEventLog(events EventLog) {
+	h.events = events
+}
+
+// SetKeepAlive sets the ping, timeout, message size and send queue settings
+// of new connections. A zero SendQueueSize keeps the default. It must be set
+// before clients connect.
+func (h *Hub) SetKeepAlive(keepAlive KeepAlive) {
+	if keepAlive.SendQueueSize <= 0 {
+		keepAlive.SendQueueSize = sendQueueSize
 	}
+	h.keepAlive = keepAlive
 }
 
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
+			// The client already joined its game room in HandleWebSocket
+			h.clientsMu.Lock()
 			h.clients[client] = true
-			if client.gameID > 0 {
-				if h.gameRooms[client.gameID] == nil {
-					h.gameRooms[client.gameID] = make(map[*Client]bool)
-				}
-				h.gameRooms[client.gameID][client] = true
-			}
-			log.Printf("Client registered: UserID %d, GameID %d", client.userID, client.gameID)
+			h.clientsMu.Unlock()
+			// Presence was tracked in HandleWebSocket: the read pump may
+			// already be moving the client to another game
+			log.Printf("Client registered: UserID %d", client.userID)
 
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
+			h.clientsMu.Lock()
+			_, ok := h.clients[client]
+			delete(h.clients, client)
+			h.clientsMu.Unlock()
+			if ok {
 				// Remove from game room first
-				if client.gameID > 0 && h.gameRooms[client.gameID] != nil {
-					delete(h.gameRooms[client.gameID], client)
-					if len(h.gameRooms[client.gameID]) == 0 {
-						delete(h.gameRooms, client.gameID)
-					}
-				}
-				// Close the send channel to signal writePump to exit
-				close(client.send)
+				h.rooms.Lock()
+				h.leaveRoomLocked(client)
+				h.untrackPresence(client)
+				// Close the send queue to signal writePump to exit
+				client.queue.shutdown()
+				h.rooms.Unlock()
 				log.Printf("Client unregistered: UserID %d, GameID %d", client.userID, client.gameID)
 			}
 
-		case message := <-h.broadcast:
+		case envelope := <-h.broadcast:
+			msg := newOutgoing(envelope)
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					// Client's send channel is full or closed, unregister the client
-					log.Printf("Failed to send to client UserID %d, unregistering", client.userID)
-					h.unregister <- client
-				}
+				h.deliver(client, msg)
 			}
 		}
 	}
 }
 
-func (h *Hub) BroadcastToGame(gameID int, message []byte) {
-	if gameClients, exists := h.gameRooms[gameID]; exists {
-		for client := range gameClients {
-			select {
-			case client.send <- message:
-			default:
-				// Client's send channel is full or closed, unregister the client
-				log.Printf("Failed to send to client UserID %d, unregistering", client.userID)
-				h.unregister <- client
-			}
+// outgoing is a message being delivered. It is encoded at most once per
+// protocol format however many clients receive it.
+type outgoing struct {
+	envelope *protocol.Envelope
+	encoded  map[protocol.Format][]byte
+	critical bool
+	key      string
+}
+
+func newOutgoing(envelope *protocol.Envelope) *outgoing {
+	critical, key := classify(envelope)
+	return &outgoing{envelope: envelope, encoded: make(map[protocol.Format][]byte), critical: critical, key: key}
+}
+
+func (o *outgoing) bytes(format protocol.Format) ([]byte, error) {
+	if msgBytes, ok := o.encoded[format]; ok {
+		return msgBytes, nil
+	}
+	msgBytes, err := format.Encode(o.envelope)
+	if err != nil {
+		return nil, err
+	}
+	o.encoded[format] = msgBytes
+	return msgBytes, nil
+}
+
+// deliver queues a message for a client in the client's protocol format.
+func (h *Hub) deliver(client *Client, msg *outgoing) {
+	msgBytes, err := msg.bytes(client.format)
+	if err != nil {
+		log.Printf("Failed to encode %s for UserID %d: %v", msg.envelope.Type, client.userID, err)
+		return
+	}
+	dropped, ok := client.queue.push(queuedMessage{
+		data:           msgBytes,
+		critical:       msg.critical,
+		key:            msg.key,
+		notificationID: msg.envelope.NotificationID,
+	})
+	if dropped {
+		h.dropped.Add(1)
+	}
+	if !ok {
+		// Closing the connection ends the readPump, which unregisters the
+		// client. Unregistering here could block: the caller may hold the
+		// rooms lock the hub needs for it.
+		log.Printf("Send queue of UserID %d is full, disconnecting", client.userID)
+		h.slowDisconnects.Add(1)
+		client.queue.abort(slowConsumerClose)
+	}
+}
+
+// BroadcastToGame sends a message to the game room. With an event log the
+// message is numbered and stored first.
+func (h *Hub) BroadcastToGame(gameID int, envelope *protocol.Envelope) {
+	h.broadcastToGame(gameID, 0, envelope)
+}
+
+// broadcastToGame delivers a game event to the game room and its event
+// stream subscribers. With a non-zero senderID, recipients the chat filter
+// rejects are skipped.
+func (h *Hub) broadcastToGame(gameID, senderID int, envelope *protocol.Envelope) {
+	allowed := h.recipients(senderID)
+	unlock := h.lockGame(gameID)
+	defer unlock()
+
+	envelope.Sender = senderID
+	h.record(gameID, envelope)
+	msg := newOutgoing(envelope)
+
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+	for client := range h.gameRooms[gameID] {
+		if allowed(client.userID) {
+			h.deliver(client, msg)
 		}
 	}
+	for sub := range h.subscribers[gameID] {
+		if allowed(sub.userID) {
+			h.notifyLocked(sub, envelope)
+		}
+	}
+}
+
+// gameLock is the lock of one game, kept while anyone holds or waits for it.
+type gameLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockGame takes the lock of a game and returns the function releasing it.
+func (h *Hub) lockGame(gameID int) func() {
+	h.rooms.Lock()
+	lock := h.gameLocks[gameID]
+	if lock == nil {
+		lock = &gameLock{}
+		h.gameLocks[gameID] = lock
+	}
+	lock.refs++
+	h.rooms.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		h.rooms.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(h.gameLocks, gameID)
+		}
+		h.rooms.Unlock()
+	}
+}
+
+// recipients applies the chat filter to messages from senderID. Messages
+// without a sender reach everyone.
+func (h *Hub) recipients(senderID int) func(recipientID int) bool {
+	if senderID == 0 || h.chatFilter == nil {
+		return func(int) bool { return true }
+	}
+	return h.chatFilter(senderID)
+}
+
+// record numbers and stores a game event. Failing to store it only costs
+// replay, so the event is still delivered.
+func (h *Hub) record(gameID int, envelope *protocol.Envelope) {
+	envelope.GameID = gameID
+	if h.events == nil {
+		return
+	}
+	if err := h.events.Append(gameID, envelope); err != nil {
+		log.Printf("Failed to record %s event for game %d: %v", envelope.Type, gameID, err)
+	}
+}
+
+// joinRoom moves a client into a game room. With lastSeq set, the events the
+// client missed since then are queued first.
+func (h *Hub) joinRoom(c *Client, gameID int, lastSeq *int64) {
+	unlock := h.lockGame(gameID)
+	defer unlock()
+
+	var missed []*protocol.Envelope
+	if lastSeq != nil {
+		missed = h.missed(c.userID, gameID, *lastSeq)
+	}
+
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+	if c.gameID != gameID {
+		h.leaveRoomLocked(c)
+	}
+	c.gameID = gameID
+	for _, envelope := range missed {
+		h.deliver(c, newOutgoing(envelope))
+	}
+	if h.gameRooms[gameID] == nil {
+		h.gameRooms[gameID] = make(map[*Client]bool)
+	}
+	h.gameRooms[gameID][c] = true
+}
+
+func (h *Hub) leaveRoomLocked(c *Client) {
+	if c.gameID > 0 && h.gameRooms[c.gameID] != nil {
+		delete(h.gameRooms[c.gameID], c)
+		if len(h.gameRooms[c.gameID]) == 0 {
+			delete(h.gameRooms, c.gameID)
+		}
+	}
+}
+
+// missed returns the game events after afterSeq a user may see, or a resync
+// message when they cannot be replayed. The caller holds the game's lock.
+func (h *Hub) missed(userID, gameID int, afterSeq int64) []*protocol.Envelope {
+	if h.events == nil {
+		return nil
+	}
+	missed, err := h.events.Since(gameID, afterSeq)
+	if err != nil {
+		lastSeq, _ := h.events.LastSeq(gameID)
+		log.Printf("Cannot replay game %d after seq %d for UserID %d: %v", gameID, afterSeq, userID, err)
+		return []*protocol.Envelope{protocol.New(protocol.Resync{GameID: gameID, Seq: lastSeq}).ForGame(gameID)}
+	}
+
+	allowed := missed[:0:0]
+	filters := make(map[int]func(int) bool)
+	for _, envelope := range missed {
+		filter, ok := filters[envelope.Sender]
+		if !ok {
+			filter = h.recipients(envelope.Sender)
+			filters[envelope.Sender] = filter
+		}
+		if filter(userID) {
+			allowed = append(allowed, envelope)
+		}
+	}
+	return allowed
+}
+
+// SetChatFilter registers a function returning which recipients a chat
+// message from senderID may be delivered to. It is called once per message,
+// outside the hub's locks. It must be set before clients connect.
+func (h *Hub) SetChatFilter(filter func(senderID int) func(recipientID int) bool) {
+	h.chatFilter = filter
 }
 
-func (h *Hub) BroadcastToAll(message []byte) {
+// SetChatHandler registers the function that stores and delivers chat
+// messages sent over the WebSocket, so they go through the same moderation as
+// the REST endpoint. Without a handler such messages are dropped. It must be
+// set before clients connect.
+func (h *Hub) SetChatHandler(handler func(userID, gameID int, text string)) {
+	h.onChat = handler
+}
+
+// SetQuickChatHandler registers the function that handles quick-chat phrases
+// and reactions sent over the WebSocket. Without a handler they are dropped.
+// It must be set before clients connect.
+func (h *Hub) SetQuickChatHandler(handler func(userID, gameID int, kind, code string)) {
+	h.onQuick = handler
+}
+
+// SetStillHereHandler registers the function called when a player says they
+// are still there, resetting their game's inactivity timer. It must be set
+// before clients connect.
+func (h *Hub) SetStillHereHandler(handler func(userID, gameID int)) {
+	h.onStillHere = handler
+}
+
+// SetConnectHandler registers a function returning messages to send to a new
+// connection before anything else, such as notifications the user missed
+// while offline. It must be set before clients connect.
+func (h *Hub) SetConnectHandler(handler func(userID int) []*protocol.Envelope) {
+	h.onConnect = handler
+}
+
+// SetUnwrittenHandler registers a function called with the notifications a
+// connection dropped before writing them, such as when a slow client is
+// disconnected, so they can be pushed again on the next connect. It is called
+// before the connection closes. It must be set before clients connect.
+func (h *Hub) SetUnwrittenHandler(handler func(userID int, notificationIDs []int)) {
+	h.onUnwritten = handler
+}
+
+// BroadcastChatToGame sends a chat message to the game room, skipping
+// recipients the chat filter rejects.
+func (h *Hub) BroadcastChatToGame(gameID, senderID int, envelope *protocol.Envelope) {
+	h.broadcastToGame(gameID, senderID, envelope)
+}
+
+// BroadcastToLobby sends a message to every client connected to the lobby.
+func (h *Hub) BroadcastToLobby(envelope *protocol.Envelope) {
+	h.BroadcastChatToLobby(0, envelope)
+}
+
+// BroadcastChatToLobby sends a chat message to every lobby client the chat
+// filter allows. A zero senderID skips the filter.
+func (h *Hub) BroadcastChatToLobby(senderID int, envelope *protocol.Envelope) {
+	msg := newOutgoing(envelope)
+	allowed := h.recipients(senderID)
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
 	for client := range h.clients {
-		select {
-		case client.send <- message:
-		default:
-			// Client's send channel is full or closed, unregister the client
-			log.Printf("Failed to send to client UserID %d, unregistering", client.userID)
-			h.unregister <- client
+		if client.lobby && allowed(client.userID) {
+			h.deliver(client, msg)
 		}
 	}
 }
 
-func (h *Hub) SendToUser(userID int, message []byte) {
+func (h *Hub) BroadcastToAll(envelope *protocol.Envelope) {
+	msg := newOutgoing(envelope)
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	for client := range h.clients {
+		h.deliver(client, msg)
+	}
+}
+
+// Disconnect closes a user's WebSocket connections and event streams, e.g.
+// once they are banned. Long polls in progress return empty.
+func (h *Hub) Disconnect(userID int) {
+	h.clientsMu.RLock()
 	for client := range h.clients {
 		if client.userID == userID {
-			select {
-			case client.send <- message:
-			default:
-				// Client's send channel is full or closed, unregister the client
-				log.Printf("Failed to send to client UserID %d, unregistering", client.userID)
-				h.unregister <- client
+			client.queue.abort(accountClosedClose)
+		}
+	}
+	h.clientsMu.RUnlock()
+
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			if sub.userID == userID {
+				h.unsubscribeLocked(sub)
 			}
 		}
 	}
 }
 
-func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+func (h *Hub) SendToUser(userID int, envelope *protocol.Envelope) {
+	msg := newOutgoing(envelope)
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	for client := range h.clients {
+		if client.userID == userID {
+			h.deliver(client, msg)
+		}
+	}
+}
+
+// HandleWebSocket negotiates the protocol version and encoding and upgrades
+// the connection for an already authenticated user.
+func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request, userID int) {
+	offered := websocket.Subprotocols(r)
+	format, err := protocol.Negotiate(r.URL.Query().Get("v"), r.URL.Query().Get("encoding"), offered)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Confirm the subprotocol only when the client asked for it by name
+	var header http.Header
+	for _, name := range offered {
+		if name == format.Subprotocol() {
+			header = http.Header{"Sec-Websocket-Protocol": {name}}
+			break
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
 
-	// Extract user and game info from query parameters
-	userID := extractUserIDFromToken(r)
+	// Extract game info from query parameters
 	gameIDStr := r.URL.Query().Get("gameId")
 	gameID := 0
 	if gameIDStr != "" {
@@ -149,22 +524,60 @@ func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	log.Printf("WebSocket connection: UserID %d, GameID %d", userID, gameID)
+	log.Printf("WebSocket connection: UserID %d, GameID %d, protocol %s", userID, gameID, format.Subprotocol())
 
 	client := &Client{
 		hub:    hub,
 		conn:   conn,
-		send:   make(chan []byte, 256),
+		queue:  newSendQueue(hub.keepAlive.SendQueueSize, hub.keepAlive.SlowConsumer),
 		userID: userID,
-		gameID: gameID,
+		lobby:  r.URL.Query().Get("lobby") == "true",
+		format: format,
+	}
+
+	// Queue the greeting and missed messages first; the writePump sends them once it starts
+	var queued []*protocol.Envelope
+	if format.Version >= protocol.Version2 {
+		queued = append(queued, protocol.New(protocol.Welcome{
+			Version:    format.Version,
+			MinVersion: protocol.MinVersion,
+			MaxVersion: protocol.CurrentVersion,
+			UserID:     userID,
+		}))
+	}
+	if hub.onConnect != nil {
+		queued = append(queued, hub.onConnect(userID)...)
+	}
+	for _, envelope := range queued {
+		hub.deliver(client, newOutgoing(envelope))
 	}
 
+	// A client resuming after a drop passes the last seq it saw
+	if gameID > 0 {
+		var lastSeq *int64
+		if seq, err := strconv.ParseInt(r.URL.Query().Get("last_seq"), 10, 64); err == nil {
+			lastSeq = &seq
+		}
+		hub.joinRoom(client, gameID, lastSeq)
+	}
+
+	// Tracked before the read pump starts, so a join_game always comes after
+	hub.trackPresence(client)
 	client.hub.register <- client
 
 	go client.writePump()
 	go client.readPump()
 }
 
+// reject tells a client its message was not handled. Version 1 clients were
+// never sent errors, so they do not get one now either.
+func (c *Client) reject(message string) {
+	if c.format.Version < protocol.Version2 {
+		return
+	}
+	c.hub.deliver(c, newOutgoing(protocol.New(protocol.Error{Message: message})))
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		log.Printf("ReadPump closing for UserID %d, GameID %d", c.userID, c.gameID)
@@ -172,88 +585,151 @@ func (c *Client) readPump() {
 		c.conn.Close()
 	}()
 
+	// Any message from the client, pongs included, proves it is alive. A peer
+	// that goes silent hits the read deadline and is unregistered.
+	keepAlive := c.hub.keepAlive
+	c.conn.SetReadLimit(keepAlive.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(keepAlive.PongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(keepAlive.PongTimeout))
+	})
+
+	limiter := ratelimit.NewBucket(messagePolicy)
+	throttled := 0
+
 	for {
 		_, messageBytes, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("WebSocket for UserID %d timed out: no pong within %s", c.userID, keepAlive.PongTimeout)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error for UserID %d: %v", c.userID, err)
 			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(keepAlive.PongTimeout))
 
-		var msg Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("JSON unmarshal error: %v", err)
+		// Messages over the limit are dropped; a client that keeps flooding is disconnected
+		if !limiter.Take(time.Now()).Allowed {
+			throttled++
+			if throttled >= maxThrottledMessages {
+				log.Printf("Closing WebSocket for UserID %d: message rate limit exceeded", c.userID)
+				c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+					time.Now().Add(time.Second))
+				break
+			}
+			continue
+		}
+		throttled = 0
+
+		msg, err := c.format.Decode(messageBytes)
+		if err != nil {
+			log.Printf("Invalid WebSocket message from UserID %d: %v", c.userID, err)
+			c.reject("invalid message: " + err.Error())
 			continue
 		}
 
 		// Handle different message types
 		switch msg.Type {
-		case "chat":
-			// Broadcast chat message to game room
-			if c.gameID > 0 {
-				c.hub.BroadcastToGame(c.gameID, messageBytes)
+		case protocol.TypeChat:
+			// Chat is stored and broadcast by the handler
+			if c.gameID > 0 && c.hub.onChat != nil {
+				c.hub.onChat(c.userID, c.gameID, msg.Text())
+			}
+		case protocol.TypeQuickChat, protocol.TypeReaction:
+			// The message carries a catalog code rather than free text
+			kind := "phrase"
+			if msg.Type == protocol.TypeReaction {
+				kind = "reaction"
+			}
+			if c.gameID > 0 && c.hub.onQuick != nil {
+				c.hub.onQuick(c.userID, c.gameID, kind, msg.Code())
+			}
+		case protocol.TypeMove:
+			// Relay the move to the game room
+			move, ok := msg.Move()
+			if !ok {
+				c.reject("move requires x and y")
+				continue
 			}
-		case "move":
-			// Handle game move
 			if c.gameID > 0 {
-				c.hub.BroadcastToGame(c.gameID, messageBytes)
+				c.hub.BroadcastToGame(c.gameID, protocol.New(protocol.MoveRelay{
+					UserID: c.userID, X: move.X, Y: move.Y,
+				}).ForGame(c.gameID))
 			}
-		case "join_game":
-			// Handle joining a game
-			if gameID, ok := msg.Data.(float64); ok {
-				c.gameID = int(gameID)
-				if c.hub.gameRooms[c.gameID] == nil {
-					c.hub.gameRooms[c.gameID] = make(map[*Client]bool)
-				}
-				c.hub.gameRooms[c.gameID][c] = true
+		case protocol.TypeStillHere:
+			if c.gameID == 0 {
+				c.reject("still_here requires joining a game")
+				continue
+			}
+			if c.hub.onStillHere != nil {
+				c.hub.onStillHere(c.userID, c.gameID)
 			}
+		case protocol.TypeJoinGame:
+			// Handle joining a game, resuming it when the client sends its last seq
+			join, ok := msg.JoinGame()
+			if !ok {
+				c.reject("join_game requires a game_id")
+				continue
+			}
+			c.hub.joinRoom(c, join.GameID, join.LastSeq)
+			c.hub.trackPresence(c)
+		default:
+			c.reject("unknown message type " + string(msg.Type))
 		}
 	}
 }
 
+// unwritten reports notifications the connection failed to write.
+func (c *Client) unwritten(notificationIDs []int) {
+	if len(notificationIDs) > 0 && c.hub.onUnwritten != nil {
+		c.hub.onUnwritten(c.userID, notificationIDs)
+	}
+}
+
 func (c *Client) writePump() {
+	keepAlive := c.hub.keepAlive
+	messageType := websocket.TextMessage
+	if c.format.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	ticker := time.NewTicker(keepAlive.PingInterval)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
-		log.Printf("WritePump closed for UserID %d, GameID %d", c.userID, c.gameID)
+		log.Printf("WritePump closed for UserID %d", c.userID)
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				// Channel was closed, send close message and return
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.queue.ready:
+			messages, closed, closeFrame := c.queue.drain()
+			for i, message := range messages {
+				c.conn.SetWriteDeadline(time.Now().Add(keepAlive.WriteTimeout))
+				if err := c.conn.WriteMessage(messageType, message.data); err != nil {
+					log.Printf("WebSocket write error for UserID %d: %v", c.userID, err)
+					c.unwritten(notificationIDs(messages[i:]))
+					return
+				}
+			}
+			if closed {
+				// Queue was closed, send close message and return. Aborted
+				// notifications are handed back before the client can reconnect.
+				c.unwritten(c.queue.takeDiscarded())
+				c.conn.SetWriteDeadline(time.Now().Add(keepAlive.WriteTimeout))
+				c.conn.WriteMessage(websocket.CloseMessage, closeFrame)
 				return
 			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("WebSocket write error for UserID %d: %v", c.userID, err)
+		case <-ticker.C:
+			// Closing the connection on a failed ping also ends the readPump,
+			// which unregisters the client
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepAlive.WriteTimeout)); err != nil {
+				log.Printf("WebSocket ping failed for UserID %d: %v", c.userID, err)
 				return
 			}
 		}
 	}
 }
-
-// extractUserIDFromToken extracts the user ID from JWT token in query params
-func extractUserIDFromToken(r *http.Request) int {
-	tokenString := r.URL.Query().Get("token")
-	if tokenString == "" {
-		return 0
-	}
-
-	// Parse the token without verification for now (in production, you should verify)
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
-	if err != nil {
-		log.Printf("Failed to parse JWT token: %v", err)
-		return 0
-	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if userID, ok := claims["user_id"].(float64); ok {
-			return int(userID)
-		}
-	}
-
-	return 0
-}
@@ -0,0 +1,410 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"battleship-go/internal/protocol"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+var testKeepAlive = KeepAlive{
+	PingInterval:   20 * time.Millisecond,
+	PongTimeout:    80 * time.Millisecond,
+	WriteTimeout:   50 * time.Millisecond,
+	MaxMessageSize: 64,
+}
+
+// startHub serves the hub for user 1 and returns the URL to dial.
+func startHub(t *testing.T, hub *Hub) string {
+	hub.SetKeepAlive(testKeepAlive)
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(hub, w, r, 1)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "?gameId=5"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHub_KeepAlive(t *testing.T) {
+	t.Run("peer answering pings stays connected", func(t *testing.T) {
+		hub := NewHub()
+		conn := dial(t, startHub(t, hub))
+
+		// Reading makes the client answer pings with pongs
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		require.Eventually(t, func() bool { return hub.Connected(1, 5) }, time.Second, 5*time.Millisecond)
+		time.Sleep(4 * testKeepAlive.PongTimeout)
+		assert.True(t, hub.Connected(1, 5))
+	})
+
+	t.Run("dead peer is unregistered", func(t *testing.T) {
+		hub := NewHub()
+		left := make(chan int, 1)
+		hub.SetGameConnectionHandler(func(userID, gameID int, connected bool) {
+			if !connected {
+				left <- gameID
+			}
+		})
+
+		// A client that never reads never answers pings
+		dial(t, startHub(t, hub))
+
+		select {
+		case gameID := <-left:
+			assert.Equal(t, 5, gameID)
+		case <-time.After(time.Second):
+			t.Fatal("dead peer was not unregistered")
+		}
+		assert.False(t, hub.Connected(1, 5))
+		assert.Equal(t, PresenceOffline, hub.Presence(1).Status)
+	})
+
+	t.Run("oversized message closes the connection", func(t *testing.T) {
+		hub := NewHub()
+		conn := dial(t, startHub(t, hub))
+		require.Eventually(t, func() bool { return hub.Connected(1, 5) }, time.Second, 5*time.Millisecond)
+
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 128))))
+		assert.Eventually(t, func() bool { return !hub.Connected(1, 5) }, time.Second, 5*time.Millisecond)
+	})
+}
+
+// memoryLog is an EventLog keeping every event.
+type memoryLog struct {
+	events []*protocol.Envelope
+}
+
+func (m *memoryLog) Append(gameID int, envelope *protocol.Envelope) error {
+	envelope.Seq = int64(len(m.events) + 1)
+	m.events = append(m.events, envelope)
+	return nil
+}
+
+func (m *memoryLog) Since(gameID int, afterSeq int64) ([]*protocol.Envelope, error) {
+	if afterSeq > int64(len(m.events)) {
+		return nil, errors.New("ahead of the log")
+	}
+	return m.events[afterSeq:], nil
+}
+
+func (m *memoryLog) LastSeq(gameID int) (int64, error) {
+	return int64(len(m.events)), nil
+}
+
+// readSeqs reads n messages and returns their types and sequence numbers.
+func readSeqs(t *testing.T, conn *websocket.Conn, n int) ([]string, []int64) {
+	var types []string
+	var seqs []int64
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < n; i++ {
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var msg struct {
+			Type string `json:"type"`
+			Seq  int64  `json:"seq"`
+		}
+		require.NoError(t, json.Unmarshal(data, &msg))
+		types = append(types, msg.Type)
+		seqs = append(seqs, msg.Seq)
+	}
+	return types, seqs
+}
+
+func TestHub_Resume(t *testing.T) {
+	hub := NewHub()
+	hub.SetEventLog(&memoryLog{})
+	hub.SetChatFilter(func(senderID int) func(int) bool {
+		return func(int) bool { return senderID != 3 }
+	})
+	url := startHub(t, hub)
+
+	hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 2}))
+	hub.BroadcastChatToGame(5, 3, protocol.New(protocol.GameChat{}))
+	hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 1}))
+
+	t.Run("missed events are replayed before live ones", func(t *testing.T) {
+		conn := dial(t, url+"&v=2&last_seq=0")
+		types, _ := readSeqs(t, conn, 1)
+		assert.Equal(t, []string{"welcome"}, types)
+
+		// The chat from a filtered sender is skipped
+		_, seqs := readSeqs(t, conn, 2)
+		assert.Equal(t, []int64{1, 3}, seqs)
+
+		hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 2}))
+		_, seqs = readSeqs(t, conn, 1)
+		assert.Equal(t, []int64{4}, seqs)
+	})
+
+	t.Run("unknown position asks for a resync", func(t *testing.T) {
+		conn := dial(t, url+"&last_seq=99")
+		types, _ := readSeqs(t, conn, 1)
+		assert.Equal(t, []string{"resync"}, types)
+	})
+}
+
+func TestHub_Subscriptions(t *testing.T) {
+	hub := NewHub()
+	hub.SetEventLog(&memoryLog{})
+	hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 2}))
+	hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 1}))
+
+	t.Run("subscribe replays then streams", func(t *testing.T) {
+		lastSeq := int64(1)
+		sub := hub.Subscribe(1, 5, &lastSeq)
+		assert.True(t, hub.Connected(1, 5), "a stream counts as a connection")
+
+		assert.EqualValues(t, 2, (<-sub.C).Seq)
+		hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 2}))
+		assert.EqualValues(t, 3, (<-sub.C).Seq)
+
+		sub.Close()
+		_, open := <-sub.C
+		assert.False(t, open)
+		assert.False(t, hub.Connected(1, 5))
+		sub.Close() // closing twice is harmless
+	})
+
+	t.Run("poll returns missed events at once", func(t *testing.T) {
+		events := hub.Poll(context.Background(), 1, 5, 1, time.Second)
+		require.Len(t, events, 2)
+		assert.EqualValues(t, 2, events[0].Seq)
+		assert.False(t, hub.Connected(1, 5), "a poll does not count as a connection")
+	})
+
+	t.Run("poll waits for the next event", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			hub.BroadcastToGame(5, protocol.New(protocol.ShipPlacementUpdate{UserID: 2}))
+		}()
+		events := hub.Poll(context.Background(), 1, 5, 3, time.Second)
+		require.Len(t, events, 1)
+		assert.EqualValues(t, 4, events[0].Seq)
+	})
+
+	t.Run("poll times out empty", func(t *testing.T) {
+		assert.Empty(t, hub.Poll(context.Background(), 1, 5, 4, 20*time.Millisecond))
+	})
+}
+
+func TestHub_PresenceOrder(t *testing.T) {
+	hub := NewHub()
+	var mu sync.Mutex
+	var seen []Presence
+	var connections []bool
+	first := make(chan struct{})
+	hub.SetPresenceHandler(func(userID int, presence Presence) {
+		mu.Lock()
+		seen = append(seen, presence)
+		n := len(seen)
+		mu.Unlock()
+		if n == 1 {
+			// A slow first call must not let later changes overtake it
+			<-first
+		}
+	})
+	hub.SetGameConnectionHandler(func(userID, gameID int, connected bool) {
+		mu.Lock()
+		connections = append(connections, connected)
+		mu.Unlock()
+	})
+
+	for i := 0; i < 3; i++ {
+		hub.Subscribe(1, 5, nil).Close()
+	}
+	close(first)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) == 6 && len(connections) == 6
+	}, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, presence := range seen {
+		if i%2 == 0 {
+			assert.Equal(t, PresenceInGame, presence.Status)
+		} else {
+			assert.Equal(t, PresenceOffline, presence.Status)
+		}
+		assert.EqualValues(t, i+1, presence.Version)
+	}
+	assert.Equal(t, []bool{true, false, true, false, true, false}, connections)
+	assert.Equal(t, seen[5], hub.Presence(1))
+}
+
+func TestHub_JoinGameRightAfterConnect(t *testing.T) {
+	hub := NewHub()
+	var mu sync.Mutex
+	var last Presence
+	hub.SetPresenceHandler(func(userID int, presence Presence) {
+		mu.Lock()
+		last = presence
+		mu.Unlock()
+	})
+
+	conn := dial(t, startHub(t, hub))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"join_game","data":{"game_id":7}}`)))
+
+	require.Eventually(t, func() bool { return hub.Connected(1, 7) }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, hub.Connected(1, 5))
+	assert.Equal(t, 7, hub.Presence(1).GameID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, hub.Presence(1), last)
+}
+
+func TestHub_Disconnect(t *testing.T) {
+	hub := NewHub()
+	conn := dial(t, startHub(t, hub))
+	require.Eventually(t, func() bool { return hub.Connected(1, 5) }, time.Second, 5*time.Millisecond)
+	sub := hub.Subscribe(1, 6, nil)
+	other := hub.Subscribe(2, 6, nil)
+	defer other.Close()
+
+	hub.Disconnect(1)
+
+	_, open := <-sub.C
+	assert.False(t, open)
+	sub.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+			break
+		}
+	}
+	assert.Eventually(t, func() bool { return hub.Presence(1).Status == PresenceOffline }, time.Second, 5*time.Millisecond)
+	assert.True(t, hub.Connected(2, 6), "other users stay connected")
+}
+
+// stallingLog is a memoryLog whose appends to one game wait for release.
+type stallingLog struct {
+	memoryLog
+	mu      sync.Mutex
+	game    int
+	release chan struct{}
+}
+
+func (s *stallingLog) Append(gameID int, envelope *protocol.Envelope) error {
+	if gameID == s.game {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memoryLog.Append(gameID, envelope)
+}
+
+func TestHub_SlowEventLog(t *testing.T) {
+	hub := NewHub()
+	events := &stallingLog{game: 1, release: make(chan struct{})}
+	hub.SetEventLog(events)
+
+	stalled := make(chan struct{})
+	go func() {
+		hub.BroadcastToGame(1, protocol.New(protocol.ShipPlacementUpdate{UserID: 1}))
+		close(stalled)
+	}()
+
+	// A slow write in one game holds up neither other games nor the hub
+	sub := hub.Subscribe(2, 7, nil)
+	done := make(chan struct{})
+	go func() {
+		hub.BroadcastToGame(7, protocol.New(protocol.ShipPlacementUpdate{UserID: 2}))
+		hub.Stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast waited for another game's event log write")
+	}
+	assert.Equal(t, 7, (<-sub.C).GameID)
+	sub.Close()
+
+	close(events.release)
+	<-stalled
+	hub.rooms.Lock()
+	assert.Empty(t, hub.gameLocks, "released game locks are dropped")
+	hub.rooms.Unlock()
+}
+
+func TestHub_Msgpack(t *testing.T) {
+	hub := NewHub()
+	url := startHub(t, hub)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"battleship.v2.msgpack", "battleship.v2"}}
+	conn, resp, err := dialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	assert.Equal(t, "battleship.v2.msgpack", resp.Header.Get("Sec-Websocket-Protocol"))
+
+	jsonConn := dial(t, url+"&v=2")
+	require.Eventually(t, func() bool { return hub.Connected(1, 5) }, time.Second, 5*time.Millisecond)
+
+	handle := &codec.MsgpackHandle{}
+	handle.RawToString = true
+	readMsgpack := func() map[string]interface{} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, messageType)
+		var msg map[string]interface{}
+		require.NoError(t, codec.NewDecoderBytes(data, handle).Decode(&msg))
+		return msg
+	}
+
+	welcome := readMsgpack()
+	assert.Equal(t, "welcome", welcome["type"])
+
+	// Both clients get the same broadcast, each in their own encoding
+	time.Sleep(50 * time.Millisecond)
+	hub.BroadcastToGame(5, protocol.New(protocol.Presence{UserID: 2, Status: PresenceInGame, GameID: 5}))
+	assert.Equal(t, "presence", readMsgpack()["type"])
+
+	jsonConn.SetReadDeadline(time.Now().Add(time.Second))
+	var types []string
+	for len(types) < 2 {
+		messageType, data, err := jsonConn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.TextMessage, messageType)
+		var msg struct {
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(data, &msg))
+		types = append(types, msg.Type)
+	}
+	assert.Equal(t, []string{"welcome", "presence"}, types)
+}
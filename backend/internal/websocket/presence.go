@@ -0,0 +1,247 @@
+package websocket
+
+import (
+	"sync"
+)
+
+// Presence statuses, from least to most specific
+const (
+	PresenceOffline = "offline"
+	PresenceOnline  = "online"
+	PresenceLobby   = "lobby"
+	PresenceInGame  = "in_game"
+)
+
+// Presence is where a user currently is, derived from their open connections.
+// Version grows with every change, so a client can tell which of two
+// presences of a user is newer.
+type Presence struct {
+	Status  string `json:"status"`
+	GameID  int    `json:"game_id,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// location is what a single connection reports about its user.
+type location struct {
+	gameID int
+	lobby  bool
+}
+
+// presenceTracker keeps the location of every connection per user. A
+// connection is a WebSocket client or an event stream subscription. It has
+// its own lock because presence is read from request handlers while the hub
+// goroutine and client read pumps update it. Changes are passed to onChange
+// with the lock held, so they are seen in the order they happened.
+type presenceTracker struct {
+	mu       sync.RWMutex
+	users    map[int]map[interface{}]location
+	version  uint64
+	versions map[int]uint64 // userID -> version of their last change
+	onChange func(userID int, update presenceUpdate)
+}
+
+func newPresenceTracker(onChange func(userID int, update presenceUpdate)) *presenceTracker {
+	return &presenceTracker{
+		users:    make(map[int]map[interface{}]location),
+		versions: make(map[int]uint64),
+		onChange: onChange,
+	}
+}
+
+// get returns a user's presence. A user with several connections is reported
+// at the most specific location: a game, then the lobby, then just online.
+func (p *presenceTracker) get(userID int) Presence {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.presenceLocked(userID)
+}
+
+func (p *presenceTracker) presenceLocked(userID int) Presence {
+	conns := p.users[userID]
+	if len(conns) == 0 {
+		return Presence{Status: PresenceOffline, Version: p.versions[userID]}
+	}
+
+	presence := Presence{Status: PresenceOnline, Version: p.versions[userID]}
+	for _, loc := range conns {
+		switch {
+		case loc.gameID > 0:
+			// Prefer the most recent game when several are open
+			if loc.gameID > presence.GameID {
+				presence.Status, presence.GameID = PresenceInGame, loc.gameID
+			}
+		case loc.lobby && presence.Status == PresenceOnline:
+			presence.Status = PresenceLobby
+		}
+	}
+	return presence
+}
+
+// presenceUpdate is the effect of a connection change on its user.
+type presenceUpdate struct {
+	presence Presence
+	changed  bool
+	joined   []int // games the user now has a first connection to
+	left     []int // games the user no longer has any connection to
+}
+
+// set records a connection's location.
+func (p *presenceTracker) set(userID int, conn interface{}, loc location) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	before := p.presenceLocked(userID)
+	gamesBefore := p.gamesLocked(userID)
+	if p.users[userID] == nil {
+		p.users[userID] = make(map[interface{}]location)
+	}
+	p.users[userID][conn] = loc
+	p.updateLocked(userID, before, gamesBefore)
+}
+
+// remove forgets a connection.
+func (p *presenceTracker) remove(userID int, conn interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	before := p.presenceLocked(userID)
+	gamesBefore := p.gamesLocked(userID)
+	delete(p.users[userID], conn)
+	if len(p.users[userID]) == 0 {
+		delete(p.users, userID)
+	}
+	p.updateLocked(userID, before, gamesBefore)
+}
+
+// connected reports whether the user has a connection to the game.
+func (p *presenceTracker) connected(userID, gameID int) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.gamesLocked(userID)[gameID]
+}
+
+func (p *presenceTracker) gamesLocked(userID int) map[int]bool {
+	games := make(map[int]bool)
+	for _, loc := range p.users[userID] {
+		if loc.gameID > 0 {
+			games[loc.gameID] = true
+		}
+	}
+	return games
+}
+
+// updateLocked works out how a connection change affected the user and
+// reports it.
+func (p *presenceTracker) updateLocked(userID int, before Presence, gamesBefore map[int]bool) {
+	update := presenceUpdate{presence: p.presenceLocked(userID)}
+	update.changed = update.presence != before
+	if update.changed {
+		p.version++
+		p.versions[userID] = p.version
+		update.presence.Version = p.version
+	}
+
+	gamesAfter := p.gamesLocked(userID)
+	for gameID := range gamesAfter {
+		if !gamesBefore[gameID] {
+			update.joined = append(update.joined, gameID)
+		}
+	}
+	for gameID := range gamesBefore {
+		if !gamesAfter[gameID] {
+			update.left = append(update.left, gameID)
+		}
+	}
+	if p.onChange != nil {
+		p.onChange(userID, update)
+	}
+}
+
+// SetPresenceHandler registers a function called whenever a user's presence
+// changes. Calls are made one at a time in the order of the changes, on a
+// goroutine of their own so that slow handlers never block the hub. It must
+// be set before clients connect.
+func (h *Hub) SetPresenceHandler(handler func(userID int, presence Presence)) {
+	h.onPresence = handler
+}
+
+// Presence returns a user's current presence.
+func (h *Hub) Presence(userID int) Presence {
+	return h.presence.get(userID)
+}
+
+// SetGameConnectionHandler registers a function called when a user opens
+// their first connection to a game or loses their last one, whether it was
+// closed or timed out. It is called in order with the presence handler. It
+// must be set before clients connect.
+func (h *Hub) SetGameConnectionHandler(handler func(userID, gameID int, connected bool)) {
+	h.onGameConnection = handler
+}
+
+// Connected reports whether a user has an open connection to a game.
+func (h *Hub) Connected(userID, gameID int) bool {
+	return h.presence.connected(userID, gameID)
+}
+
+// trackPresence records the client's current location.
+func (h *Hub) trackPresence(c *Client) {
+	h.presence.set(c.userID, c, location{gameID: c.gameID, lobby: c.lobby})
+}
+
+// untrackPresence forgets a closed client.
+func (h *Hub) untrackPresence(c *Client) {
+	h.presence.remove(c.userID, c)
+}
+
+// presenceChanged queues the handlers for a change. It is called with the
+// presence lock held.
+func (h *Hub) presenceChanged(userID int, update presenceUpdate) {
+	if update.changed && h.onPresence != nil {
+		presence := update.presence
+		h.presenceEvents.queue(func() { h.onPresence(userID, presence) })
+	}
+	if h.onGameConnection == nil {
+		return
+	}
+	for _, gameID := range update.joined {
+		h.presenceEvents.queue(func() { h.onGameConnection(userID, gameID, true) })
+	}
+	for _, gameID := range update.left {
+		h.presenceEvents.queue(func() { h.onGameConnection(userID, gameID, false) })
+	}
+}
+
+// dispatcher runs functions one at a time in the order they were queued.
+// Queueing never blocks: a goroutine is started when there is work and
+// exits once the queue is empty.
+type dispatcher struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (d *dispatcher) queue(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = append(d.pending, fn)
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+}
+
+func (d *dispatcher) run() {
+	for {
+		d.mu.Lock()
+		if len(d.pending) == 0 {
+			d.running = false
+			d.mu.Unlock()
+			return
+		}
+		fn := d.pending[0]
+		d.pending[0] = nil
+		d.pending = d.pending[1:]
+		d.mu.Unlock()
+		fn()
+	}
+}
@@ -0,0 +1,255 @@
+package websocket
+
+import (
+	"strconv"
+	"sync"
+
+	"battleship-go/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendQueueSize is the default number of messages a client may have waiting
+// before the slow consumer policy applies.
+const sendQueueSize = 256
+
+// SlowConsumerPolicy decides what happens to a client whose send queue is full.
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest drops the oldest message that is not critical to make room.
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// Coalesce replaces a queued state update, such as a friend's presence,
+	// with its newer version and otherwise drops the oldest message.
+	Coalesce SlowConsumerPolicy = "coalesce"
+	// Disconnect closes the connection with CloseTryAgainLater. The client
+	// reconnects with the last seq it saw to get the missed game events.
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+// Valid reports whether p is a known policy.
+func (p SlowConsumerPolicy) Valid() bool {
+	return p == DropOldest || p == Coalesce || p == Disconnect
+}
+
+// queuedMessage is an encoded message waiting to be written.
+type queuedMessage struct {
+	data []byte
+	// critical messages are never dropped: a client that cannot take them is
+	// disconnected instead, and gets them again when it resumes. Game events
+	// are replayed by seq; notifications are handed back as undelivered.
+	critical bool
+	// key identifies a state update a newer one may replace; empty if none.
+	key string
+	// notificationID is set for notifications.
+	notificationID int
+}
+
+// classify returns whether a message is critical and its coalescing key.
+// Game state and notifications are critical; chat and presence are not.
+func classify(envelope *protocol.Envelope) (bool, string) {
+	switch envelope.Type {
+	case protocol.TypePresence:
+		if presence, ok := envelope.Data.(protocol.Presence); ok {
+			return false, "presence:" + strconv.Itoa(presence.UserID)
+		}
+		return false, ""
+	case protocol.TypeChat, protocol.TypeLobbyChat, protocol.TypeDirectMessage, protocol.TypeChatDeleted,
+		protocol.TypeQuickChat, protocol.TypeReaction, protocol.TypeNewGameCreated:
+		return false, ""
+	default:
+		return true, ""
+	}
+}
+
+// sendQueue is a client's bounded queue of outgoing messages. Pushing never
+// blocks, so the hub can deliver while holding its locks.
+type sendQueue struct {
+	mu       sync.Mutex
+	messages []queuedMessage
+	limit    int
+	policy   SlowConsumerPolicy
+	// ready is signalled when messages are pushed or the queue is closed.
+	ready   chan struct{}
+	closed  bool
+	close   []byte // close frame payload once closed
+	dropped uint64
+	// discarded holds the notifications abort threw away unwritten.
+	discarded []int
+}
+
+func newSendQueue(limit int, policy SlowConsumerPolicy) *sendQueue {
+	return &sendQueue{limit: limit, policy: policy, ready: make(chan struct{}, 1)}
+}
+
+// push queues a message, applying the policy when the queue is full. It
+// reports whether an older message was dropped to make room, and false for
+// ok when the message could not be queued and the client has to be
+// disconnected.
+func (q *sendQueue) push(msg queuedMessage) (dropped bool, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, true
+	}
+	if len(q.messages) >= q.limit {
+		if !q.makeRoom(msg) {
+			return false, false
+		}
+		dropped = true
+	}
+	q.messages = append(q.messages, msg)
+	q.signal()
+	return dropped, true
+}
+
+// makeRoom drops a message from a full queue to free a slot for msg. It
+// reports false when the policy allows dropping nothing.
+func (q *sendQueue) makeRoom(msg queuedMessage) bool {
+	if q.policy == Disconnect {
+		return false
+	}
+	if q.policy == Coalesce && msg.key != "" {
+		for i := range q.messages {
+			if q.messages[i].key == msg.key {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				q.dropped++
+				return true
+			}
+		}
+	}
+	for i := range q.messages {
+		if !q.messages[i].critical {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			q.dropped++
+			return true
+		}
+	}
+	return false
+}
+
+// shutdown closes the queue. The writer sends what is queued, then an
+// empty close frame.
+func (q *sendQueue) shutdown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.signal()
+}
+
+// abort closes the queue, discarding what is queued, so the writer sends
+// the close frame payload right away. The discarded notifications are kept
+// for takeDiscarded.
+func (q *sendQueue) abort(payload []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.close = payload
+	q.discarded = append(q.discarded, notificationIDs(q.messages)...)
+	q.messages = nil
+	q.signal()
+}
+
+// takeDiscarded returns the notifications abort discarded.
+func (q *sendQueue) takeDiscarded() []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	discarded := q.discarded
+	q.discarded = nil
+	return discarded
+}
+
+// notificationIDs returns the notifications among messages.
+func notificationIDs(messages []queuedMessage) []int {
+	var ids []int
+	for _, msg := range messages {
+		if msg.notificationID != 0 {
+			ids = append(ids, msg.notificationID)
+		}
+	}
+	return ids
+}
+
+// drain takes every queued message. When the queue is closed it also
+// returns the close frame payload.
+func (q *sendQueue) drain() ([]queuedMessage, bool, []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := q.messages
+	q.messages = nil
+	return messages, q.closed, q.close
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// stats returns the queue depth and the number of dropped messages.
+func (q *sendQueue) stats() (int, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages), q.dropped
+}
+
+// slowConsumerClose is the close frame sent to clients disconnected for
+// falling behind.
+var slowConsumerClose = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "send queue full")
+
+// accountClosedClose is the close frame sent to clients of a user who was
+// banned or deleted their account.
+var accountClosedClose = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "account closed")
+
+// ClientStats describes the send queue of one connection.
+type ClientStats struct {
+	UserID     int    `json:"user_id"`
+	GameID     int    `json:"game_id,omitempty"`
+	QueueDepth int    `json:"queue_depth"`
+	Dropped    uint64 `json:"dropped"`
+}
+
+// Stats are the hub's delivery metrics. Dropped and SlowDisconnects count
+// since the hub started, closed connections included.
+type Stats struct {
+	Connections     int           `json:"connections"`
+	Dropped         uint64        `json:"dropped"`
+	SlowDisconnects uint64        `json:"slow_disconnects"`
+	Policy          string        `json:"policy"`
+	Clients         []ClientStats `json:"clients"`
+}
+
+// Stats returns the queue depth and dropped messages of every connection.
+func (h *Hub) Stats() Stats {
+	// The rooms lock keeps the clients' game IDs still
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	stats := Stats{
+		Connections:     len(h.clients),
+		Dropped:         h.dropped.Load(),
+		SlowDisconnects: h.slowDisconnects.Load(),
+		Policy:          string(h.keepAlive.SlowConsumer),
+		Clients:         make([]ClientStats, 0, len(h.clients)),
+	}
+	for client := range h.clients {
+		depth, dropped := client.queue.stats()
+		stats.Clients = append(stats.Clients, ClientStats{
+			UserID:     client.userID,
+			GameID:     client.gameID,
+			QueueDepth: depth,
+			Dropped:    dropped,
+		})
+	}
+	return stats
+}
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"testing"
+
+	"battleship-go/internal/protocol"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	critical, key := classify(protocol.New(protocol.GameUpdate{Reason: protocol.ReasonForfeit}))
+	assert.True(t, critical)
+	assert.Empty(t, key)
+
+	critical, key = classify(protocol.New(protocol.Presence{UserID: 4, Status: PresenceOnline}))
+	assert.False(t, critical)
+	assert.Equal(t, "presence:4", key)
+
+	critical, _ = classify(protocol.New(protocol.LobbyChat{}))
+	assert.False(t, critical)
+}
+
+func TestSendQueue(t *testing.T) {
+	chat := queuedMessage{data: []byte("chat")}
+	update := queuedMessage{data: []byte("update"), critical: true}
+	presence := func(data string) queuedMessage {
+		return queuedMessage{data: []byte(data), key: "presence:4"}
+	}
+	contents := func(q *sendQueue) []string {
+		messages, _, _ := q.drain()
+		var result []string
+		for _, msg := range messages {
+			result = append(result, string(msg.data))
+		}
+		return result
+	}
+
+	t.Run("drop oldest skips critical messages", func(t *testing.T) {
+		q := newSendQueue(3, DropOldest)
+		for _, msg := range []queuedMessage{update, chat, presence("online")} {
+			_, ok := q.push(msg)
+			require.True(t, ok)
+		}
+
+		dropped, ok := q.push(update)
+		assert.True(t, dropped)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"update", "online", "update"}, contents(q))
+	})
+
+	t.Run("coalesce replaces the queued state update", func(t *testing.T) {
+		q := newSendQueue(3, Coalesce)
+		for _, msg := range []queuedMessage{chat, presence("online"), update} {
+			q.push(msg)
+		}
+
+		dropped, ok := q.push(presence("in_game"))
+		assert.True(t, dropped)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"chat", "update", "in_game"}, contents(q))
+
+		depth, droppedTotal := q.stats()
+		assert.Equal(t, 0, depth)
+		assert.Equal(t, uint64(1), droppedTotal)
+	})
+
+	t.Run("full of critical messages", func(t *testing.T) {
+		q := newSendQueue(2, Coalesce)
+		q.push(update)
+		q.push(update)
+
+		// Nothing may be dropped, so the client has to be disconnected
+		_, ok := q.push(chat)
+		assert.False(t, ok)
+		_, ok = q.push(update)
+		assert.False(t, ok)
+	})
+
+	t.Run("disconnect drops nothing", func(t *testing.T) {
+		q := newSendQueue(1, Disconnect)
+		q.push(chat)
+		_, ok := q.push(chat)
+		assert.False(t, ok)
+	})
+
+	t.Run("abort discards queued messages", func(t *testing.T) {
+		q := newSendQueue(2, DropOldest)
+		q.push(chat)
+		q.abort(slowConsumerClose)
+
+		messages, closed, closeFrame := q.drain()
+		assert.Empty(t, messages)
+		assert.True(t, closed)
+		assert.Equal(t, slowConsumerClose, closeFrame)
+
+		// Pushing after close is ignored
+		_, ok := q.push(update)
+		assert.True(t, ok)
+	})
+
+	t.Run("abort keeps discarded notifications", func(t *testing.T) {
+		q := newSendQueue(2, Disconnect)
+		q.push(queuedMessage{data: []byte("friend_request"), critical: true, notificationID: 12})
+		q.push(chat)
+		_, ok := q.push(update)
+		require.False(t, ok)
+		q.abort(slowConsumerClose)
+
+		assert.Equal(t, []int{12}, q.takeDiscarded())
+		assert.Empty(t, q.takeDiscarded())
+	})
+}
+
+func TestHub_SlowConsumer(t *testing.T) {
+	hub := NewHub()
+	client := &Client{hub: hub, userID: 7, format: protocol.DefaultFormat, queue: newSendQueue(2, Disconnect)}
+
+	chat := protocol.New(protocol.LobbyChat{})
+	notification := protocol.New(protocol.Stored{Type: protocol.TypeFriendRequest})
+	notification.NotificationID = 3
+	hub.deliver(client, newOutgoing(notification))
+	hub.deliver(client, newOutgoing(chat))
+	assert.Zero(t, hub.slowDisconnects.Load())
+
+	// Delivering never blocks, even when the client has to go
+	hub.deliver(client, newOutgoing(protocol.New(protocol.GameUpdate{Reason: protocol.ReasonForfeit})))
+	assert.Equal(t, uint64(1), hub.slowDisconnects.Load())
+
+	messages, closed, closeFrame := client.queue.drain()
+	assert.Empty(t, messages)
+	assert.True(t, closed)
+	assert.Equal(t, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "send queue full"), closeFrame)
+
+	// The notification was never written, so it is handed back
+	var unwritten []int
+	hub.SetUnwrittenHandler(func(userID int, notificationIDs []int) {
+		assert.Equal(t, 7, userID)
+		unwritten = notificationIDs
+	})
+	client.unwritten(client.queue.takeDiscarded())
+	assert.Equal(t, []int{3}, unwritten)
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"battleship-go/internal/protocol"
+)
+
+// subscriptionBuffer is the number of events a subscription holds before it
+// is considered too slow and closed.
+const subscriptionBuffer = 256
+
+// Subscription receives the events of one game outside a WebSocket, for the
+// Server-Sent Events and long-poll endpoints. It sees exactly what a
+// WebSocket client in the game room sees, in the same order.
+type Subscription struct {
+	// C delivers the events. It is closed when the subscription ends, also
+	// when the subscriber fell too far behind; it can resume from the last
+	// seq it received.
+	C <-chan *protocol.Envelope
+
+	events  chan *protocol.Envelope
+	hub     *Hub
+	userID  int
+	gameID  int
+	present bool // counts as a connection to the game for presence
+}
+
+// Subscribe follows a game's events as a connection of the user: it shows up
+// in presence like a WebSocket client in the game. With lastSeq set, the
+// events missed since then are delivered first. Call Close when done.
+func (h *Hub) Subscribe(userID, gameID int, lastSeq *int64) *Subscription {
+	return h.subscribe(userID, gameID, lastSeq, true)
+}
+
+func (h *Hub) subscribe(userID, gameID int, lastSeq *int64, present bool) *Subscription {
+	events := make(chan *protocol.Envelope, subscriptionBuffer)
+	sub := &Subscription{C: events, events: events, hub: h, userID: userID, gameID: gameID, present: present}
+
+	unlock := h.lockGame(gameID)
+	var missed []*protocol.Envelope
+	if lastSeq != nil {
+		missed = h.missed(userID, gameID, *lastSeq)
+	}
+	h.rooms.Lock()
+	for _, envelope := range missed {
+		h.notifyLocked(sub, envelope)
+	}
+	if h.subscribers[gameID] == nil {
+		h.subscribers[gameID] = make(map[*Subscription]bool)
+	}
+	h.subscribers[gameID][sub] = true
+	h.rooms.Unlock()
+	unlock()
+
+	if present {
+		h.presence.set(userID, sub, location{gameID: gameID})
+	}
+	return sub
+}
+
+// notifyLocked queues an event for a subscriber, dropping subscribers that
+// fell behind.
+func (h *Hub) notifyLocked(sub *Subscription, envelope *protocol.Envelope) {
+	select {
+	case sub.events <- envelope:
+	default:
+		log.Printf("Dropping slow event subscriber UserID %d, GameID %d", sub.userID, sub.gameID)
+		h.unsubscribeLocked(sub)
+	}
+}
+
+func (h *Hub) unsubscribeLocked(sub *Subscription) bool {
+	if !h.subscribers[sub.gameID][sub] {
+		return false
+	}
+	delete(h.subscribers[sub.gameID], sub)
+	if len(h.subscribers[sub.gameID]) == 0 {
+		delete(h.subscribers, sub.gameID)
+	}
+	close(sub.events)
+	return true
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.rooms.Lock()
+	s.hub.unsubscribeLocked(s)
+	s.hub.rooms.Unlock()
+
+	if s.present {
+		s.hub.presence.remove(s.userID, s)
+	}
+}
+
+// Poll waits up to wait for a game's events after lastSeq and returns them,
+// for long-polling clients. Events already missed are returned at once. A
+// poll does not count as a connection for presence.
+func (h *Hub) Poll(ctx context.Context, userID, gameID int, lastSeq int64, wait time.Duration) []*protocol.Envelope {
+	sub := h.subscribe(userID, gameID, &lastSeq, false)
+	defer sub.Close()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var events []*protocol.Envelope
+	select {
+	case envelope, ok := <-sub.C:
+		if !ok {
+			return nil
+		}
+		events = append(events, envelope)
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+
+	// Return everything that is already queued along with the first event
+	for {
+		select {
+		case envelope, ok := <-sub.C:
+			if !ok {
+				return events
+			}
+			events = append(events, envelope)
+		default:
+			return events
+		}
+	}
+}
@@ -1,26 +1,50 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"battleship-go/internal/api"
 	"battleship-go/internal/cleanup"
 	"battleship-go/internal/config"
 	"battleship-go/internal/database"
+	"battleship-go/internal/game"
 	"battleship-go/internal/websocket"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(configCommand(args[1:]))
+	}
+
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Failed to load configuration:\n", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	if !cfg.IsProduction() && cfg.JWT.Secret == config.DefaultJWTSecret {
+		log.Println("WARNING: using the default JWT secret; set JWT_SECRET before deploying")
+	}
 
 	// Initialize database
-	db, err := database.Initialize(cfg.DatabaseURL)
+	db, err := database.Initialize(cfg.Database.URL)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
@@ -35,39 +59,81 @@ func main() {
 	hub := websocket.NewHub()
 	go hub.Run()
 
-	// Initialize and start cleanup service
-	cleanupService := cleanup.NewCleanupService(db)
-	cleanupService.StartCleanupScheduler()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Setup Gin router
-	router := gin.Default()
+	// Initialize and start cleanup service. The advisory lock keeps several
+	// instances from cleaning up at the same time.
+	cleanupService := cleanup.NewCleanupService(db, game.NewGameService(db), cleanup.Options{
+		ActiveTimeout:  cfg.Cleanup.ActiveTimeout,
+		WaitingTimeout: cfg.Cleanup.WaitingTimeout,
+		ArchiveAfter:   cfg.Cleanup.ArchiveAfter,
+		WarnBefore:     cfg.Cleanup.WarnBefore,
+	})
+	cleanupService.SetLocker(cleanup.NewAdvisoryLock(db, cleanup.AdvisoryLockKey))
+	cleanupDone := make(chan struct{})
+	go func() {
+		cleanupService.Start(ctx, cfg.Cleanup.Interval)
+		close(cleanupDone)
+	}()
 
-	// CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		AllowCredentials: true,
-	}))
+	// Setup Gin router
+	router, err := api.NewRouter(cfg)
+	if err != nil {
+		log.Fatal("Failed to setup router:", err)
+	}
 
 	// Initialize API routes
-	api.SetupRoutes(router, db, hub)
-
-	// WebSocket endpoint
-	router.GET("/ws", func(c *gin.Context) {
-		websocket.HandleWebSocket(hub, c.Writer, c.Request)
-	})
+	if err := api.SetupRoutes(router, db, hub, cleanupService, cfg); err != nil {
+		log.Fatal("Failed to setup routes:", err)
+	}
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	server := &http.Server{Addr: ":" + cfg.Server.Port, Handler: router}
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Server.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
+	<-cleanupDone
+}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+// configCommand runs "config print", which shows the configuration the
+// server would start with, secrets redacted, and reports what is invalid.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: battleship-go config print [-config file] [flags]")
+		return 2
+	}
+	cfg, err := config.Load(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
 }
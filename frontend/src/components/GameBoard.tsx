@@ -13,7 +13,10 @@ const GameBoard: React.FC<GameBoardProps> = ({ board, onCellClick, disabled = fa
   };
 
   return (
-    <div className="game-board">
+    <div
+      className="game-board"
+      style={{ gridTemplateColumns: `repeat(${board.length}, 40px)`, gridTemplateRows: `repeat(${board.length}, 40px)` }}
+    >
       {board.map((row, y) =>
         row.map((cell, x) => (
           <button
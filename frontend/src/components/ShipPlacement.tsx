@@ -2,6 +2,7 @@ import React, { useState } from 'react';
 import { Ship } from '../types';
 
 interface ShipPlacementProps {
+  boardSize: number;
   onShipsPlaced: (ships: Ship[]) => void;
 }
 
@@ -13,9 +14,9 @@ const SHIP_TYPES = [
   { type: 'destroyer', size: 2, name: 'Destroyer' },
 ] as const;
 
-const ShipPlacement: React.FC<ShipPlacementProps> = ({ onShipsPlaced }) => {
+const ShipPlacement: React.FC<ShipPlacementProps> = ({ boardSize, onShipsPlaced }) => {
   const [board, setBoard] = useState<string[][]>(
-    Array(10).fill(null).map(() => Array(10).fill('empty'))
+    Array(boardSize).fill(null).map(() => Array(boardSize).fill('empty'))
   );
   const [ships, setShips] = useState<Ship[]>([]);
   const [currentShipIndex, setCurrentShipIndex] = useState(0);
@@ -34,9 +35,9 @@ const ShipPlacement: React.FC<ShipPlacementProps> = ({ onShipsPlaced }) => {
   const canPlaceShip = (x: number, y: number, size: number, vertical: boolean): boolean => {
     // Check bounds
     if (vertical) {
-      if (y + size > 10) return false;
+      if (y + size > boardSize) return false;
     } else {
-      if (x + size > 10) return false;
+      if (x + size > boardSize) return false;
     }
 
     // Check for overlaps
@@ -136,7 +137,7 @@ const ShipPlacement: React.FC<ShipPlacementProps> = ({ onShipsPlaced }) => {
   };
 
   const resetPlacement = () => {
-    setBoard(Array(10).fill(null).map(() => Array(10).fill('empty')));
+    setBoard(Array(boardSize).fill(null).map(() => Array(boardSize).fill('empty')));
     setShips([]);
     setCurrentShipIndex(0);
   };
@@ -176,7 +177,7 @@ const ShipPlacement: React.FC<ShipPlacementProps> = ({ onShipsPlaced }) => {
         )}
       </div>
 
-      <div className="game-board">
+      <div className="game-board" style={{ gridTemplateColumns: `repeat(${boardSize}, 40px)`, gridTemplateRows: `repeat(${boardSize}, 40px)` }}>
         {board.map((row: string[], y: number) =>
           row.map((cell: string, x: number) => (
             <button
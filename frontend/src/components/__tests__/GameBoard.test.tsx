@@ -23,6 +23,14 @@ describe('GameBoard', () => {
     expect(cells).toHaveLength(100);
   });
 
+  it('sizes the grid to the board', () => {
+    const board: CellState[][] = Array(12).fill(null).map(() => Array(12).fill('empty'));
+    const { container } = render(<GameBoard board={board} onCellClick={mockOnCellClick} />);
+
+    expect(screen.getAllByRole('button')).toHaveLength(144);
+    expect(container.firstChild).toHaveStyle({ gridTemplateColumns: 'repeat(12, 40px)' });
+  });
+
   it('calls onCellClick when a cell is clicked', () => {
     const board = createBoard();
     render(<GameBoard board={board} onCellClick={mockOnCellClick} />);
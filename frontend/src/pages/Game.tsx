@@ -3,11 +3,14 @@ import { useParams } from 'react-router-dom';
 import { useAuth } from '../hooks/useAuth';
 import { gameAPI, chatAPI } from '../services/api';
 import { websocketService } from '../services/websocket';
-import { Game as GameType, Ship, Move, ChatMessage, CellState } from '../types';
+import { Game as GameType, Ship, Move, ChatMessage, CellState, DEFAULT_BOARD_SIZE } from '../types';
 import GameBoard from '../components/GameBoard';
 import ShipPlacement from '../components/ShipPlacement';
 import Chat from '../components/Chat';
 
+const emptyBoard = (size: number): CellState[][] =>
+  Array(size).fill(null).map(() => Array(size).fill('empty'));
+
 const Game: React.FC = () => {
   const { gameId } = useParams<{ gameId: string }>();
   const { user } = useAuth();
@@ -29,6 +32,7 @@ const Game: React.FC = () => {
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState('');
   const [errorTimeout, setErrorTimeout] = useState<ReturnType<typeof setTimeout> | null>(null);
+  const boardSize = game?.board_size ?? DEFAULT_BOARD_SIZE;
 
   useEffect(() => {
     if (gameId) {
@@ -50,7 +54,7 @@ const Game: React.FC = () => {
     if (ships.length > 0 || moves.length > 0 || sunkShips.length > 0) {
       updateBoards();
     }
-  }, [ships, sunkShips, moves]);
+  }, [ships, sunkShips, moves, boardSize]);
 
   const setErrorWithTimeout = (message: string) => {
     // Clear existing timeout if any
@@ -97,7 +101,7 @@ const Game: React.FC = () => {
       }
       
       // Initialize boards
-      initializeBoards();
+      initializeBoards(gameData.board_size ?? DEFAULT_BOARD_SIZE);
       
       // Determine game phase
       if (gameData.status === 'waiting') {
@@ -192,23 +196,16 @@ const Game: React.FC = () => {
     });
   };
 
-  const initializeBoards = () => {
-    const emptyBoard: CellState[][] = Array(10).fill(null).map(() => 
-      Array(10).fill('empty')
-    );
-    setPlayerBoard([...emptyBoard]);
-    setOpponentBoard([...emptyBoard]);
+  const initializeBoards = (size: number) => {
+    setPlayerBoard(emptyBoard(size));
+    setOpponentBoard(emptyBoard(size));
   };
 
   const updateBoards = () => {
     // Update boards based on ships and moves
     // This is a simplified implementation
-    const newPlayerBoard: CellState[][] = Array(10).fill(null).map(() => 
-      Array(10).fill('empty')
-    );
-    const newOpponentBoard: CellState[][] = Array(10).fill(null).map(() => 
-      Array(10).fill('empty')
-    );
+    const newPlayerBoard = emptyBoard(boardSize);
+    const newOpponentBoard = emptyBoard(boardSize);
 
     console.log('Updating boards with', moves.length, 'moves for user', user?.id);
 
@@ -429,7 +426,7 @@ const Game: React.FC = () => {
         )}
 
         {gamePhase === 'placing' && (
-          <ShipPlacement onShipsPlaced={handleShipsPlaced} />
+          <ShipPlacement boardSize={boardSize} onShipsPlaced={handleShipsPlaced} />
         )}
 
         {gamePhase === 'waiting_for_opponent' && (
@@ -6,6 +6,9 @@ export interface User {
   updated_at: string;
 }
 
+// DEFAULT_BOARD_SIZE is the board size of games the server did not report one for.
+export const DEFAULT_BOARD_SIZE = 10;
+
 export interface Game {
   id: number;
   player1_id: number;
@@ -13,6 +16,7 @@ export interface Game {
   status: 'waiting' | 'active' | 'finished';
   current_turn?: number;
   winner_id?: number;
+  board_size?: number;
   created_at: string;
   updated_at: string;
 }
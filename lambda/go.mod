@@ -1,6 +1,6 @@
 module battleship-lambda
 
-go 1.21
+go 1.23.0
 
 require (
 	github.com/aws/aws-lambda-go v1.41.0
@@ -13,3 +13,21 @@ require (
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.39.0
 )
+
+require (
+	github.com/gabriel-vasile/mimetype v1.4.8 // indirect
+	github.com/gin-contrib/sse v1.0.0 // indirect
+	github.com/go-playground/locales v0.14.1 // indirect
+	github.com/go-playground/universal-translator v0.18.1 // indirect
+	github.com/go-playground/validator/v10 v10.26.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/leodido/go-urn v1.4.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
+	github.com/ugorji/go/codec v1.2.12 // indirect
+	golang.org/x/net v0.38.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
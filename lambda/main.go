@@ -6,25 +6,32 @@ import (
 	"os"
 
 	"battleship-go/internal/api"
+	"battleship-go/internal/cleanup"
 	"battleship-go/internal/config"
 	"battleship-go/internal/database"
+	"battleship-go/internal/game"
 	"battleship-go/internal/websocket"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/awslabs/aws-lambda-go-api-proxy/gin"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 var ginLambda *ginadapter.GinLambda
 
 func init() {
-	// Load configuration
-	cfg := config.Load()
+	// Load configuration. There are no flags, only CONFIG_FILE and the environment.
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal("Failed to load configuration:\n", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
 
 	// Initialize database
-	db, err := database.Initialize(cfg.DatabaseURL)
+	db, err := database.Initialize(cfg.Database.URL)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
@@ -38,21 +45,27 @@ func init() {
 	hub := websocket.NewHub()
 	go hub.Run()
 
+	// The cleanup service is not started: a frozen Lambda cannot run it on a
+	// schedule. Admins can still trigger runs, under the same advisory lock.
+	cleanupService := cleanup.NewCleanupService(db, game.NewGameService(db), cleanup.Options{
+		ActiveTimeout:  cfg.Cleanup.ActiveTimeout,
+		WaitingTimeout: cfg.Cleanup.WaitingTimeout,
+		ArchiveAfter:   cfg.Cleanup.ArchiveAfter,
+		WarnBefore:     cfg.Cleanup.WarnBefore,
+	})
+	cleanupService.SetLocker(cleanup.NewAdvisoryLock(db, cleanup.AdvisoryLockKey))
+
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.New()
-	router.Use(gin.Recovery())
-
-	// CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // Configure this properly for production
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		AllowCredentials: true,
-	}))
+	router, err := api.NewRouter(cfg)
+	if err != nil {
+		log.Fatal("Failed to setup router:", err)
+	}
 
 	// Initialize API routes
-	api.SetupRoutes(router, db, hub)
+	if err := api.SetupRoutes(router, db, hub, cleanupService, cfg); err != nil {
+		log.Fatal("Failed to setup routes:", err)
+	}
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {